@@ -18,6 +18,9 @@ func Self(baseURL pub.IRI) pub.Service {
 
 	oauth := *url
 	oauth.Path = path.Join(oauth.Path, "oauth/")
+
+	upload := *url
+	upload.Path = path.Join(upload.Path, "uploadMedia")
 	return pub.Service{
 		ID:           pub.ID(url.String()),
 		Type:         pub.ServiceType,
@@ -35,6 +38,7 @@ func Self(baseURL pub.IRI) pub.Service {
 		Endpoints: &pub.Endpoints{
 			OauthAuthorizationEndpoint: pub.IRI(fmt.Sprintf("%s/authorize", oauth.String())),
 			OauthTokenEndpoint:         pub.IRI(fmt.Sprintf("%s/token", oauth.String())),
+			UploadMedia:                pub.IRI(upload.String()),
 		},
 	}
 }
@@ -0,0 +1,52 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+// Actor is an ActivityPub actor with the properties used for moving accounts between servers.
+// It's used only for serving the actor's document, the stored and cached actors are plain pub.Actor items.
+type Actor struct {
+	*pub.Actor
+	// AlsoKnownAs are the other IRIs of the actor
+	AlsoKnownAs pub.IRIs `jsonld:"alsoKnownAs,omitempty"`
+	// MovedTo is the IRI of the actor this one moved to
+	MovedTo pub.IRI `jsonld:"movedTo,omitempty"`
+}
+
+// MarshalJSON adds the alsoKnownAs and movedTo properties to the JSON document of the embedded actor,
+// which would be left out by its own marshaler.
+func (a Actor) MarshalJSON() ([]byte, error) {
+	if a.Actor == nil {
+		return []byte("null"), nil
+	}
+	raw, err := a.Actor.MarshalJSON()
+	if err != nil || (len(a.AlsoKnownAs) == 0 && len(a.MovedTo) == 0) {
+		return raw, err
+	}
+	props := struct {
+		AlsoKnownAs []string `json:"alsoKnownAs,omitempty"`
+		MovedTo     string   `json:"movedTo,omitempty"`
+	}{MovedTo: a.MovedTo.String()}
+	for _, iri := range a.AlsoKnownAs {
+		props.AlsoKnownAs = append(props.AlsoKnownAs, iri.String())
+	}
+	extra, err := json.Marshal(props)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimRight(raw, " \n")
+	if len(raw) < 2 || raw[len(raw)-1] != '}' {
+		return raw, nil
+	}
+	buf := bytes.Buffer{}
+	buf.Write(raw[:len(raw)-1])
+	if len(bytes.TrimSpace(raw[1:len(raw)-1])) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.Write(extra[1:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/lifecycle"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager which provisions the certificate of the Host from the ACME directory,
+// and renews it before it expires. The certificates are cached in the storage folder.
+func acmeManager(conf config.Options) (*autocert.Manager, error) {
+	host := conf.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	cl := &acme.Client{DirectoryURL: conf.ACMEDirectory}
+	if len(conf.ACMERootCA) > 0 {
+		pem, err := ioutil.ReadFile(conf.ACMERootCA)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to load the ACME root certificates")
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.NotValidf("no valid certificates in %s", conf.ACMERootCA)
+		}
+		cl.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(conf.ACMECachePath()),
+		HostPolicy: autocert.HostWhitelist(host),
+		Email:      conf.ACMEEmail,
+		Client:     cl,
+	}, nil
+}
+
+// runACMEChallenges answers the ACME HTTP-01 challenges on the ACMEHTTPListen address, if it's configured,
+// and returns the function which stops it. The other requests are redirected to HTTPS.
+func (f *FedBOX) runACMEChallenges(m *autocert.Manager) (lifecycle.StopFn, error) {
+	if len(f.conf.ACMEHTTPListen) == 0 {
+		return nil, nil
+	}
+	l, err := listen(f.conf.ACMEHTTPListen, f.conf.SocketMode)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to listen for the ACME challenges on %s", f.conf.ACMEHTTPListen)
+	}
+	f.infFn("Answering ACME challenges on %s", f.conf.ACMEHTTPListen)
+	return f.serve("ACME challenge listener", &http.Server{Handler: m.HTTPHandler(nil)}, l), nil
+}
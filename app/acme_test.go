@@ -0,0 +1,294 @@
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+// acmeDirectory is a minimal ACME directory, which marks the orders as ready without any challenges,
+// and issues the certificates signed by its own CA
+type acmeDirectory struct {
+	*httptest.Server
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	ca     *x509.Certificate
+	mu     sync.Mutex
+	issued [][]byte
+	// validFor returns how long the n-th issued certificate is valid
+	validFor func(n int) time.Duration
+}
+
+func newACMEDirectory(t *testing.T, validFor func(int) time.Duration) *acmeDirectory {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate the CA key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "FedBOX test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Unable to create the CA certificate: %s", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	d := &acmeDirectory{t: t, key: key, ca: ca, validFor: validFor}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.serve))
+	return d
+}
+
+func (d *acmeDirectory) roots() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(d.ca)
+	return p
+}
+
+func (d *acmeDirectory) reply(w http.ResponseWriter, status int, location string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if len(location) > 0 {
+		w.Header().Set("Location", d.URL+location)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// payload returns the decoded payload of the JWS request, the signatures are not checked
+func (d *acmeDirectory) payload(r *http.Request, v interface{}) error {
+	jws := struct {
+		Payload string `json:"payload"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil || len(raw) == 0 {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (d *acmeDirectory) issue(csr *x509.CertificateRequest) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.issued) + 1
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(n + 1)),
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(d.validFor(n)),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, d.ca, csr.PublicKey, d.key)
+	if err != nil {
+		return 0, err
+	}
+	d.issued = append(d.issued, der)
+	return n, nil
+}
+
+func (d *acmeDirectory) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	var n int
+	switch {
+	case r.URL.Path == "/dir":
+		d.reply(w, http.StatusOK, "", map[string]string{
+			"newNonce":   d.URL + "/nonce",
+			"newAccount": d.URL + "/account",
+			"newOrder":   d.URL + "/order",
+			"revokeCert": d.URL + "/revoke",
+			"keyChange":  d.URL + "/key-change",
+		})
+	case r.URL.Path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/account":
+		d.reply(w, http.StatusCreated, "/account/1", map[string]string{"status": "valid"})
+	case r.URL.Path == "/order":
+		order := struct {
+			Identifiers []interface{} `json:"identifiers"`
+		}{}
+		if err := d.payload(r, &order); err != nil {
+			d.t.Errorf("Unable to decode the order: %s", err)
+		}
+		d.reply(w, http.StatusCreated, "/order/1", map[string]interface{}{
+			"status":         "ready",
+			"identifiers":    order.Identifiers,
+			"authorizations": []string{},
+			"finalize":       d.URL + "/finalize",
+		})
+	case r.URL.Path == "/finalize":
+		req := struct {
+			CSR string `json:"csr"`
+		}{}
+		if err := d.payload(r, &req); err != nil {
+			d.t.Errorf("Unable to decode the finalize request: %s", err)
+		}
+		raw, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(raw)
+		if err != nil {
+			d.t.Errorf("Unable to parse the certificate request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if n, err = d.issue(csr); err != nil {
+			d.t.Errorf("Unable to issue the certificate: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		d.reply(w, http.StatusOK, "/order/1", map[string]interface{}{
+			"status":      "valid",
+			"finalize":    d.URL + "/finalize",
+			"certificate": fmt.Sprintf("%s/cert/%d", d.URL, n),
+		})
+	case strings.HasPrefix(r.URL.Path, "/cert/"):
+		fmt.Sscanf(r.URL.Path, "/cert/%d", &n)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if n < 1 || n > len(d.issued) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: d.issued[n-1]})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: d.ca.Raw})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serverCert returns the certificate which the TLS server at addr presents for the host
+func serverCert(t *testing.T, addr, host string, roots *x509.CertPool) *x509.Certificate {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: host, RootCAs: roots})
+	if err != nil {
+		t.Fatalf("Unable to connect to the TLS server: %s", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestACMEManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Options{
+		Env:           "test",
+		Host:          "fedbox.example.com:4443",
+		StoragePath:   dir,
+		ACMEDirectory: "https://localhost:14000/dir",
+	}
+	m, err := acmeManager(conf)
+	if err != nil {
+		t.Fatalf("acmeManager() error: %s", err)
+	}
+	if m.Client.DirectoryURL != conf.ACMEDirectory {
+		t.Errorf("acmeManager() directory = %s, want %s", m.Client.DirectoryURL, conf.ACMEDirectory)
+	}
+	if err := m.HostPolicy(context.Background(), "fedbox.example.com"); err != nil {
+		t.Errorf("acmeManager() host policy rejects the configured host: %s", err)
+	}
+	if err := m.HostPolicy(context.Background(), "example.com"); err == nil {
+		t.Errorf("acmeManager() host policy accepts a host which is not configured")
+	}
+
+	conf.ACMERootCA = filepath.Join(dir, "missing.pem")
+	if _, err := acmeManager(conf); err == nil {
+		t.Errorf("acmeManager() expected an error for a missing root CA file")
+	}
+	invalid := filepath.Join(dir, "invalid.pem")
+	ioutil.WriteFile(invalid, []byte("not a certificate"), 0600)
+	conf.ACMERootCA = invalid
+	if _, err := acmeManager(conf); err == nil {
+		t.Errorf("acmeManager() expected an error for an invalid root CA file")
+	}
+}
+
+func TestACMEManager_Certificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// NOTE(marius): the first certificate expires before the renewal window of the manager, so it gets renewed
+	// right away, and the renewed one is valid long enough to not be renewed again
+	acmeDir := newACMEDirectory(t, func(n int) time.Duration {
+		if n == 1 {
+			return time.Hour
+		}
+		return 90 * 24 * time.Hour
+	})
+	defer acmeDir.Close()
+
+	conf := config.Options{
+		Env:           "test",
+		Host:          "fedbox.example.com",
+		StoragePath:   dir,
+		ACMEDirectory: acmeDir.URL + "/dir",
+	}
+	m, err := acmeManager(conf)
+	if err != nil {
+		t.Fatalf("acmeManager() error: %s", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{GetCertificate: m.GetCertificate}
+	srv.StartTLS()
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().String()
+	first := serverCert(t, addr, conf.Host, acmeDir.roots())
+	if first.SerialNumber.Int64() != 2 {
+		t.Errorf("expected the first certificate from the ACME directory, got serial %s", first.SerialNumber)
+	}
+	if err := first.VerifyHostname(conf.Host); err != nil {
+		t.Errorf("the certificate is not valid for %s: %s", conf.Host, err)
+	}
+
+	var renewed *x509.Certificate
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if c := serverCert(t, addr, conf.Host, acmeDir.roots()); c.SerialNumber.Cmp(first.SerialNumber) != 0 {
+			renewed = c
+			break
+		}
+	}
+	if renewed == nil {
+		t.Fatalf("the certificate which is about to expire was not renewed")
+	}
+	if !renewed.NotAfter.After(first.NotAfter) {
+		t.Errorf("expected the renewed certificate to expire after %s, got %s", first.NotAfter, renewed.NotAfter)
+	}
+
+	// the certificates are kept in the cache folder, for when the server restarts
+	if _, err := os.Stat(filepath.Join(conf.ACMECachePath(), conf.Host)); err != nil {
+		t.Errorf("the certificate was not cached: %s", err)
+	}
+}
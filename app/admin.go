@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/lifecycle"
+	"github.com/go-ap/fedbox/internal/metrics"
+	"github.com/go-chi/chi"
+)
+
+// AdminRoutes are the routes for the operators: the health checks, the metrics and the profiling endpoints.
+// They are served on the admin listener, when one is configured.
+func (f FedBOX) AdminRoutes() func(chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/healthz", f.HandleLiveness)
+		r.Get("/readyz", f.HandleReadiness)
+		r.Get("/metrics", metrics.Default.Handler().ServeHTTP)
+		pprofRoutes(r)
+
+		r.NotFound(errors.HandleError(errors.NotFoundf("invalid url")).ServeHTTP)
+	}
+}
+
+// publicAdminRoutes adds the health checks to the public router, and the admin routes when there's no admin listener.
+// They are restricted by MetricsAccess, the metrics are served only when they're enabled,
+// and the profiling endpoints are not served in production.
+func (f FedBOX) publicAdminRoutes(r chi.Router) {
+	// NOTE(marius): the health checks are always public, for the probes of the container orchestration
+	r.Get("/healthz", f.HandleLiveness)
+	r.Get("/readyz", f.HandleReadiness)
+	if len(f.conf.AdminListen) > 0 {
+		return
+	}
+	restricted := r.With(MetricsAccess(f.conf))
+	if f.conf.Metrics {
+		restricted.Get("/metrics", metrics.Default.Handler().ServeHTTP)
+	}
+	if f.conf.Env.IsProd() {
+		return
+	}
+	pprofRoutes(restricted)
+}
+
+func pprofRoutes(r chi.Router) {
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// NOTE(marius): the index serves also the named profiles, like /debug/pprof/heap
+	r.HandleFunc("/debug/pprof/*", pprof.Index)
+}
+
+// runAdmin starts serving the admin routes on the admin listener, if one is configured,
+// and returns the function which stops it
+func (f *FedBOX) runAdmin() (lifecycle.StopFn, error) {
+	if len(f.conf.AdminListen) == 0 {
+		return nil, nil
+	}
+	l, err := listen(f.conf.AdminListen, f.conf.SocketMode)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to listen on the admin address %s", f.conf.AdminListen)
+	}
+	f.infFn("Admin listening on %s", f.conf.AdminListen)
+	return f.serve("Admin listener", &http.Server{Handler: f.Admin}, l), nil
+}
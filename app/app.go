@@ -2,8 +2,14 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"strings"
 	"syscall"
+	"time"
 
 	w "git.sr.ht/~mariusor/wrapper"
 	pub "github.com/go-ap/activitypub"
@@ -12,13 +18,19 @@ import (
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/internal/cache"
 	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/lifecycle"
 	"github.com/go-ap/fedbox/internal/log"
+	"github.com/go-ap/fedbox/internal/media"
+	"github.com/go-ap/fedbox/internal/metrics"
+	"github.com/go-ap/fedbox/internal/webhooks"
 	"github.com/go-ap/handlers"
 	st "github.com/go-ap/storage"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/openshift/osin"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var Config config.Options
@@ -27,14 +39,21 @@ type LogFn func(string, ...interface{})
 
 type FedBOX struct {
 	conf         config.Options
+	live         *liveOptions
+	logger       logrus.FieldLogger
+	certs        *certLoader
 	R            chi.Router
+	Admin        chi.Router
 	ver          string
 	caches       cache.CanStore
 	Storage      st.Store
 	OAuthStorage osin.Storage
 	stopFn       func()
+	closing      chan struct{}
 	infFn        LogFn
 	errFn        LogFn
+	proxy        *mediaProxy
+	hooks        *webhooks.Dispatcher
 }
 
 var (
@@ -66,13 +85,17 @@ func New(l logrus.FieldLogger, ver string, conf config.Options, db st.Store, o o
 	app := FedBOX{
 		ver:          ver,
 		conf:         conf,
+		live:         &liveOptions{conf: conf},
+		logger:       l,
+		closing:      make(chan struct{}),
 		R:            chi.NewRouter(),
 		Storage:      db,
 		OAuthStorage: o,
 		infFn:        emptyLogFn,
 		errFn:        emptyLogFn,
-		caches:       cache.New(!(conf.Env.IsTest() || conf.Env.IsDev())),
+		caches:       cache.New(conf.Cache),
 	}
+	app.setCacheEnabled(conf.Cache)
 	if l != nil {
 		app.infFn = l.Infof
 		app.errFn = l.Errorf
@@ -81,22 +104,63 @@ func New(l logrus.FieldLogger, ver string, conf config.Options, db st.Store, o o
 	ap.Secure = conf.Secure
 	errors.IncludeBacktrace = conf.Env.IsDev() || conf.Env.IsTest()
 
+	app.hooks = webhooks.NewDispatcher(webhooks.New(conf.WebhooksPath()), app.errFn)
+	// NOTE(marius): the webhooks are the only deliveries FedBOX queues, the activities are not federated yet
+	metrics.NewGaugeFunc("fedbox_webhook_queue_depth", "The webhook deliveries in the queue, in flight or waiting to be retried", func() float64 {
+		return float64(app.hooks.Store().Len())
+	})
+	metrics.NewGaugeFunc("fedbox_webhook_requests_in_flight", "The requests to the webhooks in progress", func() float64 {
+		return float64(app.hooks.InFlight())
+	})
+
+	if conf.MediaProxy {
+		mediaCache := media.NewCache(conf.MediaCachePath(), conf.MediaCacheSize)
+		if key, err := mediaCache.Key(); err != nil {
+			app.errFn("Unable to load the media proxy key, the proxy is disabled: %s", err)
+		} else {
+			app.proxy = &mediaProxy{
+				baseIRI: pub.IRI(conf.BaseURL),
+				key:     key,
+				cache:   mediaCache,
+				maxSize: conf.MediaProxyMaxSize,
+				cl:      newPublicClient(proxyTimeOut, 5),
+			}
+		}
+	}
+
 	osin, err := auth.NewServer(app.OAuthStorage, l)
 	if err != nil {
 		l.Warn(err.Error())
 		return nil, err
 	}
+	// NOTE(marius): the public clients, which have no secret, send their id in the request parameters,
+	// and need to use PKCE for the authorization code grant
+	osin.Config.AllowClientSecretInParams = true
+	osin.Config.RequirePKCEForPublicClients = true
+	// NOTE(marius): the redirect URIs of the clients are separated by new lines, both by fedboxctl and by the registration
+	osin.Config.RedirectUriSeparator = "\n"
 
 	app.R.Use(Repo(db))
 	app.R.Use(middleware.RequestID)
+	// NOTE(marius): the real address of the client needs to be set before the access log uses it
+	app.R.Use(RealIP(conf.TrustedProxies))
 	app.R.Use(log.NewStructuredLogger(l))
+	app.R.Use(RequestMetrics)
+	app.publicAdminRoutes(app.R)
 	app.R.Route("/", app.Routes(Config.BaseURL, osin, l))
 
+	app.Admin = chi.NewRouter()
+	app.Admin.Route("/", app.AdminRoutes())
+
 	return &app, err
 }
 
+// Config returns the current configuration, including the changes applied by reloading it
 func (f FedBOX) Config() config.Options {
-	return f.conf
+	if f.live == nil {
+		return f.conf
+	}
+	return f.live.get()
 }
 
 // Stop
@@ -106,66 +170,140 @@ func (f *FedBOX) Stop() {
 	}
 }
 
+// exitForced is the exit status for stopping without waiting for the pending work to finish
+const exitForced = 2
+
 // Run is the wrapper for starting the web-server and handling signals
 func (f *FedBOX) Run() error {
-	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.TODO(), f.conf.TimeOut)
-	defer cancel()
-
 	// set local path typer to validate collections
 	handlers.Typer = pathTyper{}
 
+	srv := &http.Server{Handler: f.R}
 	listenOn := "HTTP"
-	if len(f.conf.CertPath) + len(f.conf.KeyPath) > 0 {
+	var acmeM *autocert.Manager
+	if f.conf.ACME {
 		listenOn = "HTTPS"
+		m, err := acmeManager(f.conf)
+		if err != nil {
+			return err
+		}
+		// NOTE(marius): the manager serves the renewed certificates without restarting the server
+		srv.TLSConfig = m.TLSConfig()
+		acmeM = m
+	} else if len(f.conf.CertPath)+len(f.conf.KeyPath) > 0 {
+		listenOn = "HTTPS"
+		f.certs = new(certLoader)
+		if err := f.certs.Load(f.conf.CertPath, f.conf.KeyPath); err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: f.certs.GetCertificate}
 	}
-	// Get start/stop functions for the http server
-	srvRun, srvStop := w.HttpServer(ctx, w.Handler(f.R), w.ListenOn(f.conf.Listen), w.SSL(f.conf.CertPath, f.conf.KeyPath))
-	f.infFn("Listening on %s %s", listenOn, f.conf.Listen)
-	f.stopFn = func() {
-		if err := srvStop(); err != nil {
-			f.errFn("Err: %s", err)
+	l, err := listen(f.conf.Listen, f.conf.SocketMode)
+	if err != nil {
+		return errors.Annotatef(err, "unable to listen on %s", f.conf.Listen)
+	}
+	srvRun := func() error {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(l, "", "")
+		} else {
+			err = srv.Serve(l)
 		}
-		if closable, ok :=  f.Storage.(io.Closer); ok {
-			if err := closable.Close(); err != nil {
-				f.errFn("Err: %s", err)
-			}
+		if err == http.ErrServerClosed {
+			return nil
 		}
+		return err
+	}
+
+	// NOTE(marius): the subsystems are stopped in the reverse order: first the streaming clients are disconnected,
+	// then the listeners wait for the requests in progress, the webhooks for their deliveries, and at the end
+	// the storage is closed.
+	lc := lifecycle.New(f.infFn)
+	lc.Register("storage", nil, func(context.Context) error {
+		if closable, ok := f.Storage.(io.Closer); ok {
+			return closable.Close()
+		}
+		return nil
+	})
+	lc.Register("OAuth2 storage", nil, func(context.Context) error {
 		f.OAuthStorage.Close()
+		return nil
+	})
+	stopHooks := make(chan struct{})
+	lc.Register("webhooks", func() error {
+		go f.hooks.Run(webhookRetryInterval, stopHooks)
+		return nil
+	}, func(ctx context.Context) error {
+		close(stopHooks)
+		return f.hooks.Drain(ctx)
+	})
+	if acmeM != nil {
+		lc.RegisterRun("ACME challenge listener", func() (lifecycle.StopFn, error) {
+			return f.runACMEChallenges(acmeM)
+		})
 	}
+	lc.RegisterRun("admin listener", f.runAdmin)
+	lc.Register("HTTP server", nil, stopServer(srv))
+	lc.Register("streams", nil, func(context.Context) error {
+		close(f.closing)
+		events.Default.Close()
+		return nil
+	})
+	if err := lc.Start(); err != nil {
+		l.Close()
+		return err
+	}
+	stop := func(timeOut time.Duration) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+		defer cancel()
+		if err := lc.Stop(ctx); err != nil {
+			f.errFn("%s", err)
+		}
+	}
+	f.stopFn = func() {
+		stop(f.live.get().TimeOut)
+	}
+	f.infFn("Listening on %s %s", listenOn, f.conf.Listen)
 
 	exit := w.RegisterSignalHandlers(w.SignalHandlers{
 		syscall.SIGHUP: func(_ chan int) {
 			f.infFn("SIGHUP received, reloading configuration")
+			restart, err := f.Reload()
+			if err != nil {
+				f.errFn("Unable to reload the configuration: %s", err)
+				return
+			}
+			if len(restart) > 0 {
+				f.infFn("The changes to %s require a restart", strings.Join(restart, ", "))
+			}
 		},
 		syscall.SIGINT: func(exit chan int) {
 			f.infFn("SIGINT received, stopping")
 			exit <- 0
 		},
 		syscall.SIGTERM: func(exit chan int) {
-			f.infFn("SIGITERM received, force stopping")
+			f.infFn("SIGTERM received, stopping")
 			exit <- 0
 		},
 		syscall.SIGQUIT: func(exit chan int) {
 			f.infFn("SIGQUIT received, force stopping with core-dump")
-			exit <- 0
+			pprof.Lookup("goroutine").WriteTo(os.Stderr, 2)
+			exit <- exitForced
 		},
 	}).Exec(func() error {
-		if err := srvRun(); err != nil{
+		if err := srvRun(); err != nil {
 			f.errFn("Error: %s", err)
 			return err
 		}
-		var err error
-		// Doesn't block if no connections, but will otherwise wait until the timeout deadline.
-		go func(e error) {
-			if err = srvStop(); err != nil {
-				f.errFn("Error: %s", err)
-			}
-		}(err)
-		return err
+		return nil
 	})
-	if exit == 0 {
-		f.infFn("Shutting down")
+	if exit == exitForced {
+		// NOTE(marius): the requests and the deliveries in progress are abandoned, but the storage is still closed
+		stop(0)
+		return nil
 	}
+	// NOTE(marius): wait for the pending work until the --wait deadline
+	f.infFn("Shutting down")
+	f.Stop()
 	return nil
 }
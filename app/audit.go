@@ -0,0 +1,132 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxFailedLogins is the number of failed login attempts after which the account is locked
+	maxFailedLogins = 5
+	// lockoutBase is how long the account is locked after maxFailedLogins attempts.
+	// It doubles for each failed attempt after that, up to lockoutMax.
+	lockoutBase = time.Minute
+	lockoutMax  = 24 * time.Hour
+	// auditLogSize is the number of events kept in the audit log of an account
+	auditLogSize = 100
+)
+
+// loginGuard keeps track of the failed logins of the accounts, locks them after too many,
+// and records the events of the audit log in their metadata
+type loginGuard struct {
+	repo   st.MetadataTyper
+	logger logrus.FieldLogger
+}
+
+func newLoginGuard(repo interface{}, l logrus.FieldLogger) *loginGuard {
+	g := loginGuard{logger: l}
+	if m, ok := repo.(st.MetadataTyper); ok {
+		g.repo = m
+	}
+	return &g
+}
+
+// lockoutDuration returns for how long the account is locked after the failed attempts
+func lockoutDuration(failed int) time.Duration {
+	if failed < maxFailedLogins {
+		return 0
+	}
+	d := lockoutBase
+	for i := maxFailedLogins; i < failed && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+func requestIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// update changes the metadata of the account with fn, and keeps only the latest events of the audit log
+func (g *loginGuard) update(iri pub.IRI, fn func(m *st.Metadata)) (*st.Metadata, error) {
+	if g == nil || g.repo == nil {
+		return nil, errors.NotImplementedf("the storage does not support metadata")
+	}
+	m, err := st.UpdateMetadata(g.repo, iri, func(m *st.Metadata) {
+		fn(m)
+		if len(m.Audit) > auditLogSize {
+			m.Audit = m.Audit[len(m.Audit)-auditLogSize:]
+		}
+	})
+	if err != nil && g.logger != nil {
+		g.logger.Errorf("Unable to update the metadata of %s: %s", iri, err)
+	}
+	return m, err
+}
+
+// Record adds the event to the audit log of the account
+func (g *loginGuard) Record(iri pub.IRI, r *http.Request, event, client, detail string) {
+	e := st.AuditEntry{Time: time.Now().UTC(), Event: event, IP: requestIP(r), Client: client, Detail: detail}
+	g.update(iri, func(m *st.Metadata) {
+		m.Audit = append(m.Audit, e)
+	})
+	if g != nil && g.logger != nil {
+		g.logger.WithFields(logrus.Fields{"actor": iri, "ip": e.IP, "client": client, "event": event}).Info("audit")
+	}
+}
+
+// Locked returns an error if the account is locked because of too many failed logins
+func (g *loginGuard) Locked(iri pub.IRI) error {
+	if g == nil || g.repo == nil {
+		return nil
+	}
+	m, err := g.repo.LoadMetadata(iri)
+	if err != nil || m == nil {
+		return nil
+	}
+	if until := m.LockedUntil; time.Now().Before(until) {
+		return errors.Unauthorizedf("Too many failed login attempts, try again after %s", until.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Failed records a failed login, and locks the account after too many of them
+func (g *loginGuard) Failed(iri pub.IRI, r *http.Request, client string) {
+	now := time.Now().UTC()
+	ip := requestIP(r)
+	g.update(iri, func(m *st.Metadata) {
+		m.FailedLogins++
+		m.Audit = append(m.Audit, st.AuditEntry{Time: now, Event: st.AuditLoginFailed, IP: ip, Client: client})
+		if d := lockoutDuration(m.FailedLogins); d > 0 {
+			m.LockedUntil = now.Add(d)
+			m.Audit = append(m.Audit, st.AuditEntry{Time: now, Event: st.AuditLocked, IP: ip, Detail: d.String()})
+			g.logger.Warnf("Locked %s for %s after %d failed login attempts", iri, d, m.FailedLogins)
+		}
+	})
+}
+
+// Succeeded records a successful login, and resets the failed attempts
+func (g *loginGuard) Succeeded(iri pub.IRI, r *http.Request, client string) {
+	e := st.AuditEntry{Time: time.Now().UTC(), Event: st.AuditLogin, IP: requestIP(r), Client: client}
+	g.update(iri, func(m *st.Metadata) {
+		m.FailedLogins = 0
+		m.LockedUntil = time.Time{}
+		m.Audit = append(m.Audit, e)
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/sirupsen/logrus"
+)
+
+type mockMetadata map[pub.IRI]st.Metadata
+
+func (m mockMetadata) LoadMetadata(iri pub.IRI) (*st.Metadata, error) {
+	meta, ok := m[iri]
+	if !ok {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+func (m mockMetadata) SaveMetadata(meta st.Metadata, iri pub.IRI) error {
+	m[iri] = meta
+	return nil
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failed int
+		want   time.Duration
+	}{
+		{failed: 0, want: 0},
+		{failed: maxFailedLogins - 1, want: 0},
+		{failed: maxFailedLogins, want: lockoutBase},
+		{failed: maxFailedLogins + 2, want: 4 * lockoutBase},
+		{failed: maxFailedLogins + 100, want: lockoutMax},
+	}
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.failed); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	iri := pub.IRI("https://example.com/actors/jdoe")
+	repo := mockMetadata{}
+	l := logrus.New()
+	l.SetLevel(logrus.PanicLevel)
+	g := newLoginGuard(repo, l)
+	r := httptest.NewRequest("POST", "/oauth/login", nil)
+
+	for i := 0; i < maxFailedLogins-1; i++ {
+		g.Failed(iri, r, "client")
+	}
+	if err := g.Locked(iri); err != nil {
+		t.Fatalf("expected the account not to be locked before %d failed attempts", maxFailedLogins)
+	}
+	g.Failed(iri, r, "client")
+	if err := g.Locked(iri); err == nil {
+		t.Fatalf("expected the account to be locked after %d failed attempts", maxFailedLogins)
+	}
+	m := repo[iri]
+	if last := m.Audit[len(m.Audit)-1]; last.Event != st.AuditLocked || last.IP != "192.0.2.1" {
+		t.Errorf("expected the lock to be in the audit log, got %#v", last)
+	}
+
+	g.Succeeded(iri, r, "client")
+	m = repo[iri]
+	if m.FailedLogins != 0 || !m.LockedUntil.IsZero() {
+		t.Errorf("expected the successful login to reset the failed attempts, got %d, %s", m.FailedLogins, m.LockedUntil)
+	}
+
+	for i := 0; i < auditLogSize; i++ {
+		g.Record(iri, r, st.AuditTokenGrant, "client", "password")
+	}
+	if m = repo[iri]; len(m.Audit) != auditLogSize {
+		t.Errorf("expected the audit log to keep %d events, got %d", auditLogSize, len(m.Audit))
+	}
+}
+
+// brokenMetadata fails to load the metadata, like a storage which can't be read
+type brokenMetadata struct {
+	mockMetadata
+}
+
+func (m brokenMetadata) LoadMetadata(iri pub.IRI) (*st.Metadata, error) {
+	return nil, errors.Newf("unable to read the storage")
+}
+
+func TestLoginGuard_LoadError(t *testing.T) {
+	iri := pub.IRI("https://fedbox.example.com/actors/jdoe")
+	repo := brokenMetadata{mockMetadata{iri: st.Metadata{Pw: []byte("hash"), TOTPSecret: "secret"}}}
+	l := logrus.New()
+	l.SetLevel(logrus.PanicLevel)
+	g := newLoginGuard(repo, l)
+
+	g.Failed(iri, httptest.NewRequest("POST", "/oauth/login", nil), "client")
+	if m := repo.mockMetadata[iri]; string(m.Pw) != "hash" || m.TOTPSecret != "secret" || m.FailedLogins != 0 {
+		t.Errorf("expected the metadata not to be saved when it can't be loaded, got %#v", m)
+	}
+}
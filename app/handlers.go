@@ -76,6 +76,9 @@ func orderItems(col pub.ItemCollection) pub.ItemCollection {
 // that return ActivityPub objects or activities
 func HandleCollection(fb FedBOX) h.CollectionHandlerFn {
 	return func(typ h.CollectionType, r *http.Request, repo storage.ReadStore) (pub.CollectionInterface, error) {
+		if err := requireScope(r, ScopeRead); err != nil {
+			return nil, err
+		}
 
 		f, err := ap.FromRequest(r, fb.Config().BaseURL)
 		if it := fb.caches.Get(ap.CacheKey(f)); !pub.IsNil(it) {
@@ -122,6 +125,9 @@ func HandleCollection(fb FedBOX) h.CollectionHandlerFn {
 				s.Clean()
 			}
 		}
+		if rw, ok := fb.proxy.rewrite(col).(pub.CollectionInterface); ok {
+			col = rw
+		}
 		if col.Count() > 0 {
 			fb.caches.Set(ap.CacheKey(f), col)
 		}
@@ -192,6 +198,9 @@ func HandleRequest(fb FedBOX) h.ActivityHandlerFn {
 		if it, err = pub.UnmarshalJSON(body); err != nil {
 			return it, http.StatusInternalServerError, errors.NewNotValid(err, "unable to unmarshal JSON request")
 		}
+		if err = requireScope(r, activityScopes(it, pub.IRI(reqURL(r)), pub.IRI(fb.Config().BaseURL))...); err != nil {
+			return it, http.StatusForbidden, err
+		}
 
 		baseIRI := pub.IRI(Config.BaseURL)
 		processor, validator, err := processing.New(
@@ -219,6 +228,9 @@ func HandleRequest(fb FedBOX) h.ActivityHandlerFn {
 		case h.Inbox:
 			validateFn = validator.ValidateServerActivity
 			processFn = func(a pub.Item) (pub.Item, error) {
+				if a.GetType() == pub.MoveType {
+					return processMove(repo, processor.ProcessClientActivity, fb.errFn, baseIRI, f.Authenticated, a)
+				}
 				return a, errors.NotImplementedf("S2S activities not implemented")
 			}
 		default:
@@ -241,6 +253,7 @@ func HandleRequest(fb FedBOX) h.ActivityHandlerFn {
 		if err != nil {
 			return it, http.StatusInternalServerError, err
 		}
+		fb.fireWebhooks(it)
 
 		status := http.StatusCreated
 		if it.GetType() == pub.DeleteType {
@@ -255,12 +268,15 @@ func HandleRequest(fb FedBOX) h.ActivityHandlerFn {
 // that returns a single ActivityPub object
 func HandleItem(fb FedBOX) h.ItemHandlerFn {
 	return func(r *http.Request, repo storage.ReadStore) (pub.Item, error) {
+		if err := requireScope(r, ScopeRead); err != nil {
+			return nil, err
+		}
 		collection := h.Typer.Type(r)
 
 		var items pub.ItemCollection
 		f, err := ap.FromRequest(r, fb.Config().BaseURL)
 		if it := fb.caches.Get(ap.CacheKey(f)); !pub.IsNil(it) {
-			return it, nil
+			return withAliases(repo, it), nil
 		}
 		where := ""
 		what := ""
@@ -329,8 +345,9 @@ func HandleItem(fb FedBOX) h.ItemHandlerFn {
 			return nil, errors.NotFoundf("%snot found", what)
 		}
 
+		it = fb.proxy.rewrite(it)
 		fb.caches.Set(ap.CacheKey(f), it)
-		return it, nil
+		return withAliases(repo, it), nil
 	}
 }
 
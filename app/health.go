@@ -0,0 +1,122 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/storage"
+	"github.com/openshift/osin"
+)
+
+// healthCheckTimeout is the time a readiness check has to answer before it's considered failed
+const healthCheckTimeout = 5 * time.Second
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type componentStatus struct {
+	Status string `json:"status"`
+}
+
+type healthStatus struct {
+	Status     string                     `json:"status"`
+	Version    string                     `json:"version,omitempty"`
+	Components map[string]componentStatus `json:"components,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, s healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if s.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+// HandleLiveness answers as long as the process is able to serve requests
+func (f FedBOX) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthStatus{Status: statusOK, Version: f.ver})
+}
+
+// HandleReadiness checks that the storage backends answer, and that the service actor exists.
+// The response is public, so it has only the status of the checks, the errors are logged.
+func (f FedBOX) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	s := readiness(pub.IRI(f.conf.BaseURL), f.Storage, f.OAuthStorage, f.errFn)
+	s.Version = f.ver
+	writeHealth(w, s)
+}
+
+// withTimeout runs the check, failing it if it doesn't return in healthCheckTimeout
+func withTimeout(check func() error) error {
+	res := make(chan error, 1)
+	go func() {
+		res <- check()
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-time.After(healthCheckTimeout):
+		return errors.Newf("timed out after %s", healthCheckTimeout)
+	}
+}
+
+func readiness(baseIRI pub.IRI, repo storage.ReadStore, o osin.Storage, errFn LogFn) healthStatus {
+	s := healthStatus{Status: statusOK, Components: make(map[string]componentStatus)}
+	set := func(name string, err error) {
+		if err != nil {
+			errFn("Readiness check %s failed: %s", name, err)
+			s.Status = statusFail
+			s.Components[name] = componentStatus{Status: statusFail}
+			return
+		}
+		s.Components[name] = componentStatus{Status: statusOK}
+	}
+
+	var service pub.Item
+	if repo == nil {
+		set("storage", errors.Newf("storage is not configured"))
+	} else {
+		set("storage", withTimeout(func() error {
+			var err error
+			service, err = repo.Load(baseIRI)
+			if errors.IsNotFound(err) {
+				// NOTE(marius): the storage answered, the missing service is reported separately
+				return nil
+			}
+			return err
+		}))
+	}
+	if s.Components["storage"].Status == statusOK {
+		if pub.IsItemCollection(service) {
+			pub.OnCollectionIntf(service, func(col pub.CollectionInterface) error {
+				service = col.Collection().First()
+				return nil
+			})
+		}
+		if pub.IsNil(service) || !service.GetLink().Equals(baseIRI, false) {
+			set("service", errors.NotFoundf("service actor %s not found, the storage might need to be bootstrapped", baseIRI))
+		} else {
+			set("service", nil)
+		}
+	}
+
+	if o == nil {
+		set("oauth", errors.Newf("oauth storage is not configured"))
+	} else {
+		set("oauth", withTimeout(func() error {
+			// NOTE(marius): loading a client which doesn't exist is enough for checking that the storage answers
+			if _, err := o.GetClient("-"); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		}))
+	}
+	return s
+}
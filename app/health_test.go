@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+	authboltdb "github.com/go-ap/auth/boltdb"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+type mockLoader map[pub.IRI]pub.Item
+
+func (m mockLoader) Load(i pub.IRI) (pub.Item, error) {
+	if it, ok := m[i]; ok {
+		return it, nil
+	}
+	return nil, errors.NotFoundf("%s not found", i)
+}
+
+type mockOAuth struct {
+	osin.Storage
+	err error
+}
+
+func (m mockOAuth) GetClient(id string) (osin.Client, error) {
+	return nil, m.err
+}
+
+func TestReadiness(t *testing.T) {
+	baseIRI := pub.IRI("https://example.com")
+	service := &pub.Service{ID: baseIRI, Type: pub.ServiceType}
+
+	tests := []struct {
+		name       string
+		repo       mockLoader
+		oauth      osin.Storage
+		wantStatus string
+		components map[string]string
+	}{
+		{
+			name:       "ready",
+			repo:       mockLoader{baseIRI: service},
+			oauth:      mockOAuth{err: errors.NotFoundf("client not found")},
+			wantStatus: statusOK,
+			components: map[string]string{"storage": statusOK, "service": statusOK, "oauth": statusOK},
+		},
+		{
+			name:       "missing service",
+			repo:       mockLoader{},
+			oauth:      mockOAuth{err: errors.NotFoundf("client not found")},
+			wantStatus: statusFail,
+			components: map[string]string{"storage": statusOK, "service": statusFail, "oauth": statusOK},
+		},
+		{
+			name:       "oauth error",
+			repo:       mockLoader{baseIRI: service},
+			oauth:      mockOAuth{err: errors.Newf("database is closed")},
+			wantStatus: statusFail,
+			components: map[string]string{"storage": statusOK, "service": statusOK, "oauth": statusFail},
+		},
+		{
+			name:       "no oauth storage",
+			repo:       mockLoader{baseIRI: service},
+			wantStatus: statusFail,
+			components: map[string]string{"storage": statusOK, "service": statusOK, "oauth": statusFail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := readiness(baseIRI, tt.repo, tt.oauth, t.Logf)
+			if s.Status != tt.wantStatus {
+				t.Errorf("readiness() status = %s, want %s", s.Status, tt.wantStatus)
+			}
+			for name, want := range tt.components {
+				if got := s.Components[name].Status; got != want {
+					t.Errorf("readiness() %s status = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessBoltDBOAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-readiness")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseIRI := pub.IRI("https://example.com")
+	repo := mockLoader{baseIRI: &pub.Service{ID: baseIRI, Type: pub.ServiceType}}
+
+	o := authboltdb.New(authboltdb.Config{
+		Path:       filepath.Join(dir, "oauth.bdb"),
+		BucketName: "example.com",
+		LogFn:      InfoLogFn(nil),
+		ErrFn:      ErrLogFn(nil),
+	})
+	defer o.Close()
+	cl := osin.DefaultClient{Id: "test", Secret: "secret", RedirectUri: "https://example.com/callback"}
+	if err = o.CreateClient(&cl); err != nil {
+		t.Fatalf("Unable to save client: %s", err)
+	}
+	if s := readiness(baseIRI, repo, o, t.Logf); s.Components["oauth"].Status != statusOK {
+		t.Errorf("readiness() oauth status = %s, want %s", s.Components["oauth"].Status, statusOK)
+	}
+
+	broken := authboltdb.New(authboltdb.Config{
+		Path:       filepath.Join(dir, "missing", "oauth.bdb"),
+		BucketName: "example.com",
+		LogFn:      InfoLogFn(nil),
+		ErrFn:      ErrLogFn(nil),
+	})
+	logged := make([]string, 0)
+	logFn := func(s string, p ...interface{}) {
+		logged = append(logged, fmt.Sprintf(s, p...))
+	}
+	s := readiness(baseIRI, repo, broken, logFn)
+	if s.Components["oauth"].Status != statusFail {
+		t.Errorf("readiness() oauth status = %s, want %s", s.Components["oauth"].Status, statusFail)
+	}
+	if len(logged) == 0 || !strings.Contains(logged[0], "oauth") {
+		t.Errorf("readiness() didn't log the error of the oauth check")
+	}
+}
+
+func TestWriteHealth(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeHealth(w, healthStatus{Status: statusFail})
+	if w.Code != 503 {
+		t.Errorf("writeHealth() code = %d, want 503", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("writeHealth() Content-Type = %s, want application/json", ct)
+	}
+}
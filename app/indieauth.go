@@ -0,0 +1,503 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/openshift/osin"
+	"golang.org/x/net/html"
+)
+
+const (
+	// clientInfoTimeOut is how long we wait for the client information of the IndieAuth clients
+	clientInfoTimeOut = 10 * time.Second
+	// maxClientInfoSize is the maximum size, in bytes, of the client information we parse
+	maxClientInfoSize = 1 << 20
+	// maxClientInfoRedirects is the number of redirects followed when fetching the client information
+	maxClientInfoRedirects = 5
+
+	// metadataPath is where the authorization server metadata document, from RFC 8414, is served
+	metadataPath = ".well-known/oauth-authorization-server"
+)
+
+// canonicalURL returns the URL in the canonical form of the IndieAuth spec: the http scheme is added
+// when it's missing, the scheme and the host are lower case, and the empty path becomes "/"
+func canonicalURL(s string) (*url.URL, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil, errors.NotValidf("empty URL")
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "invalid URL %s", s)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+func hasDotSegments(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// validateClientID checks the rules of the IndieAuth spec for the client identifiers: http or https URLs,
+// with a path without dot segments, without a fragment or user info, and with a domain name or a loopback address
+func validateClientID(u *url.URL) error {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.NotValidf("client_id must be a http or https URL")
+	}
+	if len(u.Host) == 0 || len(u.Fragment) > 0 || u.User != nil || hasDotSegments(u.Path) {
+		return errors.NotValidf("invalid client_id %s", u)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !ip.IsLoopback() {
+		return errors.NotValidf("client_id can't be an IP address, except the loopback ones")
+	}
+	return nil
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && strings.EqualFold(a.Host, b.Host)
+}
+
+// clientInfo is the information published by an IndieAuth client at its client_id
+type clientInfo struct {
+	ID           string
+	Name         string
+	URL          string
+	Logo         string
+	RedirectURIs []string
+}
+
+// apply sets the name and the icon of the client on its Application actor
+func (c *clientInfo) apply(p *pub.Actor) {
+	if c == nil {
+		return
+	}
+	if len(c.Name) > 0 {
+		p.Name = pub.NaturalLanguageValues{{pub.NilLangRef, pub.Content(c.Name)}}
+	}
+	if len(c.Logo) > 0 {
+		p.Icon = pub.IRI(c.Logo)
+	}
+}
+
+// clientDocument is the JSON client metadata document
+type clientDocument struct {
+	ClientID     string   `json:"client_id"`
+	ClientName   string   `json:"client_name"`
+	ClientURI    string   `json:"client_uri"`
+	LogoURI      string   `json:"logo_uri"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if len(ref) == 0 {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(u).String()
+}
+
+// linkRels returns the targets of the Link headers with the rel
+func linkRels(headers []string, rel string) []string {
+	targets := make([]string, 0)
+	for _, hdr := range headers {
+		for _, link := range strings.Split(hdr, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, r := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if strings.EqualFold(r, rel) {
+						targets = append(targets, strings.Trim(target, "<>"))
+					}
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func htmlHasToken(n *html.Node, key string, tokens ...string) bool {
+	for _, v := range strings.Fields(htmlAttr(n, key)) {
+		for _, t := range tokens {
+			if v == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func htmlText(n *html.Node) string {
+	b := strings.Builder{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func htmlWalk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		htmlWalk(c, fn)
+	}
+}
+
+// htmlURL returns the URL property of the microformats element
+func htmlURL(n *html.Node, base *url.URL) string {
+	switch n.Data {
+	case "a", "area", "link":
+		return resolve(base, htmlAttr(n, "href"))
+	case "img", "audio", "video", "source":
+		return resolve(base, htmlAttr(n, "src"))
+	}
+	return resolve(base, htmlText(n))
+}
+
+// parseHApp loads the name, the URL and the logo of the h-app microformats element,
+// with the implied name and URL of the element itself when the properties are missing
+func parseHApp(app *html.Node, base *url.URL, info *clientInfo) {
+	htmlWalk(app, func(n *html.Node) {
+		if n == app {
+			return
+		}
+		if len(info.Name) == 0 && htmlHasToken(n, "class", "p-name") {
+			info.Name = htmlText(n)
+			if len(info.Name) == 0 && n.Data == "img" {
+				info.Name = htmlAttr(n, "alt")
+			}
+		}
+		if len(info.URL) == 0 && htmlHasToken(n, "class", "u-url") {
+			info.URL = htmlURL(n, base)
+		}
+		if len(info.Logo) == 0 && htmlHasToken(n, "class", "u-logo", "u-photo") {
+			info.Logo = htmlURL(n, base)
+		}
+	})
+	if len(info.Name) == 0 {
+		info.Name = htmlText(app)
+	}
+	if len(info.URL) == 0 && (app.Data == "a" || app.Data == "area") {
+		info.URL = resolve(base, htmlAttr(app, "href"))
+	}
+}
+
+// parseClientHTML loads the client information from the h-app or h-x-app microformats,
+// and the redirect URIs from the link and a elements with rel="redirect_uri"
+func parseClientHTML(r io.Reader, base *url.URL, info *clientInfo) error {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return errors.NewNotValid(err, "unable to parse the client page")
+	}
+	var app *html.Node
+	htmlWalk(doc, func(n *html.Node) {
+		if app == nil && htmlHasToken(n, "class", "h-app", "h-x-app") {
+			app = n
+		}
+		if (n.Data == "link" || n.Data == "a") && htmlHasToken(n, "rel", "redirect_uri") {
+			if u := resolve(base, htmlAttr(n, "href")); len(u) > 0 {
+				info.RedirectURIs = append(info.RedirectURIs, u)
+			}
+		}
+	})
+	if app != nil {
+		parseHApp(app, base, info)
+	}
+	return nil
+}
+
+func parseClientJSON(r io.Reader, info *clientInfo) error {
+	doc := clientDocument{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return errors.NewNotValid(err, "unable to parse the client metadata")
+	}
+	if doc.ClientID != info.ID {
+		return errors.NotValidf("the client metadata is for %s instead of %s", doc.ClientID, info.ID)
+	}
+	info.Name = doc.ClientName
+	info.URL = doc.ClientURI
+	info.Logo = doc.LogoURI
+	info.RedirectURIs = append(info.RedirectURIs, doc.RedirectURIs...)
+	return nil
+}
+
+// fetchClientInfo loads the client information published at the client_id,
+// either as a JSON metadata document, or as a HTML page with a h-app
+func fetchClientInfo(cl *http.Client, clientID string) (*clientInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, clientID, nil)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "invalid client_id")
+	}
+	req.Header.Set("Accept", "application/json, text/html;q=0.9")
+	res, err := cl.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load the client information")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Newf("unable to load the client information: %s", res.Status)
+	}
+
+	info := clientInfo{ID: clientID}
+	base := res.Request.URL
+	body := io.LimitReader(res.Body, maxClientInfoSize)
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	switch {
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		err = parseClientJSON(body, &info)
+	case mt == "text/html" || mt == "application/xhtml+xml":
+		err = parseClientHTML(body, base, &info)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range linkRels(res.Header.Values("Link"), "redirect_uri") {
+		if u = resolve(base, u); len(u) > 0 {
+			info.RedirectURIs = append(info.RedirectURIs, u)
+		}
+	}
+	return &info, nil
+}
+
+// verifyRedirectURI accepts the redirect URIs on the same scheme, host and port as the client_id,
+// and the ones published in the client information
+func verifyRedirectURI(clientID *url.URL, redirect string, info *clientInfo) error {
+	u, err := url.Parse(redirect)
+	if err != nil || !u.IsAbs() {
+		return errors.NotValidf("invalid redirect_uri %s", redirect)
+	}
+	if sameOrigin(clientID, u) {
+		return nil
+	}
+	if info != nil {
+		for _, uri := range info.RedirectURIs {
+			if uri == redirect {
+				return nil
+			}
+		}
+	}
+	return errors.NotValidf("the redirect_uri %s is not published by the client %s", redirect, clientID)
+}
+
+// newClientInfoFetcher returns the HTTP client which loads the client information.
+// As the client_id is chosen by whoever starts the authorization, it refuses to connect to the internal addresses.
+func newClientInfoFetcher() *http.Client {
+	return newPublicClient(clientInfoTimeOut, maxClientInfoRedirects)
+}
+
+// profileURL returns the profile URL of the actor, which is the me value of the IndieAuth responses
+func profileURL(p *pub.Actor) string {
+	if p.URL != nil && len(p.URL.GetLink()) > 0 {
+		return p.URL.GetLink().String()
+	}
+	return p.GetLink().String()
+}
+
+type profile struct {
+	Name  string `json:"name,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Photo string `json:"photo,omitempty"`
+}
+
+func actorProfile(p *pub.Actor) profile {
+	pr := profile{URL: profileURL(p)}
+	if len(p.Name) > 0 {
+		pr.Name = p.Name.First().String()
+	} else if len(p.PreferredUsername) > 0 {
+		pr.Name = p.PreferredUsername.First().String()
+	}
+	if p.Icon != nil {
+		pr.Photo = p.Icon.GetLink().String()
+	}
+	return pr
+}
+
+func scopeContains(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// addProfile adds the me value, and the profile when it's in the scope, to the output of the OAuth2 response
+func (h *oauthHandler) addProfile(resp *osin.Response, userData interface{}, scope string) {
+	iri, err := assertToBytes(userData)
+	if err != nil || len(iri) == 0 {
+		return
+	}
+	it, err := h.loader.Load(pub.IRI(iri))
+	if it = firstItem(it); err != nil || pub.IsNil(it) {
+		return
+	}
+	pub.OnActor(it, func(p *pub.Actor) error {
+		resp.Output["me"] = profileURL(p)
+		if scopeContains(scope, ScopeProfile) {
+			resp.Output["profile"] = actorProfile(p)
+		}
+		return nil
+	})
+}
+
+// redeemProfile serves the POST requests to the authorization endpoint which exchange the authorization code
+// for the profile URL of the actor, without an access token, as the IndieAuth spec describes
+func (h *oauthHandler) redeemProfile(w http.ResponseWriter, r *http.Request) {
+	s := h.ia.os
+	resp := s.NewResponse()
+	defer resp.Close()
+
+	if h.ia.IsValidRequest(r) {
+		if err := h.ia.mapClientID(r); err != nil {
+			resp.SetError(osin.E_INVALID_CLIENT, err.Error())
+			redirectOrOutput(resp, w, r)
+			return
+		}
+	}
+	publicClientAuth(r)
+	ar := s.HandleAccessRequest(resp, r)
+	if ar == nil {
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	if ar.Type != osin.AUTHORIZATION_CODE {
+		resp.SetError(osin.E_UNSUPPORTED_GRANT_TYPE, "")
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	// NOTE(marius): no token is issued, so the code is used up here
+	if err := s.Storage.RemoveAuthorize(ar.Code); err != nil {
+		h.logger.Errorf("Unable to remove the authorization code: %s", err)
+	}
+	h.addProfile(resp, ar.UserData, ar.Scope)
+	if _, ok := resp.Output["me"]; !ok {
+		resp.SetError(osin.E_INVALID_GRANT, "")
+	}
+	redirectOrOutput(resp, w, r)
+}
+
+// authServerMetadata is the authorization server metadata document, from RFC 8414
+type authServerMetadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported  []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported     []string `json:"revocation_endpoint_auth_methods_supported"`
+	AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// HandleMetadata serves the authorization server metadata document, which the IndieAuth clients
+// discover from the indieauth-metadata links of the profile URLs
+func (h *oauthHandler) HandleMetadata(w http.ResponseWriter, r *http.Request) {
+	base := pub.IRI(h.baseURL)
+	authMethods := []string{authMethodBasic, authMethodPost}
+	m := authServerMetadata{
+		Issuer:                 h.baseURL,
+		AuthorizationEndpoint:  base.AddPath("oauth/authorize").String(),
+		TokenEndpoint:          base.AddPath("oauth/token").String(),
+		IntrospectionEndpoint:  base.AddPath("oauth/introspect").String(),
+		RevocationEndpoint:     base.AddPath("oauth/revoke").String(),
+		ScopesSupported:        []string{ScopeRead, ScopeWrite, ScopeFollow, ScopeProfile},
+		ResponseTypesSupported: []string{string(osin.CODE)},
+		// NOTE(marius): the password grant still works for the clients added with fedboxctl,
+		// but it's not advertised, as the new clients should use the authorization code
+		GrantTypesSupported: []string{string(osin.AUTHORIZATION_CODE), string(osin.REFRESH_TOKEN)},
+		// NOTE(marius): the authorization endpoint refuses the plain challenges, which osin would accept
+		CodeChallengeMethodsSupported:              []string{"S256"},
+		IntrospectionEndpointAuthMethodsSupported:  authMethods,
+		RevocationEndpointAuthMethodsSupported:     authMethods,
+		AuthorizationResponseIssParameterSupported: true,
+	}
+	if h.registration != config.RegistrationClosed && len(h.registration) > 0 {
+		m.RegistrationEndpoint = base.AddPath("oauth/register").String()
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// isProfileDocument returns true for the paths of the actors, which are the profile URLs of the IndieAuth users,
+// and for the root path, which is the service actor
+func isProfileDocument(p string) bool {
+	p = strings.Trim(p, "/")
+	if len(p) == 0 {
+		return true
+	}
+	col, id := path.Split(p)
+	return strings.Trim(col, "/") == string(ap.ActorsType) && len(id) > 0
+}
+
+// IndieAuthLinks adds to the responses for the actors the links from which the IndieAuth clients discover
+// the authorization server of the profile URLs
+func IndieAuthLinks(baseURL string) func(http.Handler) http.Handler {
+	base := pub.IRI(baseURL)
+	links := strings.Join([]string{
+		"<" + base.AddPath(metadataPath).String() + `>; rel="indieauth-metadata"`,
+		"<" + base.AddPath("oauth/authorize").String() + `>; rel="authorization_endpoint"`,
+		"<" + base.AddPath("oauth/token").String() + `>; rel="token_endpoint"`,
+	}, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && isProfileDocument(r.URL.Path) {
+				w.Header().Add("Link", links)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := map[string]string{
+		"example.com":                 "http://example.com/",
+		"https://Example.COM":         "https://example.com/",
+		"https://example.com/app?x=1": "https://example.com/app?x=1",
+		" http://localhost:3000/ ":    "http://localhost:3000/",
+	}
+	for in, want := range tests {
+		u, err := canonicalURL(in)
+		if err != nil {
+			t.Errorf("canonicalURL(%q) error = %s", in, err)
+			continue
+		}
+		if u.String() != want {
+			t.Errorf("canonicalURL(%q) = %s, want %s", in, u, want)
+		}
+	}
+	if _, err := canonicalURL(""); err == nil {
+		t.Errorf("expected an error for the empty URL")
+	}
+}
+
+func TestValidateClientID(t *testing.T) {
+	tests := map[string]bool{
+		"https://app.example.com/":         true,
+		"https://app.example.com/app/":     true,
+		"http://localhost:3000/":           true,
+		"http://127.0.0.1/":                true,
+		"mailto:app@example.com":           false,
+		"https://app.example.com/a/../b":   false,
+		"https://app.example.com/#frag":    false,
+		"https://user:pw@app.example.com/": false,
+		"https://10.0.0.1/":                false,
+	}
+	for id, want := range tests {
+		u, err := url.Parse(id)
+		if err != nil {
+			t.Fatalf("invalid test URL %s: %s", id, err)
+		}
+		if got := validateClientID(u) == nil; got != want {
+			t.Errorf("validateClientID(%q) valid = %t, want %t", id, got, want)
+		}
+	}
+}
+
+func TestLinkRels(t *testing.T) {
+	headers := []string{
+		`<https://app.example.com/cb>; rel="redirect_uri", <https://app.example.com/>; rel="home"`,
+		`</other>; rel="me redirect_uri"`,
+	}
+	got := linkRels(headers, "redirect_uri")
+	if len(got) != 2 || got[0] != "https://app.example.com/cb" || got[1] != "/other" {
+		t.Errorf("linkRels() = %v", got)
+	}
+}
+
+const hAppPage = `<!doctype html>
+<html>
+<head>
+	<link rel="redirect_uri" href="/callback">
+</head>
+<body>
+	<div class="h-app">
+		<img class="u-logo" src="/logo.png" alt="">
+		<a class="u-url p-name" href="/">Example App</a>
+	</div>
+</body>
+</html>`
+
+func TestFetchClientInfo_HApp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Link", `<https://redirect.example.com/cb>; rel="redirect_uri"`)
+		fmt.Fprint(w, hAppPage)
+	}))
+	defer srv.Close()
+
+	info, err := fetchClientInfo(srv.Client(), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("fetchClientInfo() error = %s", err)
+	}
+	if info.Name != "Example App" {
+		t.Errorf("Name = %q, want %q", info.Name, "Example App")
+	}
+	if info.URL != srv.URL+"/" {
+		t.Errorf("URL = %q, want %q", info.URL, srv.URL+"/")
+	}
+	if info.Logo != srv.URL+"/logo.png" {
+		t.Errorf("Logo = %q, want %q", info.Logo, srv.URL+"/logo.png")
+	}
+	want := []string{srv.URL + "/callback", "https://redirect.example.com/cb"}
+	if len(info.RedirectURIs) != len(want) {
+		t.Fatalf("RedirectURIs = %v, want %v", info.RedirectURIs, want)
+	}
+	for i, u := range want {
+		if info.RedirectURIs[i] != u {
+			t.Errorf("RedirectURIs[%d] = %q, want %q", i, info.RedirectURIs[i], u)
+		}
+	}
+}
+
+func TestFetchClientInfo_JSON(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"client_id":%q,"client_name":"JSON App","client_uri":%q,"logo_uri":%q,"redirect_uris":["https://other.example.com/cb"]}`,
+			srv.URL+"/app", srv.URL+"/", srv.URL+"/logo.png")
+	}))
+	defer srv.Close()
+
+	info, err := fetchClientInfo(srv.Client(), srv.URL+"/app")
+	if err != nil {
+		t.Fatalf("fetchClientInfo() error = %s", err)
+	}
+	if info.Name != "JSON App" || info.Logo != srv.URL+"/logo.png" {
+		t.Errorf("unexpected client information %#v", info)
+	}
+	if len(info.RedirectURIs) != 1 || info.RedirectURIs[0] != "https://other.example.com/cb" {
+		t.Errorf("RedirectURIs = %v", info.RedirectURIs)
+	}
+
+	if _, err := fetchClientInfo(srv.Client(), srv.URL+"/other"); err == nil {
+		t.Errorf("expected an error when the client_id of the document doesn't match")
+	}
+}
+
+func TestVerifyRedirectURI(t *testing.T) {
+	clientID, _ := url.Parse("https://app.example.com/")
+	info := &clientInfo{RedirectURIs: []string{"https://redirect.example.com/cb"}}
+
+	tests := map[string]bool{
+		"https://app.example.com/callback":  true,
+		"https://redirect.example.com/cb":   true,
+		"https://redirect.example.com/cb2":  false,
+		"http://app.example.com/callback":   false,
+		"https://app.example.com:8443/cb":   false,
+		"https://evil.example.com/callback": false,
+	}
+	for redirect, want := range tests {
+		if got := verifyRedirectURI(clientID, redirect, info) == nil; got != want {
+			t.Errorf("verifyRedirectURI(%q) valid = %t, want %t", redirect, got, want)
+		}
+	}
+	if err := verifyRedirectURI(clientID, "https://redirect.example.com/cb", nil); err == nil {
+		t.Errorf("expected the cross origin redirect to be refused without the client information")
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34": true,
+		"2606:4700::1":  true,
+		"127.0.0.1":     false,
+		"::1":           false,
+		"10.1.2.3":      false,
+		"172.16.0.1":    false,
+		"192.168.1.1":   false,
+		"169.254.1.1":   false,
+		"fd00::1":       false,
+		"0.0.0.0":       false,
+	}
+	for ip, want := range tests {
+		if got := publicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("publicIP(%s) = %t, want %t", ip, got, want)
+		}
+	}
+}
+
+func TestNewClientInfoFetcher_RefusesInternal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, hAppPage)
+	}))
+	defer srv.Close()
+
+	if _, err := fetchClientInfo(newClientInfoFetcher(), srv.URL+"/"); err == nil {
+		t.Errorf("expected the client information of a loopback address to be refused")
+	}
+}
+
+func TestIndieAuthLinks(t *testing.T) {
+	handler := IndieAuthLinks("https://fedbox.example.com")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := map[string]bool{
+		"/":                    true,
+		"/actors/jdoe":         true,
+		"/actors/jdoe/":        true,
+		"/actors":              false,
+		"/actors/jdoe/outbox":  false,
+		"/objects/1":           false,
+		"/media/abcd":          false,
+		"/actors/jdoe/inbox/1": false,
+	}
+	for p, want := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if got := len(w.Header().Get("Link")) > 0; got != want {
+			t.Errorf("IndieAuthLinks() for %s added the links: %t, want %t", p, got, want)
+		}
+	}
+}
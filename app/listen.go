@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/lifecycle"
+)
+
+const (
+	// unixSocketPrefix marks the addresses which are unix sockets, for example: unix:/run/fedbox/fedbox.sock
+	unixSocketPrefix = "unix:"
+	// systemdPrefix marks the addresses which are sockets passed by systemd socket activation.
+	// "systemd" uses the first of them, and "systemd:name" the one named in the FileDescriptorName of the unit.
+	systemdPrefix = "systemd"
+
+	// listenFdsStart is the first file descriptor passed by systemd
+	listenFdsStart = 3
+)
+
+type namedListener struct {
+	name string
+	l    net.Listener
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdSockets   []namedListener
+	systemdSocketErr error
+)
+
+// listenFdNames returns the names of the file descriptors passed by systemd, from the values of
+// the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment variables
+func listenFdNames(pid int, listenPid, listenFds, listenFdNames string) ([]string, error) {
+	if len(listenPid) == 0 || len(listenFds) == 0 {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(listenPid); err != nil || p != pid {
+		// NOTE(marius): the sockets were passed to a different process
+		return nil, nil
+	}
+	count, err := strconv.Atoi(listenFds)
+	if err != nil || count < 0 {
+		return nil, errors.NotValidf("invalid LISTEN_FDS value %q", listenFds)
+	}
+	names := make([]string, count)
+	given := strings.Split(listenFdNames, ":")
+	for i := range names {
+		names[i] = "unknown"
+		if i < len(given) && len(given[i]) > 0 {
+			names[i] = given[i]
+		}
+	}
+	return names, nil
+}
+
+// systemdListeners returns the sockets passed by systemd. They're loaded only once,
+// and the environment variables are removed so they're not inherited by the child processes.
+func systemdListeners() ([]namedListener, error) {
+	systemdOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+		names, err := listenFdNames(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+		if err != nil {
+			systemdSocketErr = err
+			return
+		}
+		for i, name := range names {
+			fd := listenFdsStart + i
+			syscall.CloseOnExec(fd)
+			f := os.NewFile(uintptr(fd), name)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				systemdSocketErr = errors.Annotatef(err, "invalid socket %s passed by systemd", name)
+				return
+			}
+			systemdSockets = append(systemdSockets, namedListener{name: name, l: l})
+		}
+	})
+	return systemdSockets, systemdSocketErr
+}
+
+// systemdListener returns the socket passed by systemd for the address
+func systemdListener(addr string) (net.Listener, error) {
+	sockets, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(sockets) == 0 {
+		return nil, errors.NotFoundf("no sockets were passed by systemd")
+	}
+	if addr == systemdPrefix {
+		return sockets[0].l, nil
+	}
+	name := strings.TrimPrefix(addr, systemdPrefix+":")
+	for _, s := range sockets {
+		if s.name == name {
+			return s.l, nil
+		}
+	}
+	return nil, errors.NotFoundf("no socket named %s was passed by systemd", name)
+}
+
+// listen opens a listener on a TCP address, on a unix socket when the address is prefixed with "unix:",
+// or uses the socket passed by systemd when the address is "systemd" or "systemd:name".
+// The unix sockets are created with the mode permissions.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if addr == systemdPrefix || strings.HasPrefix(addr, systemdPrefix+":") {
+		return systemdListener(addr)
+	}
+	if !strings.HasPrefix(addr, unixSocketPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	p := strings.TrimPrefix(addr, unixSocketPrefix)
+	// NOTE(marius): remove the socket left behind by a previous run which didn't stop cleanly
+	if fi, err := os.Stat(p); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(p)
+	}
+	l, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = 0660
+	}
+	if err = os.Chmod(p, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// stopServer returns the function which stops srv, waiting for the requests in progress to finish.
+// When ctx is done before that, the remaining connections are closed.
+func stopServer(srv *http.Server) lifecycle.StopFn {
+	return func(ctx context.Context) error {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return err
+		}
+		return nil
+	}
+}
+
+// serve serves the requests from l with srv in the background, and returns the function which stops it
+func (f *FedBOX) serve(name string, srv *http.Server, l net.Listener) lifecycle.StopFn {
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			f.errFn("%s error: %s", name, err)
+		}
+	}()
+	return stopServer(srv)
+}
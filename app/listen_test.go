@@ -0,0 +1,71 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "fedbox.sock")
+	l, err := listen(unixSocketPrefix+sock, 0600)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %s", sock, err)
+	}
+	if l.Addr().Network() != "unix" {
+		t.Errorf("expected a unix socket listener, got %s", l.Addr().Network())
+	}
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the socket permissions to be 0600, got %o", fi.Mode().Perm())
+	}
+	l.Close()
+
+	l, err = listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Addr().Network() != "tcp" {
+		t.Errorf("expected a tcp listener, got %s", l.Addr().Network())
+	}
+	l.Close()
+}
+
+func TestListenFdNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		pid     string
+		fds     string
+		names   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "not activated"},
+		{name: "other process", pid: "1", fds: "1"},
+		{name: "unnamed", pid: "42", fds: "2", want: []string{"unknown", "unknown"}},
+		{name: "named", pid: "42", fds: "2", names: "http:admin", want: []string{"http", "admin"}},
+		{name: "invalid", pid: "42", fds: "two", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenFdNames(42, tt.pid, tt.fds, tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenFdNames() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listenFdNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/media"
+	"github.com/go-ap/jsonld"
+	"github.com/go-ap/processing"
+	"github.com/go-ap/storage"
+	"github.com/go-chi/chi"
+)
+
+// mediaHandler handles the uploadMedia endpoint, and serves the uploaded files
+type mediaHandler struct {
+	fb      FedBOX
+	baseIRI pub.IRI
+	blobs   *media.Store
+	maxSize int64
+	repo    storage.Store
+	// thumbnails are the sizes of the thumbnails generated for the uploaded images
+	thumbnails []int
+	// maxPixels is the maximum number of pixels of the uploaded images
+	maxPixels int64
+}
+
+// MediaIRI returns the IRI where the blob with the hash is served
+func MediaIRI(baseIRI pub.IRI, hash string) pub.IRI {
+	return baseIRI.AddPath("media/" + hash)
+}
+
+func (m mediaHandler) processor() (processing.Processor, error) {
+	errLogger := client.LogFn(m.fb.errFn)
+	infoLogger := client.LogFn(m.fb.infFn)
+	processor, _, err := processing.New(
+		processing.SetIRI(m.baseIRI, InternalIRI),
+		processing.SetClient(client.New(
+			client.SetInfoLogger(func(...client.Ctx) client.LogFn { return infoLogger }),
+			client.SetErrorLogger(func(...client.Ctx) client.LogFn { return errLogger }),
+		)),
+		processing.SetStorage(m.repo),
+		processing.SetInfoLogger(infoLogger),
+		processing.SetErrorLogger(errLogger),
+		processing.SetIDGenerator(GenerateID(m.baseIRI)),
+	)
+	return processor, err
+}
+
+// save stores an uploaded file. The images are stripped of their metadata, and get thumbnails,
+// which are returned as preview links.
+func (m mediaHandler) save(r io.Reader) (*media.Blob, *media.Image, pub.ItemCollection, error) {
+	br := bufio.NewReader(r)
+	sniff, _ := br.Peek(512)
+	if !media.CanProcess(http.DetectContentType(sniff)) {
+		blob, err := m.blobs.Save(br)
+		if err != nil {
+			return nil, nil, nil, errors.Annotatef(err, "unable to save the uploaded file")
+		}
+		return blob, nil, nil, nil
+	}
+
+	raw, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, nil, nil, errors.NewNotValid(err, "unable to read the uploaded file")
+	}
+	img, err := media.ProcessImage(raw, m.thumbnails, m.maxPixels)
+	if err == media.ErrTooManyPixels {
+		return nil, nil, nil, errors.NotValidf("the image has more than %d pixels", m.maxPixels)
+	}
+	if err != nil {
+		return nil, nil, nil, errors.NewNotValid(err, "invalid image")
+	}
+	blob, err := m.blobs.Save(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, nil, nil, errors.Annotatef(err, "unable to save the uploaded image")
+	}
+	previews := make(pub.ItemCollection, 0, len(img.Thumbnails))
+	for _, t := range img.Thumbnails {
+		tb, err := m.blobs.Save(bytes.NewReader(t.Data))
+		if err != nil {
+			return nil, nil, nil, errors.Annotatef(err, "unable to save thumbnail")
+		}
+		previews = append(previews, &pub.Link{
+			Type:      pub.LinkType,
+			Href:      MediaIRI(m.baseIRI, tb.Hash),
+			MediaType: pub.MimeType(t.MediaType),
+			Width:     uint(t.Width),
+			Height:    uint(t.Height),
+		})
+	}
+	return blob, img, previews, nil
+}
+
+// Upload handles the multipart requests to the uploadMedia endpoint. The "file" part is saved in the blob storage,
+// and an object for it, built on the optional "object" part, is created in the outbox of the authenticated actor.
+func (m mediaHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	actor, ok := auth.ActorContext(r.Context())
+	if !ok || !actor.GetLink().Contains(m.baseIRI, false) {
+		errors.HandleError(errors.Unauthorizedf("uploading media requires an authenticated local actor")).ServeHTTP(w, r)
+		return
+	}
+	if err := requireScope(r, ScopeWrite); err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	if m.maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, m.maxSize)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		errors.HandleError(errors.NewNotValid(err, "uploadMedia requires a multipart/form-data request")).ServeHTTP(w, r)
+		return
+	}
+
+	var shell pub.Item
+	var blob *media.Blob
+	var img *media.Image
+	var previews pub.ItemCollection
+	var fileName, declared string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errors.HandleError(errors.NewNotValid(err, "invalid multipart request")).ServeHTTP(w, r)
+			return
+		}
+		switch part.FormName() {
+		case "object":
+			raw, err := ioutil.ReadAll(io.LimitReader(part, 1<<20))
+			if err == nil {
+				shell, err = pub.UnmarshalJSON(raw)
+			}
+			if err != nil {
+				errors.HandleError(errors.NewNotValid(err, "invalid object")).ServeHTTP(w, r)
+				return
+			}
+		case "file":
+			if blob, img, previews, err = m.save(part); err != nil {
+				errors.HandleError(err).ServeHTTP(w, r)
+				return
+			}
+			fileName = part.FileName()
+			declared = part.Header.Get("Content-Type")
+		}
+		part.Close()
+	}
+	if blob == nil {
+		errors.HandleError(errors.NotValidf("missing file")).ServeHTTP(w, r)
+		return
+	}
+
+	mt := blob.MediaType
+	if strings.HasPrefix(string(mt), "application/octet-stream") && len(declared) > 0 {
+		mt = pub.MimeType(declared)
+	}
+	ob := new(pub.Object)
+	if !pub.IsNil(shell) {
+		pub.OnObject(shell, func(o *pub.Object) error {
+			*ob = *o
+			return nil
+		})
+	}
+	ob.ID = ""
+	ob.Type = media.ObjectType(mt)
+	ob.MediaType = mt
+	ob.URL = MediaIRI(m.baseIRI, blob.Hash)
+	if img != nil {
+		ob.URL = &pub.Link{
+			Type:      pub.LinkType,
+			Href:      MediaIRI(m.baseIRI, blob.Hash),
+			MediaType: mt,
+			Width:     uint(img.Width),
+			Height:    uint(img.Height),
+		}
+		ob.Preview = previews
+	}
+	ob.AttributedTo = actor.GetLink()
+	ob.Published = time.Now().UTC()
+	if len(ob.Name) == 0 && len(fileName) > 0 {
+		ob.Name = pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: pub.Content(fileName)}}
+	}
+	if len(ob.To)+len(ob.CC)+len(ob.Bto)+len(ob.BCC) == 0 {
+		ob.To = pub.ItemCollection{pub.PublicNS}
+	}
+
+	create := &pub.Create{
+		Type:   pub.CreateType,
+		Actor:  actor.GetLink(),
+		Object: ob,
+		To:     ob.To,
+		CC:     ob.CC,
+		Bto:    ob.Bto,
+		BCC:    ob.BCC,
+	}
+	processor, err := m.processor()
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	if _, err = processor.ProcessClientActivity(create); err != nil {
+		errors.HandleError(errors.Annotatef(err, "unable to create the media object")).ServeHTTP(w, r)
+		return
+	}
+
+	raw, err := jsonld.Marshal(ob)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/activity+json")
+	w.Header().Set("Location", ob.GetLink().String())
+	w.WriteHeader(http.StatusCreated)
+	w.Write(raw)
+}
+
+// Serve serves the uploaded files, with support for Range requests
+func (m mediaHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	f, err := m.blobs.Open(hash)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	// NOTE(marius): the blobs are immutable, as their name is the hash of their content
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, hash, fi.ModTime(), f)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/metrics"
+	"github.com/go-ap/fedbox/internal/websocket"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// RequestMetrics observes the duration of the requests, by their route pattern.
+// The streaming requests are not observed, as they last as long as the clients are connected.
+func RequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+		if strings.HasPrefix(ww.Header().Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		route := "unknown"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePattern()) > 0 {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.HTTPRequests.Since(start, route, r.Method, strconv.Itoa(status))
+	})
+}
+
+// MetricsAccess allows the requests from the networks in the MetricsAllow configuration,
+// and the ones with the MetricsToken bearer token
+func MetricsAccess(conf config.Options) func(http.Handler) http.Handler {
+	allowed := parseNets(conf.MetricsAllow)
+	token := []byte(conf.MetricsToken)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(token) > 0 {
+				bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if subtle.ConstantTimeCompare([]byte(bearer), token) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			// NOTE(marius): this uses the address of the connection, not the one from the X-Forwarded-For headers
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if ip := net.ParseIP(host); ip != nil && netsContain(allowed, ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			errors.HandleError(errors.NotFoundf("invalid url")).ServeHTTP(w, r)
+		})
+	}
+}
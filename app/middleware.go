@@ -59,6 +59,11 @@ func ActorFromAuthHeader(os *osin.Server, st storage.ReadStore, l logrus.FieldLo
 			id := act.GetID()
 			if id.IsValid() {
 				r = r.WithContext(context.WithValue(r.Context(), auth.ActorKey, act))
+				if tok := bearerToken(r); len(tok) > 0 {
+					if ad, err := os.Storage.LoadAccess(tok); err == nil && ad != nil {
+						r = withScope(r, ad.Scope)
+					}
+				}
 			}
 			next.ServeHTTP(w, r)
 		})
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	st "github.com/go-ap/fedbox/storage"
+	h "github.com/go-ap/handlers"
+	"github.com/go-ap/storage"
+)
+
+// aliasesClient loads the remote actors named in Move activities, so it can't reach the internal addresses
+var aliasesClient = newPublicClient(10*time.Second, 5)
+
+// withAliases adds to a local actor its aliases and the IRI of the actor it moved to, for serving its document.
+// The result must not be stored or cached, as it's not an item type the rest of the code knows how to handle.
+func withAliases(repo storage.ReadStore, it pub.Item) pub.Item {
+	meta, ok := repo.(st.MetadataTyper)
+	if !ok || pub.IsNil(it) || !pub.ActorTypes.Contains(it.GetType()) {
+		return it
+	}
+	m, err := meta.LoadMetadata(it.GetLink())
+	if err != nil || m == nil || (len(m.AlsoKnownAs) == 0 && len(m.MovedTo) == 0) {
+		return it
+	}
+	act, err := pub.ToActor(it)
+	if err != nil {
+		return it
+	}
+	return &ap.Actor{Actor: act, AlsoKnownAs: m.AlsoKnownAs, MovedTo: m.MovedTo}
+}
+
+// moveProperties are the properties of an actor which are checked when it moves
+type moveProperties struct {
+	AlsoKnownAs pub.IRIs
+	MovedTo     pub.IRI
+}
+
+// loadMoveProperties returns the aliases of an actor, and the actor it moved to. For local actors
+// they're loaded from the storage, for the remote ones from the actor's JSON document.
+func loadMoveProperties(repo storage.ReadStore, baseIRI, iri pub.IRI) (moveProperties, error) {
+	props := moveProperties{}
+	if m, ok := repo.(st.MetadataTyper); ok && iri.Contains(baseIRI, false) {
+		meta, err := m.LoadMetadata(iri)
+		if err != nil {
+			return props, errors.Annotatef(err, "unable to load metadata for %s", iri)
+		}
+		props.AlsoKnownAs, props.MovedTo = meta.AlsoKnownAs, meta.MovedTo
+		return props, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, iri.String(), nil)
+	if err != nil {
+		return props, err
+	}
+	req.Header.Set("Accept", `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	resp, err := aliasesClient.Do(req)
+	if err != nil {
+		return props, errors.Annotatef(err, "unable to load %s", iri)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return props, errors.Newf("unable to load %s: %s", iri, resp.Status)
+	}
+	actor := struct {
+		AlsoKnownAs json.RawMessage `json:"alsoKnownAs"`
+		MovedTo     json.RawMessage `json:"movedTo"`
+	}{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return props, errors.Annotatef(err, "invalid actor %s", iri)
+	}
+	props.AlsoKnownAs = parseAlsoKnownAs(actor.AlsoKnownAs)
+	if moved := parseAlsoKnownAs(actor.MovedTo); len(moved) > 0 {
+		props.MovedTo = moved[0]
+	}
+	return props, nil
+}
+
+// LoadAlsoKnownAs returns the aliases of an actor. For local actors they're loaded from the storage,
+// for the remote ones from the actor's JSON document.
+func LoadAlsoKnownAs(repo storage.ReadStore, baseIRI, iri pub.IRI) (pub.IRIs, error) {
+	props, err := loadMoveProperties(repo, baseIRI, iri)
+	return props.AlsoKnownAs, err
+}
+
+// parseAlsoKnownAs decodes the alsoKnownAs property, which can be a single IRI or a list of them
+func parseAlsoKnownAs(raw json.RawMessage) pub.IRIs {
+	iris := make(pub.IRIs, 0)
+	if len(raw) == 0 {
+		return iris
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return append(iris, pub.IRI(one))
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		for _, s := range many {
+			iris = append(iris, pub.IRI(s))
+		}
+	}
+	return iris
+}
+
+// RepointFollows makes the local actors which follow the from actor follow the to actor instead.
+// The from actor is removed from the following collection of an actor only after the Follow of the to actor
+// was processed, and the failures of some actors don't stop the rest of them from being moved, so running it
+// again moves only the ones which failed. It returns how many actors were moved.
+func RepointFollows(repo storage.Store, process func(pub.Item) (pub.Item, error), baseIRI, from, to pub.IRI) (int, error) {
+	actors, err := repo.Load(ap.ActorsType.IRI(baseIRI))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	failed := make([]string, 0)
+	err = pub.OnCollectionIntf(actors, func(col pub.CollectionInterface) error {
+		for _, actor := range col.Collection() {
+			if !followsActor(repo, actor, from) {
+				continue
+			}
+			follow := &pub.Follow{
+				Type:   pub.FollowType,
+				Actor:  actor.GetLink(),
+				Object: to,
+				To:     pub.ItemCollection{to},
+			}
+			if _, err := process(follow); err != nil {
+				failed = append(failed, fmt.Sprintf("unable to follow %s for %s: %s", to, actor.GetLink(), err))
+				continue
+			}
+			count++
+			if err := repo.RemoveFrom(h.Following.IRI(actor), from); err != nil {
+				failed = append(failed, fmt.Sprintf("unable to remove %s from the following collection of %s: %s", from, actor.GetLink(), err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if len(failed) > 0 {
+		return count, errors.Newf("unable to move the follows of %d actors: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return count, nil
+}
+
+func followsActor(repo storage.ReadStore, actor pub.Item, iri pub.IRI) bool {
+	following, err := repo.Load(h.Following.IRI(actor))
+	if err != nil || pub.IsNil(following) {
+		return false
+	}
+	found := false
+	pub.OnCollectionIntf(following, func(col pub.CollectionInterface) error {
+		for _, it := range col.Collection() {
+			if it.GetLink().Equals(iri, false) {
+				found = true
+				break
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// processMove handles a Move activity received from another server. It needs to be sent by the moving actor,
+// which needs to have moved to the target, and the target needs to have the moving actor in its aliases.
+// The local actors following the moving actor will follow the target instead, the ones which fail are logged.
+func processMove(repo storage.Store, process func(pub.Item) (pub.Item, error), errFn LogFn, baseIRI pub.IRI, sender *pub.Actor, it pub.Item) (pub.Item, error) {
+	err := pub.OnActivity(it, func(a *pub.Activity) error {
+		if pub.IsNil(a.Actor) || pub.IsNil(a.Object) || pub.IsNil(a.Target) {
+			return errors.NotValidf("Move activities need an actor, an object and a target")
+		}
+		if sender == nil || sender.GetLink().Equals(auth.AnonymousActor.ID, false) || sender.GetLink().Equals(pub.PublicNS, false) {
+			return errors.Unauthorizedf("Move activities need to be signed by their actor")
+		}
+		if !sender.GetLink().Equals(a.Actor.GetLink(), false) {
+			return errors.Forbiddenf("%s can not send a Move for %s", sender.GetLink(), a.Actor.GetLink())
+		}
+		from := a.Object.GetLink()
+		if !from.Equals(a.Actor.GetLink(), false) {
+			return errors.NotValidf("%s can not move %s", a.Actor.GetLink(), from)
+		}
+		to := a.Target.GetLink()
+		origin, err := loadMoveProperties(repo, baseIRI, from)
+		if err != nil {
+			return errors.NewNotValid(err, "unable to verify the move of %s", from)
+		}
+		if !origin.MovedTo.Equals(to, false) {
+			return errors.NotValidf("%s did not move to %s", from, to)
+		}
+		aliases, err := LoadAlsoKnownAs(repo, baseIRI, to)
+		if err != nil {
+			return errors.NewNotValid(err, "unable to verify the move to %s", to)
+		}
+		if !aliases.Contains(from) {
+			return errors.NotValidf("%s is not an alias of %s", from, to)
+		}
+		if _, err = repo.Save(a); err != nil {
+			return err
+		}
+		if _, err = RepointFollows(repo, process, baseIRI, from, to); err != nil {
+			// NOTE(marius): the Move is valid, so the inbox accepts it, even if some of the follows couldn't be moved
+			errFn("Unable to move all the follows from %s to %s: %s", from, to, err)
+		}
+		return nil
+	})
+	return it, err
+}
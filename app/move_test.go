@@ -0,0 +1,255 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	st "github.com/go-ap/fedbox/storage"
+	h "github.com/go-ap/handlers"
+	"github.com/go-ap/jsonld"
+	"github.com/go-ap/storage"
+)
+
+func TestParseAlsoKnownAs(t *testing.T) {
+	tests := map[string]struct {
+		raw  string
+		want pub.IRIs
+	}{
+		"missing": {raw: ``, want: pub.IRIs{}},
+		"single":  {raw: `"https://example.com/actors/1"`, want: pub.IRIs{"https://example.com/actors/1"}},
+		"list":    {raw: `["https://example.com/actors/1","https://example.org/users/jdoe"]`, want: pub.IRIs{"https://example.com/actors/1", "https://example.org/users/jdoe"}},
+		"invalid": {raw: `{"id":"https://example.com/actors/1"}`, want: pub.IRIs{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseAlsoKnownAs(json.RawMessage(tt.raw))
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseAlsoKnownAs(%s) = %v, want %v", tt.raw, got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equals(tt.want[i], false) {
+					t.Errorf("parseAlsoKnownAs(%s)[%d] = %s, want %s", tt.raw, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+type mockMetadataLoader struct {
+	mockLoader
+	mockMetadata
+}
+
+func TestWithAliases(t *testing.T) {
+	actor := &pub.Actor{ID: "https://example.com/actors/1", Type: pub.PersonType}
+	repo := mockMetadataLoader{
+		mockLoader: mockLoader{actor.ID: actor},
+		mockMetadata: mockMetadata{actor.ID: st.Metadata{
+			AlsoKnownAs: pub.IRIs{"https://example.org/users/jdoe"},
+			MovedTo:     "https://example.org/users/jdoe",
+		}},
+	}
+
+	served := withAliases(repo, actor)
+	raw, err := jsonld.Marshal(served)
+	if err != nil {
+		t.Fatalf("Unable to marshal %s: %s", actor.ID, err)
+	}
+	doc := struct {
+		ID          string   `json:"id"`
+		Type        string   `json:"type"`
+		AlsoKnownAs []string `json:"alsoKnownAs"`
+		MovedTo     string   `json:"movedTo"`
+	}{}
+	if err = json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("Invalid JSON %s: %s", raw, err)
+	}
+	if doc.ID != actor.ID.String() || doc.Type != string(pub.PersonType) {
+		t.Errorf("the served document %s lost the properties of the actor", raw)
+	}
+	if len(doc.AlsoKnownAs) != 1 || doc.AlsoKnownAs[0] != "https://example.org/users/jdoe" {
+		t.Errorf("the served document %s doesn't contain alsoKnownAs", raw)
+	}
+	if doc.MovedTo != "https://example.org/users/jdoe" {
+		t.Errorf("the served document %s doesn't contain movedTo", raw)
+	}
+
+	other := &pub.Actor{ID: "https://example.com/actors/2", Type: pub.PersonType}
+	if it := withAliases(repo, other); it != pub.Item(other) {
+		t.Errorf("withAliases() changed an actor without aliases: %T", it)
+	}
+	if it := withAliases(repo, &pub.Object{ID: "https://example.com/objects/1", Type: pub.NoteType}); it.GetType() != pub.NoteType {
+		t.Errorf("withAliases() changed an object which is not an actor: %T", it)
+	}
+}
+
+type mockStore struct {
+	storage.Store
+	mockLoader
+	saved   pub.ItemCollection
+	removed map[pub.IRI]pub.IRIs
+}
+
+func (m *mockStore) Load(i pub.IRI) (pub.Item, error) {
+	return m.mockLoader.Load(i)
+}
+
+func (m *mockStore) Save(it pub.Item) (pub.Item, error) {
+	m.saved = append(m.saved, it)
+	return it, nil
+}
+
+func (m *mockStore) RemoveFrom(col pub.IRI, it pub.Item) error {
+	m.removed[col] = append(m.removed[col], it.GetLink())
+	return nil
+}
+
+func TestProcessMove(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := "http://" + r.Host
+		switch r.URL.Path {
+		case "/origin":
+			fmt.Fprintf(w, `{"id":"%s/origin","type":"Person","movedTo":"%s/target"}`, base, base)
+		case "/stale":
+			fmt.Fprintf(w, `{"id":"%s/stale","type":"Person"}`, base)
+		case "/target":
+			fmt.Fprintf(w, `{"id":"%s/target","type":"Person","alsoKnownAs":["%s/origin","%s/stale"]}`, base, base, base)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(c *http.Client) { aliasesClient = c }(aliasesClient)
+	aliasesClient = srv.Client()
+
+	baseIRI := pub.IRI("https://fedbox.example.com")
+	origin := pub.IRI(srv.URL + "/origin")
+	stale := pub.IRI(srv.URL + "/stale")
+	target := pub.IRI(srv.URL + "/target")
+	follower := &pub.Actor{ID: pub.IRI("https://fedbox.example.com/actors/1"), Type: pub.PersonType}
+
+	tests := map[string]struct {
+		sender  *pub.Actor
+		from    pub.IRI
+		errFn   func(error) bool
+		repoint bool
+	}{
+		"valid":                  {sender: &pub.Actor{ID: origin}, from: origin, repoint: true},
+		"unauthenticated":        {sender: nil, from: origin, errFn: errors.IsUnauthorized},
+		"anonymous":              {sender: &auth.AnonymousActor, from: origin, errFn: errors.IsUnauthorized},
+		"forged sender":          {sender: &pub.Actor{ID: follower.ID}, from: origin, errFn: errors.IsForbidden},
+		"origin without movedTo": {sender: &pub.Actor{ID: stale}, from: stale, errFn: errors.IsNotValid},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			repo := &mockStore{
+				mockLoader: mockLoader{
+					ap.ActorsType.IRI(baseIRI): &pub.OrderedCollection{
+						ID:           ap.ActorsType.IRI(baseIRI),
+						Type:         pub.OrderedCollectionType,
+						OrderedItems: pub.ItemCollection{follower},
+					},
+					h.Following.IRI(follower): &pub.OrderedCollection{
+						ID:           h.Following.IRI(follower),
+						Type:         pub.OrderedCollectionType,
+						OrderedItems: pub.ItemCollection{tt.from},
+					},
+				},
+				removed: make(map[pub.IRI]pub.IRIs),
+			}
+			processed := make(pub.ItemCollection, 0)
+			process := func(it pub.Item) (pub.Item, error) {
+				processed = append(processed, it)
+				return it, nil
+			}
+			move := &pub.Activity{
+				Type:   pub.MoveType,
+				Actor:  tt.from,
+				Object: tt.from,
+				Target: target,
+			}
+
+			_, err := processMove(repo, process, t.Logf, baseIRI, tt.sender, move)
+			if tt.errFn != nil {
+				if err == nil || !tt.errFn(err) {
+					t.Fatalf("processMove() error = %v, want a different error", err)
+				}
+				if len(repo.saved) > 0 || len(processed) > 0 || len(repo.removed) > 0 {
+					t.Errorf("processMove() changed the storage for an invalid Move")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unable to process the Move: %s", err)
+			}
+			if len(repo.saved) != 1 {
+				t.Errorf("processMove() saved %d items, want the Move", len(repo.saved))
+			}
+			if len(processed) != 1 || processed[0].GetType() != pub.FollowType {
+				t.Fatalf("processMove() processed %v, want a Follow", processed)
+			}
+			pub.OnActivity(processed[0], func(f *pub.Activity) error {
+				if !f.Actor.GetLink().Equals(follower.ID, false) || !f.Object.GetLink().Equals(target, false) {
+					t.Errorf("processMove() sent a Follow from %s for %s, want from %s for %s", f.Actor.GetLink(), f.Object.GetLink(), follower.ID, target)
+				}
+				return nil
+			})
+			if removed := repo.removed[h.Following.IRI(follower)]; len(removed) != 1 || !removed[0].Equals(origin, false) {
+				t.Errorf("processMove() removed %v from the following collection, want %s", removed, origin)
+			}
+		})
+	}
+}
+
+func TestRepointFollowsFailures(t *testing.T) {
+	baseIRI := pub.IRI("https://fedbox.example.com")
+	from := pub.IRI("https://example.com/actors/old")
+	to := pub.IRI("https://example.com/actors/new")
+	failing := &pub.Actor{ID: pub.IRI("https://fedbox.example.com/actors/1"), Type: pub.PersonType}
+	working := &pub.Actor{ID: pub.IRI("https://fedbox.example.com/actors/2"), Type: pub.PersonType}
+
+	repo := &mockStore{
+		mockLoader: mockLoader{
+			ap.ActorsType.IRI(baseIRI): &pub.OrderedCollection{
+				ID:           ap.ActorsType.IRI(baseIRI),
+				Type:         pub.OrderedCollectionType,
+				OrderedItems: pub.ItemCollection{failing, working},
+			},
+			h.Following.IRI(failing): &pub.OrderedCollection{ID: h.Following.IRI(failing), OrderedItems: pub.ItemCollection{from}},
+			h.Following.IRI(working): &pub.OrderedCollection{ID: h.Following.IRI(working), OrderedItems: pub.ItemCollection{from}},
+		},
+		removed: make(map[pub.IRI]pub.IRIs),
+	}
+	process := func(it pub.Item) (pub.Item, error) {
+		var err error
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			if a.Actor.GetLink().Equals(failing.ID, false) {
+				err = errors.Newf("unable to deliver")
+			}
+			return nil
+		})
+		return it, err
+	}
+
+	count, err := RepointFollows(repo, process, baseIRI, from, to)
+	if err == nil {
+		t.Errorf("RepointFollows() didn't return the error of %s", failing.ID)
+	}
+	if count != 1 {
+		t.Errorf("RepointFollows() moved %d follows, want 1", count)
+	}
+	if removed := repo.removed[h.Following.IRI(failing)]; len(removed) > 0 {
+		t.Errorf("RepointFollows() removed %s from the following collection of %s, when the Follow failed", from, failing.ID)
+	}
+	if removed := repo.removed[h.Following.IRI(working)]; len(removed) != 1 {
+		t.Errorf("RepointFollows() didn't move the follow of %s", working.ID)
+	}
+}
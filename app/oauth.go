@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"github.com/go-ap/auth"
 	"github.com/go-ap/fedbox/internal/assets"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/processing"
 	"html/template"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	pub "github.com/go-ap/activitypub"
@@ -50,6 +52,16 @@ type ClientLister interface {
 	GetClient(id string) (osin.Client, error)
 }
 
+type AuthorizeLister interface {
+	// ListAuthorize lists existing authorization codes
+	ListAuthorize() ([]*osin.AuthorizeData, error)
+}
+
+type AccessLister interface {
+	// ListAccess lists existing access tokens
+	ListAccess() ([]*osin.AccessData, error)
+}
+
 func (a account) IsLogged() bool {
 	return a.actor != nil && a.actor.PreferredUsername.First().Value.String() == a.username
 }
@@ -65,6 +77,8 @@ type indieAuth struct {
 	os      *osin.Server
 	st      ClientStorage
 	ap      storage.Store
+	// cl loads the client information of the IndieAuth clients
+	cl *http.Client
 }
 
 const (
@@ -117,59 +131,122 @@ func filters(r *http.Request, baseURL pub.IRI) *activitypub.Filters {
 	return f
 }
 
-func (i indieAuth) ValidateClient(r *http.Request) (*pub.Actor, error) {
-	r.ParseForm()
-	clientID, err := url.QueryUnescape(r.FormValue(clientIdKey))
+// loadClientActor loads the Application actor of the IndieAuth client, which has the client_id as URL
+func (i indieAuth) loadClientActor(r *http.Request, clientID string) (pub.Item, error) {
+	f := filters(r, i.baseIRI)
+	f.Type = activitypub.CompStrs{activitypub.StringEquals(string(pub.ApplicationType))}
+	f.URL = activitypub.CompStrs{activitypub.StringEquals(clientID)}
+	it, err := i.ap.Load(f.GetLink())
 	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
 		return nil, err
 	}
-	if clientID == "" {
-		return nil, nil
+	return firstItem(it), nil
+}
+
+// loadProfile loads the local actor of the me parameter. As the IndieAuth spec describes, the me value
+// is only a hint, and the actors which are not found are ignored.
+func (i indieAuth) loadProfile(r *http.Request, me string) *pub.Actor {
+	u, err := canonicalURL(me)
+	if err != nil {
+		return nil
+	}
+	f := filters(r, i.baseIRI)
+	f.Type = activitypub.CompStrs{activitypub.StringEquals(string(pub.PersonType))}
+	f.URL = activitypub.CompStrs{activitypub.StringEquals(u.String())}
+	it, err := i.ap.Load(f.GetLink())
+	if it = firstItem(it); (err != nil || pub.IsNil(it)) && pub.IRI(u.String()).Contains(i.baseIRI, false) {
+		it, err = i.ap.Load(pub.IRI(u.String()))
+		it = firstItem(it)
+	}
+	if err != nil || pub.IsNil(it) {
+		return nil
+	}
+	var actor *pub.Actor
+	pub.OnActor(it, func(p *pub.Actor) error {
+		actor = p
+		return nil
+	})
+	return actor
+}
+
+func firstItem(it pub.Item) pub.Item {
+	if !pub.IsNil(it) && pub.IsItemCollection(it) {
+		pub.OnCollectionIntf(it, func(col pub.CollectionInterface) error {
+			it = col.Collection().First()
+			return nil
+		})
 	}
-	clientURL, err := url.Parse(clientID)
+	return it
+}
+
+// mapClientID replaces the URL client_id of the IndieAuth requests with the id of their OAuth2 client
+func (i indieAuth) mapClientID(r *http.Request) error {
+	r.ParseForm()
+	u, err := canonicalURL(r.FormValue(clientIdKey))
 	if err != nil {
-		return nil, nil
+		return err
+	}
+	clientActor, err := i.loadClientActor(r, u.String())
+	if err != nil {
+		return err
+	}
+	if pub.IsNil(clientActor) {
+		return errors.NotFoundf("unknown client %s", u)
+	}
+	r.Form.Set(clientIdKey, path.Base(clientActor.GetLink().String()))
+	return nil
+}
+
+func (i indieAuth) fetchInfo(clientID string) (*clientInfo, error) {
+	cl := i.cl
+	if cl == nil {
+		cl = newClientInfoFetcher()
 	}
+	return fetchClientInfo(cl, clientID)
+}
 
-	unescapedUri, err := url.QueryUnescape(r.FormValue(redirectUriKey))
+// ValidateClient checks the client_id and the redirect_uri of the IndieAuth authorization requests.
+// The redirect URIs which are not on the same host as the client_id must be published by the client,
+// in its client information. It creates the Application actor and the OAuth2 client of the new clients,
+// and returns the local actor of the me parameter, if there is one.
+func (i indieAuth) ValidateClient(r *http.Request) (*pub.Actor, error) {
+	r.ParseForm()
+	clientID := r.FormValue(clientIdKey)
+	if clientID == "" {
+		return nil, nil
+	}
+	clientURL, err := canonicalURL(clientID)
 	if err != nil {
 		return nil, err
 	}
-	// load the me value of the actor that wants to authenticate
-	me, err := url.QueryUnescape(r.FormValue(meKey))
-	if err != nil {
+	if err = validateClientID(clientURL); err != nil {
 		return nil, err
 	}
+	clientID = clientURL.String()
+	redirect := r.FormValue(redirectUriKey)
 
-	// check for existing user actor
-	var actor pub.Item
-	if me != "" {
-		f := filters(r, i.baseIRI)
-		f.Type = activitypub.CompStrs{activitypub.StringEquals(string(pub.PersonType))}
-		f.URL = activitypub.CompStrs{activitypub.StringEquals(me)}
-		actor, err = i.ap.Load(f.GetLink())
-		if err != nil {
-			return nil, err
-		}
-		if actor == nil {
-			return nil, errors.NotFoundf("unknown actor")
-		}
-	}
+	actor := i.loadProfile(r, r.FormValue(meKey))
 
 	// check for existing application actor
-	f := filters(r, i.baseIRI)
-	f.Type = activitypub.CompStrs{activitypub.StringEquals(string(pub.ApplicationType))}
-	f.URL = activitypub.CompStrs{activitypub.StringEquals(clientID)}
-	clientActor, err := i.ap.Load(f.GetLink())
+	var info *clientInfo
+	clientActor, err := i.loadClientActor(r, clientID)
 	if err != nil {
 		return nil, err
 	}
-	if clientActor == nil {
-		newClient := IndieAuthClientActor(actor, clientURL)
-		if err != nil {
-			return nil, err
+	if pub.IsNil(clientActor) {
+		// NOTE(marius): the client information is optional, so the errors are ignored here
+		info, _ = i.fetchInfo(clientID)
+
+		var author pub.Item = activitypub.Self(i.baseIRI)
+		if actor != nil {
+			author = actor
 		}
-		if newId, err := i.genID(newClient, handlers.Outbox.IRI(actor), nil); err == nil {
+		newClient := IndieAuthClientActor(author, clientURL)
+		info.apply(newClient)
+		if newId, err := i.genID(newClient, handlers.Outbox.IRI(author), nil); err == nil {
 			newClient.ID = newId
 		}
 		clientActor, err = i.ap.Save(newClient)
@@ -178,20 +255,45 @@ func (i indieAuth) ValidateClient(r *http.Request) (*pub.Actor, error) {
 		}
 	}
 	id := path.Base(clientActor.GetID().String())
-	// must have a valid client
-	if _, err = i.st.GetClient(id); err != nil {
-		if errors.IsNotFound(err) {
-			// create client
-			newClient := osin.DefaultClient{
-				Id:          id,
-				Secret:      "",
-				RedirectUri: unescapedUri,
-				//UserData:    userData,
+
+	// must have a valid client, with the redirect URI
+	cl, err := i.st.GetClient(id)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	redirects := make([]string, 0)
+	if cl != nil && len(cl.GetRedirectUri()) > 0 {
+		redirects = strings.Split(cl.GetRedirectUri(), "\n")
+	}
+	known := len(redirect) == 0
+	for _, uri := range redirects {
+		if uri == redirect {
+			known = true
+		}
+	}
+	if !known {
+		if u, err := url.Parse(redirect); err != nil || !sameOrigin(clientURL, u) {
+			if info == nil {
+				info, err = i.fetchInfo(clientID)
 			}
-			if err = i.st.CreateClient(&newClient); err != nil {
+			if err = verifyRedirectURI(clientURL, redirect, info); err != nil {
 				return nil, err
 			}
-		} else {
+		}
+		redirects = append(redirects, redirect)
+	}
+	newClient := osin.DefaultClient{
+		Id:          id,
+		RedirectUri: strings.Join(redirects, "\n"),
+	}
+	if cl == nil {
+		if err = i.st.CreateClient(&newClient); err != nil {
+			return nil, err
+		}
+	} else if !known {
+		newClient.Secret = cl.GetSecret()
+		newClient.UserData = cl.GetUserData()
+		if err = i.st.UpdateClient(&newClient); err != nil {
 			return nil, err
 		}
 	}
@@ -199,10 +301,7 @@ func (i indieAuth) ValidateClient(r *http.Request) (*pub.Actor, error) {
 	if osin.AuthorizeRequestType(r.FormValue(responseTypeKey)) == ID {
 		r.Form.Set(responseTypeKey, "code")
 	}
-	if act, ok := actor.(*pub.Actor); ok {
-		return act, nil
-	}
-	return nil, nil
+	return actor, nil
 }
 
 type oauthHandler struct {
@@ -210,11 +309,20 @@ type oauthHandler struct {
 	ia      *indieAuth
 	loader  storage.ReadStore
 	logger  logrus.FieldLogger
+	guard   *loginGuard
+	pending *pendingLogins
+	// registration is the policy of the dynamic client registration
+	registration string
 }
 
 var scopeAnonymousUserCreate = "anonUserCreate"
 
 func (h *oauthHandler) loadAccountFromPost(r *http.Request) (*account, error) {
+	client := r.PostFormValue("client")
+	if tok := r.PostFormValue("login_token"); len(tok) > 0 {
+		return h.secondFactor(r, tok, r.PostFormValue("otp"), client)
+	}
+
 	pw := r.PostFormValue("pw")
 	handle := r.PostFormValue("handle")
 
@@ -234,12 +342,49 @@ func (h *oauthHandler) loadAccountFromPost(r *http.Request) (*account, error) {
 		return nil, errUnauthorized
 	}
 
-	if pwLoader, ok := h.loader.(st.PasswordChanger); ok {
-		if act, err := checkPw(actor, []byte(pw), pwLoader); err == nil {
-			return act, nil
+	acc, err := h.login(r, actor, []byte(pw), client)
+	if err == errSecondFactor {
+		tok, err := h.pending.Add(acc.actor.GetLink(), client)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to continue the login")
 		}
+		return nil, secondFactorRequired{account: acc, token: tok}
 	}
-	return nil, errUnauthorized
+	return acc, err
+}
+
+// login checks the password of the actor, refusing it while the account is locked,
+// and records the attempt in the audit log.
+// When the account has the second factor authentication enabled, it returns the account
+// together with errSecondFactor, and the login is finished by checking the authentication code.
+func (h *oauthHandler) login(r *http.Request, it pub.Item, pw []byte, client string) (*account, error) {
+	pwLoader, ok := h.loader.(st.PasswordChanger)
+	if !ok {
+		return nil, errUnauthorized
+	}
+	if pub.IsItemCollection(it) {
+		pub.OnCollectionIntf(it, func(col pub.CollectionInterface) error {
+			it = col.Collection().First()
+			return nil
+		})
+	}
+	if pub.IsNil(it) {
+		return nil, errUnauthorized
+	}
+	iri := it.GetLink()
+	if err := h.guard.Locked(iri); err != nil {
+		return nil, err
+	}
+	acc, err := checkPw(it, pw, pwLoader)
+	if err != nil || acc == nil {
+		h.guard.Failed(iri, r, client)
+		return nil, errUnauthorized
+	}
+	if h.guard.SecondFactor(iri) {
+		return acc, errSecondFactor
+	}
+	h.guard.Succeeded(iri, r, client)
+	return acc, nil
 }
 
 func (h *oauthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
@@ -249,19 +394,50 @@ func (h *oauthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	resp := s.NewResponse()
 	defer resp.Close()
 
-	var err error
+	if r.Method == http.MethodPost && r.PostFormValue("grant_type") == string(osin.AUTHORIZATION_CODE) {
+		// NOTE(marius): the IndieAuth clients which only need the identity of the user
+		// redeem the authorization code at the authorization endpoint
+		h.redeemProfile(w, r)
+		return
+	}
+
 	actor := &auth.AnonymousActor
 	if ia.IsValidRequest(r) {
-		if actor, err = ia.ValidateClient(r); err != nil {
+		act, err := ia.ValidateClient(r)
+		if err != nil {
 			resp.SetError(osin.E_INVALID_REQUEST, err.Error())
 			redirectOrOutput(resp, w, r)
 			return
 		}
+		if act != nil {
+			actor = act
+		}
 	}
 
+	var err error
+
 	var overrideRedir = false
 
 	if ar := s.HandleAuthorizeRequest(resp, r); ar != nil {
+		// NOTE(marius): osin accepts the plain challenges, and uses them when the method is missing,
+		// but they don't protect the code if the request is intercepted, so we accept only S256
+		if len(ar.CodeChallenge) > 0 && ar.CodeChallengeMethod != osin.PKCE_S256 {
+			resp.SetError(osin.E_INVALID_REQUEST, "the code_challenge_method must be S256")
+			redirectOrOutput(resp, w, r)
+			return
+		}
+		if ar.Scope != scopeAnonymousUserCreate {
+			if ar.Scope, err = grantableScope(ar.Scope); err != nil {
+				resp.SetError(osin.E_INVALID_SCOPE, err.Error())
+				redirectOrOutput(resp, w, r)
+				return
+			}
+		}
+		if code, err := checkRegisteredAuthorize(ar.Client, ar.Type, ar.Scope); err != nil {
+			resp.SetError(code, err.Error())
+			redirectOrOutput(resp, w, r)
+			return
+		}
 		if r.Method == http.MethodGet {
 			if ar.Scope == scopeAnonymousUserCreate {
 				// FIXME(marius): this seems like a way to backdoor our selves, we need a better way
@@ -281,6 +457,9 @@ func (h *oauthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			acc, err := h.loadAccountFromPost(r)
+			if h.renderSecondFactor(w, r, err) {
+				return
+			}
 			if err != nil {
 				errors.HandleError(err).ServeHTTP(w, r)
 				return
@@ -291,6 +470,10 @@ func (h *oauthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		s.FinishAuthorizeRequest(resp, r, ar)
+		if !resp.IsError && resp.Type == osin.REDIRECT {
+			// NOTE(marius): the iss parameter from RFC 9207, which the IndieAuth clients check
+			resp.Output["iss"] = h.baseURL
+		}
 	}
 	if overrideRedir {
 		resp.Type = osin.DATA
@@ -318,8 +501,22 @@ func (h *oauthHandler) Token(w http.ResponseWriter, r *http.Request) {
 	resp := s.NewResponse()
 	defer resp.Close()
 
+	if h.ia.IsValidRequest(r) {
+		if err := h.ia.mapClientID(r); err != nil {
+			resp.SetError(osin.E_INVALID_CLIENT, err.Error())
+			redirectOrOutput(resp, w, r)
+			return
+		}
+	}
+	publicClientAuth(r)
+
 	acc := &AnonymousAcct
 	if ar := s.HandleAccessRequest(resp, r); ar != nil {
+		if code, err := checkRegisteredAccess(ar.Client, ar.Type, ar.Scope); err != nil {
+			resp.SetError(code, err.Error())
+			redirectOrOutput(resp, w, r)
+			return
+		}
 		actorFilters := activitypub.FiltersNew()
 		switch ar.Type {
 		case osin.PASSWORD:
@@ -348,13 +545,20 @@ func (h *oauthHandler) Token(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if ar.Type == osin.PASSWORD {
-			if pwLoader, ok := h.loader.(st.PasswordChanger); ok {
-				acc, err = checkPw(actor, []byte(ar.Password), pwLoader)
-				if err != nil || acc == nil {
-					if err != nil {
-						h.logger.Error(err)
-					}
-					errors.HandleError(errUnauthorized).ServeHTTP(w, r)
+			if ar.Scope, err = grantableScope(ar.Scope); err != nil {
+				resp.SetError(osin.E_INVALID_SCOPE, err.Error())
+				redirectOrOutput(resp, w, r)
+				return
+			}
+			if _, ok := h.loader.(st.PasswordChanger); ok {
+				acc, err = h.login(r, actor, []byte(ar.Password), ar.Client.GetId())
+				if err == errSecondFactor {
+					// NOTE(marius): the password grant has no second step, so the code is sent together with the password
+					err = h.guard.CheckCode(acc.actor.GetLink(), r, ar.Client.GetId(), r.PostFormValue("otp"))
+				}
+				if err != nil {
+					h.logger.Error(err)
+					errors.HandleError(err).ServeHTTP(w, r)
 					return
 				}
 				ar.Authorized = acc.IsLogged()
@@ -371,6 +575,13 @@ func (h *oauthHandler) Token(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 		s.FinishAccessRequest(resp, r, ar)
+		if !resp.IsError {
+			metrics.OAuthTokens.Inc(string(ar.Type))
+			if acc != &AnonymousAcct && acc.actor != nil {
+				h.guard.Record(acc.actor.GetLink(), r, st.AuditTokenGrant, ar.Client.GetId(), string(ar.Type))
+				h.addProfile(resp, ar.UserData, ar.Scope)
+			}
+		}
 	}
 	redirectOrOutput(resp, w, r)
 }
@@ -529,6 +740,9 @@ var errUnauthorized = errors.Unauthorizedf("Invalid username or password")
 // ShowLogin handles POST /login requests
 func (h *oauthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	acc, err := h.loadAccountFromPost(r)
+	if h.renderSecondFactor(w, r, err) {
+		return
+	}
 	if err != nil {
 		errors.HandleError(err).ServeHTTP(w, r)
 		return
@@ -612,6 +826,7 @@ func (h *oauthHandler) HandleChangePw(w http.ResponseWriter, r *http.Request) {
 			errors.HandleError(errors.NotValidf("Unable to change password")).ServeHTTP(w, r)
 			return
 		}
+		h.guard.Record(actor.GetLink(), r, st.AuditPasswordChange, "", "")
 		h.ia.os.Storage.RemoveAuthorize(tok)
 	}
 }
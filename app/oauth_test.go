@@ -0,0 +1,54 @@
+package app
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/openshift/osin"
+)
+
+type mockClientStorage struct {
+	osin.Storage
+	cl osin.Client
+}
+
+func (m mockClientStorage) Clone() osin.Storage {
+	return m
+}
+
+func (m mockClientStorage) Close() {}
+
+func (m mockClientStorage) GetClient(id string) (osin.Client, error) {
+	return m.cl, nil
+}
+
+func TestAuthorize_RefusesPlainChallenge(t *testing.T) {
+	cl := &osin.DefaultClient{Id: "test", RedirectUri: "https://app.example.com/callback"}
+	s := osin.NewServer(osin.NewServerConfig(), mockClientStorage{cl: cl})
+	h := oauthHandler{ia: &indieAuth{os: s}}
+
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	tests := map[string]string{
+		"plain":          "plain",
+		"missing method": "",
+	}
+	for name, method := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := url.Values{}
+			q.Set("response_type", "code")
+			q.Set("client_id", cl.Id)
+			q.Set("redirect_uri", cl.RedirectUri)
+			q.Set("code_challenge", challenge)
+			if len(method) > 0 {
+				q.Set("code_challenge_method", method)
+			}
+			r := httptest.NewRequest("GET", "/oauth/authorize?"+q.Encode(), nil)
+			w := httptest.NewRecorder()
+			h.Authorize(w, r)
+			if w.Code < 400 {
+				t.Errorf("Authorize() code = %d, want an error for the %q code challenge method", w.Code, method)
+			}
+		})
+	}
+}
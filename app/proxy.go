@@ -0,0 +1,247 @@
+package app
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/media"
+	"github.com/go-chi/chi"
+)
+
+// proxyTimeOut is how long the proxy waits for a remote media file
+const proxyTimeOut = 30 * time.Second
+
+// httpGetter is the part of the HTTP client used for fetching the remote media
+type httpGetter interface {
+	Get(string) (*http.Response, error)
+}
+
+// mediaProxy serves the remote media through FedBOX, so the clients don't connect to the remote servers
+type mediaProxy struct {
+	baseIRI pub.IRI
+	key     []byte
+	cache   *media.Cache
+	maxSize int64
+	// NOTE(marius): this is not a go-ap/client instance, as that sends all the requests through the same
+	// package level HTTP client, which can't get the dialer refusing the internal addresses without changing
+	// the federation requests too, and it asks for ActivityPub documents instead of the media files
+	cl httpGetter
+}
+
+func (p mediaProxy) sign(u string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(u))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// IRI returns the proxy IRI for a remote media IRI. The IRI is signed so the proxy can't be used for other URLs.
+func (p mediaProxy) IRI(iri pub.IRI) pub.IRI {
+	if len(iri) == 0 || iri.Contains(p.baseIRI, false) {
+		return iri
+	}
+	u := iri.String()
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		return iri
+	}
+	return p.baseIRI.AddPath("proxy/" + p.sign(u) + "/" + base64.RawURLEncoding.EncodeToString([]byte(u)))
+}
+
+func validProxyType(mt string) bool {
+	mt = strings.ToLower(mt)
+	if strings.HasPrefix(mt, "image/svg") {
+		return false
+	}
+	return strings.HasPrefix(mt, "image/") || strings.HasPrefix(mt, "video/") || strings.HasPrefix(mt, "audio/")
+}
+
+// Serve serves a remote media file, from the cache if possible
+func (p mediaProxy) Serve(w http.ResponseWriter, r *http.Request) {
+	if err := requireScope(r, ScopeRead); err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "url"))
+	if err != nil {
+		errors.HandleError(errors.NotFoundf("invalid media URL")).ServeHTTP(w, r)
+		return
+	}
+	u := string(raw)
+	if !hmac.Equal([]byte(p.sign(u)), []byte(chi.URLParam(r, "sig"))) {
+		errors.HandleError(errors.NotFoundf("invalid media URL")).ServeHTTP(w, r)
+		return
+	}
+
+	f, mt, err := p.cache.Open(u)
+	if err != nil {
+		resp, err := p.cl.Get(u)
+		if err != nil {
+			errors.HandleError(errors.NewNotFound(err, "unable to load %s", u)).ServeHTTP(w, r)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errors.HandleError(errors.NotFoundf("unable to load %s: %s", u, resp.Status)).ServeHTTP(w, r)
+			return
+		}
+		if resp.ContentLength > p.maxSize {
+			errors.HandleError(errors.NotValidf("%s is too large", u)).ServeHTTP(w, r)
+			return
+		}
+		br := bufio.NewReader(resp.Body)
+		sniff, _ := br.Peek(512)
+		mt = pub.MimeType(http.DetectContentType(sniff))
+		if strings.HasPrefix(string(mt), "application/octet-stream") {
+			mt = pub.MimeType(resp.Header.Get("Content-Type"))
+		}
+		if !validProxyType(string(mt)) {
+			errors.HandleError(errors.NotValidf("invalid media type %s for %s", mt, u)).ServeHTTP(w, r)
+			return
+		}
+		if f, err = p.cache.Save(u, mt, br, p.maxSize); err != nil {
+			errors.HandleError(errors.NewNotValid(err, "unable to cache %s", u)).ServeHTTP(w, r)
+			return
+		}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", string(mt))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// media returns a copy of the item, with its remote media IRIs replaced with proxy ones
+func (p mediaProxy) media(it pub.Item) pub.Item {
+	if pub.IsNil(it) {
+		return it
+	}
+	if iri, ok := it.(pub.IRI); ok {
+		return p.IRI(iri)
+	}
+	if it.IsCollection() {
+		switch col := it.(type) {
+		case pub.ItemCollection:
+			return p.mediaItems(col)
+		case *pub.ItemCollection:
+			items := p.mediaItems(*col)
+			return &items
+		}
+		return it
+	}
+	var res pub.Item = it
+	if it.IsLink() {
+		pub.OnLink(it, func(l *pub.Link) error {
+			cp := *l
+			cp.Href = p.IRI(l.Href)
+			res = &cp
+			return nil
+		})
+		return res
+	}
+	pub.OnObject(it, func(o *pub.Object) error {
+		if u, ok := o.URL.(pub.IRI); ok {
+			cp := *o
+			cp.URL = p.IRI(u)
+			res = &cp
+		}
+		return nil
+	})
+	return res
+}
+
+func (p mediaProxy) mediaItems(col pub.ItemCollection) pub.ItemCollection {
+	res := make(pub.ItemCollection, len(col))
+	for i, m := range col {
+		res[i] = p.media(m)
+	}
+	return res
+}
+
+// rewrite returns a copy of it, with the remote media IRIs of the icons, images and attachments replaced
+// with proxy ones. The item itself is not changed, as it can be shared with the caches and with other requests.
+func (p *mediaProxy) rewrite(it pub.Item) pub.Item {
+	if p == nil || pub.IsNil(it) || it.IsLink() {
+		return it
+	}
+	if it.IsCollection() {
+		return p.rewriteCollection(it)
+	}
+	var res pub.Item = it
+	typ := it.GetType()
+	switch {
+	case pub.ActivityTypes.Contains(typ):
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			cp := *a
+			cp.Object = p.rewrite(a.Object)
+			res = &cp
+			return nil
+		})
+	case pub.ActorTypes.Contains(typ):
+		pub.OnActor(it, func(a *pub.Actor) error {
+			cp := *a
+			cp.Icon = p.media(a.Icon)
+			cp.Image = p.media(a.Image)
+			cp.Attachment = p.media(a.Attachment)
+			res = &cp
+			return nil
+		})
+	default:
+		pub.OnObject(it, func(o *pub.Object) error {
+			cp := *o
+			cp.Icon = p.media(o.Icon)
+			cp.Image = p.media(o.Image)
+			cp.Attachment = p.media(o.Attachment)
+			res = &cp
+			return nil
+		})
+	}
+	return res
+}
+
+func (p *mediaProxy) rewriteItems(col pub.ItemCollection) pub.ItemCollection {
+	res := make(pub.ItemCollection, len(col))
+	for i, el := range col {
+		res[i] = p.rewrite(el)
+	}
+	return res
+}
+
+func (p *mediaProxy) rewriteCollection(it pub.Item) pub.Item {
+	switch col := it.(type) {
+	case pub.ItemCollection:
+		return p.rewriteItems(col)
+	case *pub.ItemCollection:
+		items := p.rewriteItems(*col)
+		return &items
+	case *pub.OrderedCollection:
+		cp := *col
+		cp.OrderedItems = p.rewriteItems(col.OrderedItems)
+		return &cp
+	case *pub.OrderedCollectionPage:
+		cp := *col
+		cp.OrderedItems = p.rewriteItems(col.OrderedItems)
+		return &cp
+	case *pub.Collection:
+		cp := *col
+		cp.Items = p.rewriteItems(col.Items)
+		return &cp
+	case *pub.CollectionPage:
+		cp := *col
+		cp.Items = p.rewriteItems(col.Items)
+		return &cp
+	}
+	return it
+}
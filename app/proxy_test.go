@@ -0,0 +1,90 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestMediaProxy_IRI(t *testing.T) {
+	p := mediaProxy{baseIRI: "https://fedbox.example.com", key: []byte("secret")}
+
+	local := pub.IRI("https://fedbox.example.com/media/abc")
+	if got := p.IRI(local); got != local {
+		t.Errorf("Local IRI %s should not be proxied, got %s", local, got)
+	}
+	remote := pub.IRI("https://remote.example.com/avatar.png")
+	got := p.IRI(remote)
+	if !strings.HasPrefix(got.String(), "https://fedbox.example.com/proxy/") {
+		t.Errorf("Remote IRI %s should be proxied, got %s", remote, got)
+	}
+	if again := p.IRI(got); again != got {
+		t.Errorf("Proxied IRI %s should not be proxied again, got %s", got, again)
+	}
+	other := mediaProxy{baseIRI: p.baseIRI, key: []byte("other")}
+	if other.IRI(remote) == got {
+		t.Errorf("Proxy IRIs signed with different keys should be different")
+	}
+}
+
+func TestMediaProxy_rewrite(t *testing.T) {
+	p := &mediaProxy{baseIRI: "https://fedbox.example.com", key: []byte("secret")}
+	ob := &pub.Object{
+		ID:   "https://remote.example.com/notes/1",
+		Type: pub.NoteType,
+		Attachment: pub.ItemCollection{
+			&pub.Object{Type: pub.ImageType, URL: pub.IRI("https://remote.example.com/1.png")},
+		},
+	}
+	attachmentURL := func(it pub.Item) string {
+		u := ""
+		pub.OnObject(it, func(o *pub.Object) error {
+			pub.OnCollectionIntf(o.Attachment, func(c pub.CollectionInterface) error {
+				pub.OnObject(c.Collection()[0], func(a *pub.Object) error {
+					u = a.URL.GetLink().String()
+					return nil
+				})
+				return nil
+			})
+			return nil
+		})
+		return u
+	}
+
+	got := p.rewrite(ob)
+	if got.GetLink() != "https://remote.example.com/notes/1" {
+		t.Errorf("The object ID should not be rewritten, got %s", got.GetLink())
+	}
+	if u := attachmentURL(got); !strings.HasPrefix(u, "https://fedbox.example.com/proxy/") {
+		t.Errorf("The attachment URL should be proxied, got %s", u)
+	}
+	if u := attachmentURL(ob); u != "https://remote.example.com/1.png" {
+		t.Errorf("The original object should not be changed, its attachment URL is %s", u)
+	}
+
+	col := &pub.OrderedCollection{ID: "https://fedbox.example.com/inbox", Type: pub.OrderedCollectionType, OrderedItems: pub.ItemCollection{ob}}
+	rw, ok := p.rewrite(col).(*pub.OrderedCollection)
+	if !ok || len(rw.OrderedItems) != 1 {
+		t.Fatalf("The rewritten collection should be an ordered collection with one item, got %#v", rw)
+	}
+	if rw.OrderedItems[0] == pub.Item(ob) || col.OrderedItems[0] != pub.Item(ob) {
+		t.Errorf("The items of the rewritten collection should be copies of the original ones")
+	}
+}
+
+func TestValidProxyType(t *testing.T) {
+	tests := map[string]bool{
+		"image/png":                true,
+		"video/mp4":                true,
+		"audio/mpeg":               true,
+		"image/svg+xml":            false,
+		"text/html":                false,
+		"application/octet-stream": false,
+	}
+	for mt, want := range tests {
+		if got := validProxyType(mt); got != want {
+			t.Errorf("validProxyType(%s) = %t, want %t", mt, got, want)
+		}
+	}
+}
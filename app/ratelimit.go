@@ -0,0 +1,95 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-ap/auth"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/ratelimit"
+	h "github.com/go-ap/handlers"
+)
+
+type rateCategory string
+
+const (
+	rateRead     rateCategory = "read"
+	rateC2S      rateCategory = "c2s"
+	rateS2S      rateCategory = "s2s"
+	rateAuth     rateCategory = "auth"
+	rateRegister rateCategory = "register"
+)
+
+// requestRateCategory returns the limit which applies to the request
+func requestRateCategory(r *http.Request) rateCategory {
+	if r.URL.Path == "/oauth/register" {
+		return rateRegister
+	}
+	if strings.HasPrefix(r.URL.Path, "/oauth/") {
+		return rateAuth
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return rateRead
+	}
+	if path.Base(r.URL.Path) == string(h.Inbox) {
+		return rateS2S
+	}
+	return rateC2S
+}
+
+// rateLimitKey returns the key the request is counted under: the authenticated actor,
+// or the IP of the anonymous requests. The requests to the OAuth2 endpoints and the client
+// registrations are always counted for each IP.
+func rateLimitKey(r *http.Request, cat rateCategory) string {
+	if cat != rateAuth && cat != rateRegister {
+		if act, ok := auth.ActorContext(r.Context()); ok && !act.GetLink().Equals(auth.AnonymousActor.ID, false) {
+			return "actor:" + act.GetLink().String()
+		}
+	}
+	// NOTE(marius): the RealIP middleware already replaced the address of the requests from the trusted proxies
+	return "ip:" + requestIP(r)
+}
+
+// oauthClientID returns the client of the requests to the OAuth2 endpoints
+func oauthClientID(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return r.FormValue("client_id")
+}
+
+// RateLimit limits the rate of the requests with the limits from the configuration,
+// separate for the reads, the C2S and the S2S posts, and the OAuth2 endpoints.
+// The requests to the OAuth2 endpoints need to be allowed by both the limit of their IP and the one of
+// their client, so they can't get a new limit with each made up client_id.
+// The limited requests receive a 429 response, with the seconds after which they can be retried.
+func RateLimit(conf config.Options) func(http.Handler) http.Handler {
+	limiters := map[rateCategory]*ratelimit.Limiter{
+		rateRead:     ratelimit.New(conf.RateLimitRead),
+		rateC2S:      ratelimit.New(conf.RateLimitC2S),
+		rateS2S:      ratelimit.New(conf.RateLimitS2S),
+		rateAuth:     ratelimit.New(conf.RateLimitAuth),
+		rateRegister: ratelimit.New(conf.RateLimitRegister),
+	}
+	clients := ratelimit.New(conf.RateLimitAuthClient)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cat := requestRateCategory(r)
+			ok, wait := limiters[cat].Allow(rateLimitKey(r, cat))
+			if ok && cat == rateAuth {
+				if client := oauthClientID(r); len(client) > 0 {
+					ok, wait = clients.Allow("client:" + client)
+				}
+			}
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
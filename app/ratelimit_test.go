@@ -0,0 +1,110 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/ratelimit"
+)
+
+func TestRequestRateCategory(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		want   rateCategory
+	}{
+		{method: http.MethodGet, url: "/actors/jdoe/outbox", want: rateRead},
+		{method: http.MethodPost, url: "/actors/jdoe/outbox", want: rateC2S},
+		{method: http.MethodPost, url: "/uploadMedia", want: rateC2S},
+		{method: http.MethodPost, url: "/actors/jdoe/inbox", want: rateS2S},
+		{method: http.MethodPost, url: "/oauth/login", want: rateAuth},
+		{method: http.MethodGet, url: "/oauth/authorize", want: rateAuth},
+		{method: http.MethodPost, url: "/oauth/register", want: rateRegister},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			if got := requestRateCategory(r); got != tt.want {
+				t.Errorf("requestRateCategory() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	conf := config.Options{
+		RateLimitRead: ratelimit.Limit{Rate: 1, Burst: 2},
+		RateLimitC2S:  ratelimit.Limit{Rate: 1, Burst: 1},
+	}
+	handler := RateLimit(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(method, url, addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do(http.MethodGet, "/", "10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("expected the read %d to be allowed, got %d", i, w.Code)
+		}
+	}
+	w := do(http.MethodGet, "/", "10.0.0.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the read over the limit to get 429, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("expected Retry-After: 1, got %q", ra)
+	}
+	if w := do(http.MethodGet, "/", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the reads from another IP to be allowed, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/actors/jdoe/outbox", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the posts to have a separate limit, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/actors/jdoe/inbox", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the inbox posts to be unlimited, got %d", w.Code)
+	}
+}
+
+func TestRateLimit_AuthClients(t *testing.T) {
+	conf := config.Options{
+		RateLimitAuth:       ratelimit.Limit{Rate: 1, Burst: 2},
+		RateLimitAuthClient: ratelimit.Limit{Rate: 1, Burst: 3},
+	}
+	handler := RateLimit(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(client, addr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/oauth/authorize?client_id="+client, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// a new client_id for each request doesn't get around the limit of the IP
+	for i, client := range []string{"a", "b"} {
+		if code := do(client, "10.0.0.1:1234"); code != http.StatusOK {
+			t.Fatalf("expected the request %d to be allowed, got %d", i, code)
+		}
+	}
+	if code := do("c", "10.0.0.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the request with another client from the same IP to get 429, got %d", code)
+	}
+
+	// and using the same client from other IPs doesn't get around the limit of the client
+	for i, addr := range []string{"10.0.0.2:1234", "10.0.0.3:1234", "10.0.0.4:1234"} {
+		if code := do("d", addr); code != http.StatusOK {
+			t.Fatalf("expected the request %d to be allowed, got %d", i, code)
+		}
+	}
+	if code := do("d", "10.0.0.5:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the request from another IP with the same client to get 429, got %d", code)
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNets returns the networks from the list of addresses, in CIDR notation or single IPs
+func parseNets(list []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, n := range list {
+		if _, ipNet, err := net.ParseCIDR(n); err == nil {
+			nets = append(nets, ipNet)
+		} else if ip := net.ParseIP(n); ip != nil {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+	return nets
+}
+
+func netsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP replaces the address of the requests which come through the trusted proxies with the address of the
+// client from the X-Forwarded-For or the X-Real-IP headers. Anyone can set these headers, so they are ignored
+// for the requests which come directly from the clients.
+func RealIP(trusted []string) func(http.Handler) http.Handler {
+	proxies := parseNets(trusted)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedIP(r, proxies); len(ip) > 0 {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the address of the client the trusted proxies forwarded the request for,
+// or an empty string when the request doesn't come from one of them.
+func forwardedIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	// NOTE(marius): the requests on unix sockets have no IP, and only come from the reverse proxy in front of us
+	if ip := net.ParseIP(host); ip != nil && !netsContain(proxies, ip) {
+		return ""
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		// NOTE(marius): every proxy appends the address it received the request from, so the first one
+		// from the right which is not a trusted proxy is the client, the ones before it can be forged
+		addrs := strings.Split(strings.Join(xff, ","), ",")
+		client := ""
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !netsContain(proxies, ip) {
+				break
+			}
+		}
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct",
+			remote: "203.0.113.1:1234",
+			want:   "203.0.113.1:1234",
+		},
+		{
+			name:    "spoofed by a client",
+			remote:  "203.0.113.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Real-IP": "198.51.100.7"},
+			want:    "203.0.113.1:1234",
+		},
+		{
+			name:    "trusted proxy",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "forged addresses before the one the proxy appended",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.1"},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "chain of trusted proxies",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.1, 10.0.0.2"},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "X-Real-IP from a trusted proxy",
+			remote:  "127.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "203.0.113.1"},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "unix socket",
+			remote:  "@",
+			headers: map[string]string{"X-Real-IP": "203.0.113.1"},
+			want:    "203.0.113.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP([]string{"127.0.0.0/8", "10.0.0.2"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RealIP() address = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+// The errors of the client registration, from RFC 7591
+const (
+	errInvalidRedirectURI    = "invalid_redirect_uri"
+	errInvalidClientMetadata = "invalid_client_metadata"
+)
+
+// The authentication methods of the clients at the token endpoint
+const (
+	authMethodNone  = "none"
+	authMethodBasic = "client_secret_basic"
+	authMethodPost  = "client_secret_post"
+)
+
+// maxRegistrationSize is the maximum size, in bytes, of the client registration requests
+const maxRegistrationSize = 64 << 10
+
+// clientMetadata are the properties of a client, sent in the registration request
+type clientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	Contacts                []string `json:"contacts,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+}
+
+// clientInformation is the response of a successful registration
+type clientInformation struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt int64  `json:"client_secret_expires_at"`
+	clientMetadata
+}
+
+type registrationError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e registrationError) Error() string {
+	return e.Description
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validRedirectURI accepts the https URIs, the http ones for the loopback addresses,
+// and the private-use schemes of the native applications, which are reverse domain names
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || len(u.Fragment) > 0 {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return len(u.Host) > 0
+	case "http":
+		return isLoopback(u.Hostname())
+	}
+	return strings.Contains(u.Scheme, ".")
+}
+
+func validURL(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && len(u.Host) > 0
+}
+
+func onlyValues(values []string, valid ...string) bool {
+	for _, v := range values {
+		found := false
+		for _, vv := range valid {
+			if v == vv {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the metadata and sets the default values of the missing properties
+func (m *clientMetadata) Validate() error {
+	if len(m.RedirectURIs) == 0 {
+		return registrationError{Code: errInvalidRedirectURI, Description: "missing redirect_uris"}
+	}
+	for _, uri := range m.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return registrationError{Code: errInvalidRedirectURI, Description: "invalid redirect URI " + uri}
+		}
+	}
+	for _, uri := range []string{m.ClientURI, m.LogoURI} {
+		if len(uri) > 0 && !validURL(uri) {
+			return registrationError{Code: errInvalidClientMetadata, Description: "invalid URL " + uri}
+		}
+	}
+	if len(m.ClientName) > 256 {
+		return registrationError{Code: errInvalidClientMetadata, Description: "client_name is too long"}
+	}
+	if len(m.TokenEndpointAuthMethod) == 0 {
+		m.TokenEndpointAuthMethod = authMethodBasic
+	}
+	if !onlyValues([]string{m.TokenEndpointAuthMethod}, authMethodNone, authMethodBasic, authMethodPost) {
+		return registrationError{Code: errInvalidClientMetadata, Description: "unsupported token_endpoint_auth_method " + m.TokenEndpointAuthMethod}
+	}
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{string(osin.AUTHORIZATION_CODE)}
+	}
+	if !onlyValues(m.GrantTypes, string(osin.AUTHORIZATION_CODE), string(osin.REFRESH_TOKEN)) {
+		return registrationError{Code: errInvalidClientMetadata, Description: "only the authorization_code and refresh_token grant types are supported"}
+	}
+	if len(m.ResponseTypes) == 0 {
+		m.ResponseTypes = []string{string(osin.CODE)}
+	}
+	if !onlyValues(m.ResponseTypes, string(osin.CODE)) {
+		return registrationError{Code: errInvalidClientMetadata, Description: "only the code response type is supported"}
+	}
+	scope, err := grantableScope(m.Scope)
+	if err != nil {
+		return registrationError{Code: errInvalidClientMetadata, Description: err.Error()}
+	}
+	m.Scope = scope
+	return nil
+}
+
+// registeredMetadata returns the metadata of the clients created by the dynamic registration.
+// The other clients, like the ones added with fedboxctl, have none.
+func registeredMetadata(cl osin.Client) (*clientMetadata, bool) {
+	if cl == nil {
+		return nil, false
+	}
+	raw, err := assertToBytes(cl.GetUserData())
+	if err != nil || len(raw) == 0 {
+		return nil, false
+	}
+	m := clientMetadata{}
+	if err = json.Unmarshal(raw, &m); err != nil || len(m.RedirectURIs) == 0 {
+		return nil, false
+	}
+	return &m, true
+}
+
+// allowsScope returns true if the scope contains only the scopes the client registered
+func (m clientMetadata) allowsScope(scope string) bool {
+	return onlyValues(strings.Fields(scope), strings.Fields(m.Scope)...)
+}
+
+// checkRegisteredAuthorize refuses the authorization requests of the registered clients
+// for the response types and the scopes they didn't register. It returns the OAuth2 error code.
+func checkRegisteredAuthorize(cl osin.Client, typ osin.AuthorizeRequestType, scope string) (string, error) {
+	m, ok := registeredMetadata(cl)
+	if !ok {
+		return "", nil
+	}
+	if !onlyValues([]string{string(typ)}, m.ResponseTypes...) {
+		return osin.E_UNSUPPORTED_RESPONSE_TYPE, errors.Newf("the client did not register the %s response type", typ)
+	}
+	if !m.allowsScope(scope) {
+		return osin.E_INVALID_SCOPE, errors.Newf("the client registered only the %q scope", m.Scope)
+	}
+	return "", nil
+}
+
+// checkRegisteredAccess refuses the token requests of the registered clients
+// for the grant types and the scopes they didn't register. It returns the OAuth2 error code.
+func checkRegisteredAccess(cl osin.Client, typ osin.AccessRequestType, scope string) (string, error) {
+	m, ok := registeredMetadata(cl)
+	if !ok {
+		return "", nil
+	}
+	if !onlyValues([]string{string(typ)}, m.GrantTypes...) {
+		return osin.E_UNAUTHORIZED_CLIENT, errors.Newf("the client did not register the %s grant type", typ)
+	}
+	if !m.allowsScope(scope) {
+		return osin.E_INVALID_SCOPE, errors.Newf("the client registered only the %q scope", m.Scope)
+	}
+	return "", nil
+}
+
+// registrationAllowed checks the registration policy from the configuration
+func registrationAllowed(policy string, r *http.Request) error {
+	switch policy {
+	case config.RegistrationOpen:
+		return nil
+	case config.RegistrationToken:
+		if scope, ok := r.Context().Value(scopeKey).(string); ok && hasScope(scope, ScopeAdmin) {
+			return nil
+		}
+		return errors.Unauthorizedf("the client registration requires a token with the %s scope", ScopeAdmin)
+	}
+	return errors.Forbiddenf("the client registration is disabled")
+}
+
+// clientActor returns the Application actor of a registered client
+func clientActor(self pub.Item, m clientMetadata) *pub.Actor {
+	now := time.Now().UTC()
+	name := m.ClientName
+	if len(name) == 0 {
+		name = "oauth-client-app"
+	}
+	p := pub.Actor{
+		Type:         pub.ApplicationType,
+		AttributedTo: self.GetLink(),
+		Audience:     pub.ItemCollection{pub.PublicNS},
+		Generator:    self.GetLink(),
+		Published:    now,
+		Summary: pub.NaturalLanguageValues{
+			{pub.NilLangRef, pub.Content("OAuth2 dynamically registered client")},
+		},
+		Updated: now,
+		PreferredUsername: pub.NaturalLanguageValues{
+			{pub.NilLangRef, pub.Content(name)},
+		},
+		URL: pub.IRI(m.RedirectURIs[0]),
+	}
+	if len(m.ClientURI) > 0 {
+		p.URL = pub.IRI(m.ClientURI)
+	}
+	if len(m.LogoURI) > 0 {
+		p.Icon = pub.IRI(m.LogoURI)
+	}
+	return &p
+}
+
+func writeRegistrationError(w http.ResponseWriter, err registrationError) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusBadRequest, err)
+}
+
+// HandleRegister serves POST /oauth/register requests, the dynamic client registration from RFC 7591.
+// It creates the Application actor of the client, and the OAuth2 client with the same id.
+func (h *oauthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if err := registrationAllowed(h.registration, r); err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	if h.ia.st == nil {
+		errors.HandleError(errors.NotImplementedf("the OAuth2 storage does not support creating clients")).ServeHTTP(w, r)
+		return
+	}
+
+	m := clientMetadata{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegistrationSize)).Decode(&m); err != nil {
+		writeRegistrationError(w, registrationError{Code: errInvalidClientMetadata, Description: "invalid JSON document"})
+		return
+	}
+	if err := m.Validate(); err != nil {
+		writeRegistrationError(w, err.(registrationError))
+		return
+	}
+
+	secret := ""
+	if m.TokenEndpointAuthMethod != authMethodNone {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			errors.HandleError(errors.Annotatef(err, "unable to generate the client secret")).ServeHTTP(w, r)
+			return
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	self := activitypub.Self(h.ia.baseIRI)
+	p := clientActor(self, m)
+	create := &pub.Activity{
+		Type:         pub.CreateType,
+		AttributedTo: self.GetLink(),
+		Actor:        self.GetLink(),
+		To:           pub.ItemCollection{pub.PublicNS},
+		CC:           pub.ItemCollection{self.GetLink()},
+		Updated:      p.Published,
+		Object:       p,
+	}
+	processor, _, err := processing.New(
+		processing.SetIRI(h.ia.baseIRI, InternalIRI),
+		processing.SetStorage(h.ia.ap),
+		processing.SetIDGenerator(GenerateID(h.ia.baseIRI)),
+	)
+	if err != nil {
+		errors.HandleError(errors.Annotatef(err, "unable to initialize the processor")).ServeHTTP(w, r)
+		return
+	}
+	if _, err = processor.ProcessClientActivity(create); err != nil {
+		h.logger.Errorf("Unable to save the client application: %s", err)
+		errors.HandleError(errors.Annotatef(err, "unable to save the client application")).ServeHTTP(w, r)
+		return
+	}
+
+	id := path.Base(p.GetLink().String())
+	userData, _ := json.Marshal(m)
+	cl := osin.DefaultClient{
+		Id:          id,
+		Secret:      secret,
+		RedirectUri: strings.Join(m.RedirectURIs, "\n"),
+		UserData:    userData,
+	}
+	if err = h.ia.st.CreateClient(&cl); err != nil {
+		h.logger.Errorf("Unable to save the client %s: %s", id, err)
+		// NOTE(marius): the id of the client is the one of its actor, which is created first,
+		// so the actor is deleted when the client can't be saved, like fedboxctl removes the clients
+		del := &pub.Activity{
+			Type:   pub.DeleteType,
+			Actor:  self.GetLink(),
+			To:     pub.ItemCollection{pub.PublicNS},
+			CC:     pub.ItemCollection{self.GetLink()},
+			Object: p.GetLink(),
+		}
+		if _, derr := processor.ProcessClientActivity(del); derr != nil {
+			h.logger.Errorf("Unable to remove the client application %s: %s", p.GetLink(), derr)
+		}
+		errors.HandleError(errors.Annotatef(err, "unable to save the client")).ServeHTTP(w, r)
+		return
+	}
+	h.logger.WithField("client", id).Infof("Registered OAuth2 client %q", m.ClientName)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, clientInformation{
+		ClientID:         id,
+		ClientSecret:     secret,
+		ClientIDIssuedAt: p.Published.Unix(),
+		clientMetadata:   m,
+	})
+}
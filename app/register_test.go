@@ -0,0 +1,102 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/openshift/osin"
+)
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/callback":   true,
+		"http://127.0.0.1:8080/callback": true,
+		"http://localhost/callback":      true,
+		"com.example.app:/callback":      true,
+		"http://example.com/callback":    false,
+		"https://example.com/cb#token":   false,
+		"javascript:alert(1)":            false,
+		"/callback":                      false,
+	}
+	for uri, want := range tests {
+		if got := validRedirectURI(uri); got != want {
+			t.Errorf("validRedirectURI(%q) = %t, want %t", uri, got, want)
+		}
+	}
+}
+
+func TestClientMetadata_Validate(t *testing.T) {
+	m := clientMetadata{RedirectURIs: []string{"https://example.com/callback"}}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Validate() error = %s", err)
+	}
+	if m.TokenEndpointAuthMethod != authMethodBasic || m.Scope != DefaultScope || len(m.GrantTypes) != 1 || len(m.ResponseTypes) != 1 {
+		t.Errorf("expected the default values to be set, got %#v", m)
+	}
+
+	tests := []struct {
+		name string
+		m    clientMetadata
+		code string
+	}{
+		{name: "no redirect", m: clientMetadata{}, code: errInvalidRedirectURI},
+		{name: "http redirect", m: clientMetadata{RedirectURIs: []string{"http://example.com"}}, code: errInvalidRedirectURI},
+		{name: "admin scope", m: clientMetadata{RedirectURIs: []string{"https://example.com"}, Scope: "admin"}, code: errInvalidClientMetadata},
+		{name: "password grant", m: clientMetadata{RedirectURIs: []string{"https://example.com"}, GrantTypes: []string{"password"}}, code: errInvalidClientMetadata},
+		{name: "auth method", m: clientMetadata{RedirectURIs: []string{"https://example.com"}, TokenEndpointAuthMethod: "private_key_jwt"}, code: errInvalidClientMetadata},
+	}
+	for _, tt := range tests {
+		err := tt.m.Validate()
+		rerr, ok := err.(registrationError)
+		if !ok || rerr.Code != tt.code {
+			t.Errorf("%s: Validate() error = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestRegistrationAllowed(t *testing.T) {
+	r := httptest.NewRequest("POST", "/oauth/register", nil)
+	if err := registrationAllowed(config.RegistrationClosed, r); err == nil {
+		t.Errorf("expected the registration to be refused when it's closed")
+	}
+	if err := registrationAllowed(config.RegistrationOpen, r); err != nil {
+		t.Errorf("expected the registration to be allowed when it's open, got %s", err)
+	}
+	if err := registrationAllowed(config.RegistrationToken, withScope(r, DefaultScope)); err == nil {
+		t.Errorf("expected the registration to require the %s scope", ScopeAdmin)
+	}
+	if err := registrationAllowed(config.RegistrationToken, withScope(r, "read admin")); err != nil {
+		t.Errorf("expected the registration to be allowed with the %s scope, got %s", ScopeAdmin, err)
+	}
+}
+
+func TestCheckRegisteredClient(t *testing.T) {
+	m := clientMetadata{RedirectURIs: []string{"https://example.com/callback"}, Scope: "read"}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Validate() error = %s", err)
+	}
+	userData, _ := json.Marshal(m)
+	registered := &osin.DefaultClient{Id: "registered", UserData: userData}
+	other := &osin.DefaultClient{Id: "other"}
+
+	if _, err := checkRegisteredAuthorize(registered, osin.CODE, "read"); err != nil {
+		t.Errorf("expected the registered response type and scope to be allowed, got %s", err)
+	}
+	if code, _ := checkRegisteredAuthorize(registered, osin.TOKEN, "read"); code != osin.E_UNSUPPORTED_RESPONSE_TYPE {
+		t.Errorf("expected a response type which was not registered to get %s, got %q", osin.E_UNSUPPORTED_RESPONSE_TYPE, code)
+	}
+	if code, _ := checkRegisteredAuthorize(registered, osin.CODE, "read write"); code != osin.E_INVALID_SCOPE {
+		t.Errorf("expected a scope which was not registered to get %s, got %q", osin.E_INVALID_SCOPE, code)
+	}
+	if _, err := checkRegisteredAccess(registered, osin.AUTHORIZATION_CODE, "read"); err != nil {
+		t.Errorf("expected the registered grant type to be allowed, got %s", err)
+	}
+	if code, _ := checkRegisteredAccess(registered, osin.PASSWORD, "read"); code != osin.E_UNAUTHORIZED_CLIENT {
+		t.Errorf("expected a grant type which was not registered to get %s, got %q", osin.E_UNAUTHORIZED_CLIENT, code)
+	}
+	if _, err := checkRegisteredAccess(other, osin.PASSWORD, "read write"); err != nil {
+		t.Errorf("expected the clients which were not registered dynamically not to be restricted, got %s", err)
+	}
+}
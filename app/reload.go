@@ -0,0 +1,135 @@
+package app
+
+import (
+	"crypto/tls"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// liveOptions keeps the configuration which is applied without restarting,
+// shared between all the copies of the FedBOX instance
+type liveOptions struct {
+	mu   sync.RWMutex
+	conf config.Options
+}
+
+func (l *liveOptions) get() config.Options {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.conf
+}
+
+func (l *liveOptions) set(conf config.Options) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conf = conf
+}
+
+// liveKeys are the configuration options which can be changed by reloading the configuration
+var liveKeys = map[string]bool{
+	"LogLevel": true,
+	"Cache":    true,
+	"CertPath": true,
+	"KeyPath":  true,
+}
+
+// configChanges returns the names of the options which differ between old and new
+func configChanges(old, new config.Options) []string {
+	changed := make([]string, 0)
+	ov := reflect.ValueOf(old)
+	nv := reflect.ValueOf(new)
+	for i := 0; i < ov.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, ov.Type().Field(i).Name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// certLoader keeps the TLS certificate, so it can be replaced without restarting the server
+type certLoader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Load reads the certificate and the key from the files, keeping the current ones if that fails
+func (c *certLoader) Load(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the TLS certificate %s", certPath)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// GetCertificate is used as the tls.Config.GetCertificate of the server
+func (c *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cert == nil {
+		return nil, errors.NotFoundf("no TLS certificate loaded")
+	}
+	return c.cert, nil
+}
+
+// Reload loads the configuration again from the environment and the .env files, and applies the changes
+// which are safe while running: the log level, the cache and the TLS certificates.
+// It returns the options which changed, but need a restart to be applied.
+func (f *FedBOX) Reload() ([]string, error) {
+	cur := f.live.get()
+	conf, err := config.LoadFromEnv(cur.Env, cur.TimeOut)
+	if err != nil {
+		return nil, err
+	}
+	changed := configChanges(cur, conf)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	restart := make([]string, 0)
+	for _, name := range changed {
+		// NOTE(marius): when the server was started without TLS, enabling it requires a restart
+		tlsOff := f.certs == nil && (name == "CertPath" || name == "KeyPath")
+		if !liveKeys[name] || tlsOff {
+			restart = append(restart, name)
+		}
+	}
+	if f.certs != nil && (conf.CertPath != cur.CertPath || conf.KeyPath != cur.KeyPath) {
+		if err = f.certs.Load(conf.CertPath, conf.KeyPath); err != nil {
+			conf.CertPath, conf.KeyPath = cur.CertPath, cur.KeyPath
+			f.errFn("%s", err)
+		}
+	}
+	if conf.LogLevel != cur.LogLevel {
+		if l, ok := f.logger.(*logrus.Logger); ok {
+			l.SetLevel(logrus.Level(conf.LogLevel))
+		}
+	}
+	if conf.Cache != cur.Cache {
+		f.setCacheEnabled(conf.Cache)
+	}
+	f.live.set(conf)
+
+	for _, name := range changed {
+		f.infFn("Configuration option %s changed", name)
+	}
+	return restart, nil
+}
+
+// setCacheEnabled toggles the cache of the application and the one of the storage, when it has one
+func (f *FedBOX) setCacheEnabled(enabled bool) {
+	if c, ok := f.caches.(interface{ SetEnabled(bool) }); ok {
+		c.SetEnabled(enabled)
+	}
+	if s, ok := f.Storage.(interface{ SetCacheEnabled(bool) }); ok {
+		s.SetCacheEnabled(enabled)
+	}
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/log"
+)
+
+func TestConfigChanges(t *testing.T) {
+	base := config.Options{
+		LogLevel:     log.InfoLevel,
+		TimeOut:      time.Second,
+		Listen:       "localhost:4000",
+		MetricsAllow: []string{"127.0.0.0/8"},
+	}
+	tests := []struct {
+		name   string
+		change func(*config.Options)
+		want   []string
+	}{
+		{name: "none", change: func(*config.Options) {}, want: []string{}},
+		{
+			name: "live",
+			change: func(o *config.Options) {
+				o.LogLevel = log.DebugLevel
+				o.TimeOut = time.Minute
+			},
+			want: []string{"LogLevel", "TimeOut"},
+		},
+		{
+			name: "restart",
+			change: func(o *config.Options) {
+				o.Listen = "localhost:4001"
+				o.MetricsAllow = []string{"10.0.0.0/8"}
+			},
+			want: []string{"Listen", "MetricsAllow"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			tt.change(&changed)
+			if got := configChanges(base, changed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,8 @@ package app
 import (
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/media"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/openshift/osin"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -16,6 +16,7 @@ func (f FedBOX) CollectionRoutes(descend bool) func(chi.Router) {
 			r.Method(http.MethodGet, "/", HandleCollection(f))
 			r.Method(http.MethodHead, "/", HandleCollection(f))
 			r.Method(http.MethodPost, "/", HandleRequest(f))
+			r.Get("/stream", HandleStream(f))
 
 			r.Route("/{id}", func(r chi.Router) {
 				r.Method(http.MethodGet, "/", HandleItem(f))
@@ -30,20 +31,41 @@ func (f FedBOX) CollectionRoutes(descend bool) func(chi.Router) {
 
 func (f FedBOX) Routes(baseURL string, os *osin.Server, l logrus.FieldLogger) func(chi.Router) {
 	return func(r chi.Router) {
-		r.Use(middleware.RealIP)
 		r.Use(CleanRequestPath)
+		r.Use(BearerFromQuery)
 		r.Use(ActorFromAuthHeader(os, f.Storage, l))
+		r.Use(RateLimit(f.conf))
+		r.Use(IndieAuthLinks(baseURL))
 
 		r.Method(http.MethodGet, "/", HandleItem(f))
 		r.Method(http.MethodHead, "/", HandleItem(f))
 		r.Route("/{collection}", f.CollectionRoutes(true))
 
 		baseIRI := pub.IRI(baseURL)
+		mh := mediaHandler{
+			fb:      f,
+			baseIRI: baseIRI,
+			blobs:   media.New(f.conf.MediaPath()),
+			maxSize: f.conf.MaxUploadSize,
+			repo:    f.Storage,
+
+			thumbnails: f.conf.ThumbnailSizes,
+			maxPixels:  f.conf.MaxImagePixels,
+		}
+		r.Post("/uploadMedia", mh.Upload)
+		r.Get("/media/{hash}", mh.Serve)
+		r.Head("/media/{hash}", mh.Serve)
+		r.Get("/ws", HandleWebSocket(f))
+		if f.proxy != nil {
+			r.Get("/proxy/{sig}/{url}", f.proxy.Serve)
+		}
+
 		ia := indieAuth{
 			baseIRI: baseIRI,
 			genID:   GenerateID(baseIRI),
 			os:      os,
 			ap:      f.Storage,
+			cl:      newClientInfoFetcher(),
 		}
 		if oauthStorage, ok := f.OAuthStorage.(ClientStorage); ok {
 			ia.st = oauthStorage
@@ -53,13 +75,24 @@ func (f FedBOX) Routes(baseURL string, os *osin.Server, l logrus.FieldLogger) fu
 			ia:      &ia,
 			loader:  f.Storage,
 			logger:  l,
+			guard:   newLoginGuard(f.Storage, l),
+			pending: newPendingLogins(),
+
+			registration: f.conf.ClientRegistration,
 		}
+		// Authorization server metadata, for the IndieAuth clients
+		r.Get("/"+metadataPath, h.HandleMetadata)
 		r.Route("/oauth", func(r chi.Router) {
 			// Authorization code endpoint
 			r.Get("/authorize", h.Authorize)
 			r.Post("/authorize", h.Authorize)
 			// Access token endpoint
 			r.Post("/token", h.Token)
+			// Token revocation and introspection endpoints
+			r.Post("/revoke", h.HandleRevoke)
+			r.Post("/introspect", h.HandleIntrospect)
+			// Dynamic client registration endpoint
+			r.Post("/register", h.HandleRegister)
 
 			r.Group(func(r chi.Router) {
 				r.Get("/login", h.ShowLogin)
@@ -67,6 +100,10 @@ func (f FedBOX) Routes(baseURL string, os *osin.Server, l logrus.FieldLogger) fu
 				r.Get("/pw", h.ShowChangePw)
 				r.Post("/pw", h.HandleChangePw)
 			})
+			r.Route("/2fa", func(r chi.Router) {
+				r.Post("/enroll", h.HandleEnroll2FA)
+				r.Post("/verify", h.HandleVerify2FA)
+			})
 		})
 
 		notFound := errors.HandleError(errors.NotFoundf("invalid url"))
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	h "github.com/go-ap/handlers"
+)
+
+// The scopes of the OAuth2 tokens
+const (
+	// ScopeRead allows loading the collections and the objects
+	ScopeRead = "read"
+	// ScopeWrite allows posting activities to the outbox of the actor, and uploading media
+	ScopeWrite = "write"
+	// ScopeFollow allows only the Follow, Accept, Reject and Block activities, and undoing them
+	ScopeFollow = "follow"
+	// ScopeAdmin allows posting activities to the outbox of the service actor
+	ScopeAdmin = "admin"
+	// ScopeProfile allows the IndieAuth clients to receive the profile information of the actor
+	ScopeProfile = "profile"
+)
+
+// DefaultScope is granted to the clients which don't request a scope
+const DefaultScope = ScopeRead + " " + ScopeWrite + " " + ScopeFollow
+
+// legacyScope is the scope fedboxctl gave to all the tokens it issued before the scopes were enforced.
+// They were used for administering the instance, so they keep the admin scope along with DefaultScope.
+const legacyScope = "scope"
+
+var validScopes = map[string]bool{ScopeRead: true, ScopeWrite: true, ScopeFollow: true, ScopeAdmin: true, ScopeProfile: true}
+
+// ParseScope validates the space separated scopes, and returns them sorted and without duplicates.
+// An empty scope is replaced by DefaultScope.
+func ParseScope(s string) (string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return DefaultScope, nil
+	}
+	seen := make(map[string]bool)
+	scopes := make([]string, 0, len(fields))
+	for _, sc := range fields {
+		if !validScopes[sc] {
+			return "", errors.NotValidf("invalid scope %q", sc)
+		}
+		if !seen[sc] {
+			seen[sc] = true
+			scopes = append(scopes, sc)
+		}
+	}
+	sort.Strings(scopes)
+	return strings.Join(scopes, " "), nil
+}
+
+// grantableScope validates the scope requested by an OAuth2 client.
+// The admin scope is refused, as it can only be granted with fedboxctl.
+func grantableScope(s string) (string, error) {
+	scope, err := ParseScope(s)
+	if err != nil {
+		return "", err
+	}
+	for _, sc := range strings.Fields(scope) {
+		if sc == ScopeAdmin {
+			return "", errors.NotValidf("the %s scope can not be requested by the clients", ScopeAdmin)
+		}
+	}
+	return scope, nil
+}
+
+// hasScope returns true if the granted scopes contain any of the wanted ones.
+// The tokens issued before the scopes were enforced have none of the valid scopes, and they are treated
+// as having DefaultScope, or DefaultScope and the admin scope for the ones issued by fedboxctl.
+func hasScope(granted string, want ...string) bool {
+	fields := strings.Fields(granted)
+	known := false
+	for _, g := range fields {
+		if validScopes[g] {
+			known = true
+			break
+		}
+	}
+	if !known {
+		fields = strings.Fields(DefaultScope)
+		if strings.TrimSpace(granted) == legacyScope {
+			fields = append(fields, ScopeAdmin)
+		}
+	}
+	for _, g := range fields {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type scopeCtxKey string
+
+// scopeKey is the context key of the scope of the OAuth2 token which authenticated the request
+const scopeKey = scopeCtxKey("__scope")
+
+func withScope(r *http.Request, scope string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopeKey, scope))
+}
+
+// requireScope returns an error if the request was authenticated with an OAuth2 token which has none of the scopes.
+// The requests authenticated otherwise, or not at all, are left to the authorization checks further on.
+func requireScope(r *http.Request, want ...string) error {
+	scope, ok := r.Context().Value(scopeKey).(string)
+	if !ok {
+		return nil
+	}
+	if hasScope(scope, want...) {
+		return nil
+	}
+	return errors.Forbiddenf("the token requires the %s scope", strings.Join(want, " or "))
+}
+
+// bearerToken returns the OAuth2 token from the Authorization header of the request
+func bearerToken(r *http.Request) string {
+	hdr := r.Header.Get("Authorization")
+	if len(hdr) < 7 || !strings.EqualFold(hdr[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(hdr[7:])
+}
+
+var followTypes = pub.ActivityVocabularyTypes{pub.FollowType, pub.AcceptType, pub.RejectType, pub.BlockType}
+
+// activityScopes returns the scopes which allow posting the activity to the collection
+func activityScopes(it pub.Item, collection, baseIRI pub.IRI) []string {
+	if collection.Equals(h.Outbox.IRI(baseIRI), false) {
+		return []string{ScopeAdmin}
+	}
+	typ := it.GetType()
+	if typ == pub.UndoType {
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			if a.Object != nil {
+				typ = a.Object.GetType()
+			}
+			return nil
+		})
+	}
+	if followTypes.Contains(typ) {
+		return []string{ScopeWrite, ScopeFollow}
+	}
+	return []string{ScopeWrite}
+}
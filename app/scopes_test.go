@@ -0,0 +1,104 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: DefaultScope},
+		{in: "read", want: "read"},
+		{in: "write read  read", want: "read write"},
+		{in: "admin follow", want: "admin follow"},
+		{in: "read scope", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseScope(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseScope(%q) error = %v, wantErr %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseScope(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if _, err := grantableScope("read admin"); err == nil {
+		t.Errorf("expected the clients not to be able to request the %s scope", ScopeAdmin)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := requireScope(r, ScopeAdmin); err != nil {
+		t.Errorf("expected the requests without a token not to be checked, got %s", err)
+	}
+	if err := requireScope(withScope(r, "read"), ScopeWrite); err == nil {
+		t.Errorf("expected the read token not to be allowed to write")
+	}
+	if err := requireScope(withScope(r, "follow"), ScopeWrite, ScopeFollow); err != nil {
+		t.Errorf("expected the follow token to be allowed, got %s", err)
+	}
+	if err := requireScope(withScope(r, "scope"), ScopeWrite); err != nil {
+		t.Errorf("expected the tokens without valid scopes to have the default scope, got %s", err)
+	}
+	if err := requireScope(withScope(r, ""), ScopeAdmin); err == nil {
+		t.Errorf("expected the tokens without valid scopes not to have the admin scope")
+	}
+	if err := requireScope(withScope(r, "scope"), ScopeAdmin); err != nil {
+		t.Errorf("expected the old fedboxctl tokens to keep the admin scope, got %s", err)
+	}
+}
+
+func TestActivityScopes(t *testing.T) {
+	base := pub.IRI("https://example.com")
+	outbox := pub.IRI("https://example.com/actors/jdoe/outbox")
+	follow := &pub.Activity{Type: pub.FollowType}
+	tests := []struct {
+		name       string
+		it         pub.Item
+		collection pub.IRI
+		want       []string
+	}{
+		{name: "create", it: &pub.Activity{Type: pub.CreateType}, collection: outbox, want: []string{ScopeWrite}},
+		{name: "follow", it: follow, collection: outbox, want: []string{ScopeWrite, ScopeFollow}},
+		{name: "undo follow", it: &pub.Activity{Type: pub.UndoType, Object: follow}, collection: outbox, want: []string{ScopeWrite, ScopeFollow}},
+		{name: "service", it: &pub.Activity{Type: pub.CreateType}, collection: "https://example.com/outbox", want: []string{ScopeAdmin}},
+	}
+	for _, tt := range tests {
+		got := activityScopes(tt.it, tt.collection, base)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: activityScopes() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: activityScopes() = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestReadScope_Streams(t *testing.T) {
+	handlers := map[string]http.Handler{
+		"stream":    HandleStream(FedBOX{}),
+		"websocket": HandleWebSocket(FedBOX{}),
+		"proxy":     http.HandlerFunc(mediaProxy{}.Serve),
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, withScope(httptest.NewRequest("GET", "/", nil), ScopeFollow))
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected the token without the %s scope to get %d, got %d", ScopeRead, http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/events"
+	h "github.com/go-ap/handlers"
+	"github.com/go-ap/jsonld"
+)
+
+// heartbeatInterval is how often a comment is sent on idle streams, so the proxies don't close them
+const heartbeatInterval = 30 * time.Second
+
+// lastEventID returns the ID of the last event the client received, from the Last-Event-ID header,
+// or from the lastEventId query parameter for the clients which can't set headers.
+func lastEventID(r *http.Request) uint64 {
+	val := r.Header.Get("Last-Event-ID")
+	if len(val) == 0 {
+		val = r.URL.Query().Get("lastEventId")
+	}
+	id, _ := strconv.ParseUint(val, 10, 64)
+	return id
+}
+
+// streamItems loads the item of the event, and returns it if it's visible for the audience
+func streamItems(fb FedBOX, e events.Event, audience ap.CompStrs) pub.ItemCollection {
+	ob, err := fb.Storage.Load(e.Item)
+	if err != nil || pub.IsNil(ob) {
+		return nil
+	}
+	items := pub.ItemCollection{ob}
+	if pub.IsItemCollection(ob) {
+		pub.OnCollectionIntf(ob, func(col pub.CollectionInterface) error {
+			items = col.Collection()
+			return nil
+		})
+	}
+	return filterItems(items, audience)
+}
+
+// HandleStream serves the items added to a collection as Server-Sent Events.
+// The clients which reconnect with the ID of the last event they received get the events they missed,
+// as long as the server still has them.
+func HandleStream(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := requireScope(r, ScopeRead); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			errors.HandleError(errors.NotImplementedf("streaming is not supported")).ServeHTTP(w, r)
+			return
+		}
+		typ := h.Typer.Type(r)
+		if !ap.ValidCollection(typ) {
+			errors.HandleError(errors.NotFoundf("collection '%s' not found", typ)).ServeHTTP(w, r)
+			return
+		}
+		f, err := ap.FromRequest(r, fb.Config().BaseURL)
+		if err != nil {
+			errors.HandleError(errors.NewNotValid(err, "unable to load filters from request")).ServeHTTP(w, r)
+			return
+		}
+		ap.LoadCollectionFilters(r, f)
+		audience := f.Audience()
+		col := pub.IRI(strings.TrimSuffix(reqURL(r), "/stream"))
+
+		sub, missed := events.Default.Subscribe(col, lastEventID(r))
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// NOTE(marius): nginx buffers the responses by default, which delays the events
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(e events.Event) error {
+			for _, it := range streamItems(fb, e, audience) {
+				if s, ok := it.(pub.HasRecipients); ok {
+					s.Clean()
+				}
+				it = fb.proxy.rewrite(it)
+				raw, err := jsonld.Marshal(it)
+				if err != nil {
+					fb.errFn("unable to marshal %s: %s", e.Item, err)
+					continue
+				}
+				if _, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, raw); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", 5000); err != nil {
+			return
+		}
+		flusher.Flush()
+		for _, e := range missed {
+			if err := send(e); err != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case e, ok := <-sub.C:
+				if !ok {
+					// NOTE(marius): the client didn't keep up with the events, or FedBOX is shutting down.
+					// It will reconnect and get the ones it missed using the ID of the last one it received.
+					return
+				}
+				if err := send(e); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLastEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   uint64
+	}{
+		{name: "none", url: "/actors/jdoe/inbox/stream", want: 0},
+		{name: "header", url: "/actors/jdoe/inbox/stream", header: "42", want: 42},
+		{name: "query", url: "/actors/jdoe/inbox/stream?lastEventId=7", want: 7},
+		{name: "header wins", url: "/actors/jdoe/inbox/stream?lastEventId=7", header: "42", want: 42},
+		{name: "invalid", url: "/actors/jdoe/inbox/stream", header: "abc", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if len(tt.header) > 0 {
+				r.Header.Set("Last-Event-ID", tt.header)
+			}
+			if got := lastEventID(r); got != tt.want {
+				t.Errorf("lastEventID() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/openshift/osin"
+)
+
+// authenticateClient loads the OAuth2 client of the request, from the basic authentication
+// or from the client_id and client_secret parameters, and checks its secret.
+// The public clients have no secret, and only send their id.
+func (h *oauthHandler) authenticateClient(r *http.Request) (osin.Client, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// NOTE(marius): RFC 6749 requires the credentials to be form encoded before the basic authentication
+		if v, err := url.QueryUnescape(id); err == nil {
+			id = v
+		}
+		if v, err := url.QueryUnescape(secret); err == nil {
+			secret = v
+		}
+	} else {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if len(id) == 0 {
+		return nil, false
+	}
+	cl, err := h.ia.os.Storage.GetClient(id)
+	if err != nil || cl == nil {
+		return nil, false
+	}
+	if !osin.CheckClientSecret(cl, secret) {
+		return nil, false
+	}
+	return cl, true
+}
+
+// publicClientAuth marks the token requests of the public clients, which send only their client_id,
+// as having an empty secret, as osin expects either the basic authentication or the client_secret parameter
+func publicClientAuth(r *http.Request) {
+	r.ParseForm()
+	if _, _, ok := r.BasicAuth(); ok || len(r.Form.Get("client_id")) == 0 {
+		return
+	}
+	if _, ok := r.Form["client_secret"]; !ok {
+		r.Form.Set("client_secret", "")
+	}
+}
+
+// loadToken loads the access data of an access or a refresh token, using the token_type_hint to decide
+// which one to try first
+func (h *oauthHandler) loadToken(tok, hint string) *osin.AccessData {
+	storage := h.ia.os.Storage
+	loaders := []func(string) (*osin.AccessData, error){storage.LoadAccess, storage.LoadRefresh}
+	if hint == "refresh_token" {
+		loaders[0], loaders[1] = loaders[1], loaders[0]
+	}
+	for _, load := range loaders {
+		if ad, err := load(tok); err == nil && ad != nil {
+			return ad
+		}
+	}
+	return nil
+}
+
+func sameClient(ad *osin.AccessData, cl osin.Client) bool {
+	return ad != nil && ad.Client != nil && cl != nil && ad.Client.GetId() == cl.GetId()
+}
+
+// HandleRevoke serves POST /oauth/revoke requests, from RFC 7009.
+// It removes the access token and its refresh token, when the token belongs to the authenticated client.
+// As the RFC requires, it responds successfully also for the invalid tokens.
+func (h *oauthHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	resp := h.ia.os.NewResponse()
+	defer resp.Close()
+
+	cl, ok := h.authenticateClient(r)
+	if !ok {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	tok := r.PostFormValue("token")
+	if len(tok) == 0 {
+		resp.SetError(osin.E_INVALID_REQUEST, "missing token")
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	if ad := h.loadToken(tok, r.PostFormValue("token_type_hint")); sameClient(ad, cl) {
+		storage := h.ia.os.Storage
+		if err := storage.RemoveAccess(ad.AccessToken); err != nil {
+			h.logger.Errorf("Unable to revoke the access token: %s", err)
+		}
+		if len(ad.RefreshToken) > 0 {
+			if err := storage.RemoveRefresh(ad.RefreshToken); err != nil {
+				h.logger.Errorf("Unable to revoke the refresh token: %s", err)
+			}
+		}
+	}
+	redirectOrOutput(resp, w, r)
+}
+
+// HandleIntrospect serves POST /oauth/introspect requests, from RFC 7662.
+// The clients can introspect only their own tokens, the other ones are reported as inactive.
+func (h *oauthHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request) {
+	resp := h.ia.os.NewResponse()
+	defer resp.Close()
+
+	cl, ok := h.authenticateClient(r)
+	if !ok {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	tok := r.PostFormValue("token")
+	if len(tok) == 0 {
+		resp.SetError(osin.E_INVALID_REQUEST, "missing token")
+		redirectOrOutput(resp, w, r)
+		return
+	}
+
+	resp.Output["active"] = false
+	ad := h.loadToken(tok, r.PostFormValue("token_type_hint"))
+	if !sameClient(ad, cl) || (ad.AccessToken == tok && ad.IsExpiredAt(time.Now())) {
+		redirectOrOutput(resp, w, r)
+		return
+	}
+	resp.Output["active"] = true
+	resp.Output["client_id"] = ad.Client.GetId()
+	resp.Output["scope"] = ad.Scope
+	resp.Output["iat"] = ad.CreatedAt.Unix()
+	if ad.AccessToken == tok {
+		resp.Output["token_type"] = "Bearer"
+		resp.Output["exp"] = ad.ExpireAt().Unix()
+	}
+	if iri, err := assertToBytes(ad.UserData); err == nil && len(iri) > 0 {
+		resp.Output["sub"] = string(iri)
+		if it, err := h.loader.Load(pub.IRI(iri)); err == nil {
+			pub.OnActor(it, func(p *pub.Actor) error {
+				if len(p.PreferredUsername) > 0 {
+					resp.Output["username"] = p.PreferredUsername.First().String()
+				}
+				return nil
+			})
+		}
+	}
+	redirectOrOutput(resp, w, r)
+}
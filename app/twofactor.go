@@ -0,0 +1,341 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/totp"
+	st "github.com/go-ap/fedbox/storage"
+)
+
+const (
+	// pendingLoginTTL is how long the second step of a login waits for the authentication code
+	pendingLoginTTL = 5 * time.Minute
+	// recoveryCodesCount is the number of recovery codes generated when the second factor is enabled
+	recoveryCodesCount = 10
+)
+
+// errSecondFactor is returned by login when the password is correct, but the account needs an authentication code
+var errSecondFactor = errors.Unauthorizedf("Missing authentication code")
+
+var errInvalidCode = errors.Unauthorizedf("Invalid authentication code")
+
+// secondFactorRequired is returned by loadAccountFromPost when the login continues with the authentication code
+type secondFactorRequired struct {
+	account *account
+	token   string
+}
+
+func (s secondFactorRequired) Error() string {
+	return errSecondFactor.Error()
+}
+
+type pendingLogin struct {
+	actor   pub.IRI
+	client  string
+	expires time.Time
+}
+
+// pendingLogins keeps the logins which passed the password check and wait for the authentication code
+type pendingLogins struct {
+	mu     sync.Mutex
+	logins map[string]pendingLogin
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{logins: make(map[string]pendingLogin)}
+}
+
+// Add saves the pending login, and returns the token which identifies it
+func (p *pendingLogins) Add(actor pub.IRI, client string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := hex.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, l := range p.logins {
+		if now.After(l.expires) {
+			delete(p.logins, k)
+		}
+	}
+	p.logins[tok] = pendingLogin{actor: actor, client: client, expires: now.Add(pendingLoginTTL)}
+	return tok, nil
+}
+
+// Get returns the pending login of the token, if it didn't expire
+func (p *pendingLogins) Get(tok string) (pendingLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.logins[tok]
+	if !ok || time.Now().After(l.expires) {
+		return pendingLogin{}, false
+	}
+	return l, true
+}
+
+// Remove removes the pending login of the token, after it finished
+func (p *pendingLogins) Remove(tok string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.logins, tok)
+}
+
+// SecondFactor returns true if the account has the second factor authentication enabled
+func (g *loginGuard) SecondFactor(iri pub.IRI) bool {
+	if g == nil || g.repo == nil {
+		return false
+	}
+	m, err := g.repo.LoadMetadata(iri)
+	if err != nil || m == nil {
+		return false
+	}
+	return len(m.TOTPSecret) > 0
+}
+
+// CheckCode checks the one time password, or the recovery code, of the account.
+// The accepted recovery codes are removed, and the failed attempts count towards locking the account.
+func (g *loginGuard) CheckCode(iri pub.IRI, r *http.Request, client, code string) error {
+	if g == nil || g.repo == nil {
+		return errInvalidCode
+	}
+	if err := g.Locked(iri); err != nil {
+		return err
+	}
+	valid := false
+	recovery := totp.IsRecoveryCode(code)
+	_, err := g.update(iri, func(m *st.Metadata) {
+		if recovery {
+			h := totp.HashRecoveryCode(code)
+			for i, rc := range m.RecoveryCodes {
+				if rc == h {
+					m.RecoveryCodes = append(m.RecoveryCodes[:i], m.RecoveryCodes[i+1:]...)
+					valid = true
+					break
+				}
+			}
+			return
+		}
+		if len(m.TOTPSecret) == 0 {
+			return
+		}
+		if c, ok := totp.Validate(m.TOTPSecret, code, time.Now(), m.TOTPLastCounter); ok {
+			m.TOTPLastCounter = c
+			valid = true
+		}
+	})
+	if err != nil {
+		// NOTE(marius): the used code was not saved, so it's refused to not allow using it again
+		return err
+	}
+	if !valid {
+		g.Failed(iri, r, client)
+		return errInvalidCode
+	}
+	if recovery {
+		g.Record(iri, r, st.AuditRecoveryCode, client, "")
+	}
+	g.Succeeded(iri, r, client)
+	return nil
+}
+
+// secondFactor finishes the login started with the password, using the authentication code
+func (h *oauthHandler) secondFactor(r *http.Request, tok, code, client string) (*account, error) {
+	l, ok := h.pending.Get(tok)
+	if !ok || l.client != client {
+		return nil, errors.Unauthorizedf("The login expired, please try again")
+	}
+	it, err := h.loader.Load(l.actor)
+	if err != nil || pub.IsNil(it) {
+		return nil, errUnauthorized
+	}
+	if err = h.guard.CheckCode(l.actor, r, client, code); err != nil {
+		return nil, err
+	}
+	h.pending.Remove(tok)
+	if pub.IsItemCollection(it) {
+		pub.OnCollectionIntf(it, func(col pub.CollectionInterface) error {
+			it = col.Collection().First()
+			return nil
+		})
+	}
+
+	acc := new(account)
+	err = pub.OnActor(it, func(p *pub.Actor) error {
+		acc.FromActor(p)
+		return nil
+	})
+	return acc, err
+}
+
+type secondFactorModel struct {
+	title   string
+	account pub.Actor
+	state   string
+	client  string
+	token   string
+}
+
+func (s secondFactorModel) Title() string {
+	return s.title
+}
+
+func (s secondFactorModel) Account() pub.Actor {
+	return s.account
+}
+
+func (s secondFactorModel) State() string {
+	return s.state
+}
+
+func (s secondFactorModel) Client() string {
+	return s.client
+}
+
+func (s secondFactorModel) Token() string {
+	return s.token
+}
+
+// renderSecondFactor shows the form for the authentication code, if the login needs it,
+// and returns false for the other errors
+func (h *oauthHandler) renderSecondFactor(w http.ResponseWriter, r *http.Request, err error) bool {
+	req, ok := err.(secondFactorRequired)
+	if !ok {
+		return false
+	}
+	m := secondFactorModel{
+		title:  "Authentication code",
+		state:  r.PostFormValue("state"),
+		client: r.PostFormValue("client"),
+		token:  req.token,
+	}
+	if req.account != nil && req.account.actor != nil {
+		m.account = *req.account.actor
+	}
+	h.renderTemplate(r, w, "totp", m)
+	return true
+}
+
+// loggedActor returns the actor authenticated with the OAuth2 token of the request
+func loggedActor(r *http.Request) (pub.IRI, error) {
+	act, ok := auth.ActorContext(r.Context())
+	if !ok || act.GetLink().Equals(auth.AnonymousActor.ID, false) {
+		return "", errors.Unauthorizedf("Missing or invalid authorization token")
+	}
+	return act.GetLink(), nil
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type totpRecovery struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// HandleEnroll2FA serves POST /oauth/2fa/enroll requests.
+// It generates a new secret for the authenticated actor, which is enabled after a code is verified.
+func (h *oauthHandler) HandleEnroll2FA(w http.ResponseWriter, r *http.Request) {
+	iri, err := loggedActor(r)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	if h.guard == nil || h.guard.repo == nil {
+		errors.HandleError(errors.NotImplementedf("The storage does not support the second factor authentication")).ServeHTTP(w, r)
+		return
+	}
+	if h.guard.SecondFactor(iri) {
+		errors.HandleError(errors.BadRequestf("The second factor authentication is already enabled")).ServeHTTP(w, r)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.logger.Errorf("Unable to generate the TOTP secret: %s", err)
+		errors.HandleError(errors.Annotatef(err, "Unable to generate the secret")).ServeHTTP(w, r)
+		return
+	}
+	if _, err = h.guard.update(iri, func(m *st.Metadata) { m.TOTPPendingSecret = secret }); err != nil {
+		errors.HandleError(errors.Annotatef(err, "Unable to save the secret")).ServeHTTP(w, r)
+		return
+	}
+
+	account := iri.String()
+	if it, err := h.loader.Load(iri); err == nil {
+		pub.OnActor(it, func(p *pub.Actor) error {
+			if len(p.PreferredUsername) > 0 {
+				account = p.PreferredUsername.First().String()
+			}
+			return nil
+		})
+	}
+	issuer := h.baseURL
+	if u, err := pub.IRI(h.baseURL).URL(); err == nil {
+		issuer = u.Host
+	}
+	writeJSON(w, http.StatusOK, totpEnrollment{Secret: secret, URI: totp.URI(issuer, account, secret)})
+}
+
+// HandleVerify2FA serves POST /oauth/2fa/verify requests.
+// It enables the secret being enrolled if the otp code is valid, and returns the recovery codes.
+func (h *oauthHandler) HandleVerify2FA(w http.ResponseWriter, r *http.Request) {
+	iri, err := loggedActor(r)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	codes, err := totp.RecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		h.logger.Errorf("Unable to generate the recovery codes: %s", err)
+		errors.HandleError(errors.Annotatef(err, "Unable to generate the recovery codes")).ServeHTTP(w, r)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+
+	code := r.PostFormValue("otp")
+	valid := false
+	_, err = h.guard.update(iri, func(m *st.Metadata) {
+		if len(m.TOTPPendingSecret) == 0 {
+			return
+		}
+		c, ok := totp.Validate(m.TOTPPendingSecret, code, time.Now(), 0)
+		if !ok {
+			return
+		}
+		m.TOTPSecret = m.TOTPPendingSecret
+		m.TOTPPendingSecret = ""
+		m.TOTPLastCounter = c
+		m.RecoveryCodes = hashes
+		valid = true
+	})
+	if err != nil {
+		errors.HandleError(errors.Annotatef(err, "Unable to enable the second factor authentication")).ServeHTTP(w, r)
+		return
+	}
+	if !valid {
+		errors.HandleError(errInvalidCode).ServeHTTP(w, r)
+		return
+	}
+	h.guard.Record(iri, r, st.AuditTOTPEnabled, "", "")
+	writeJSON(w, http.StatusOK, totpRecovery{RecoveryCodes: codes})
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/totp"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoginGuard_CheckCode(t *testing.T) {
+	iri := pub.IRI("https://example.com/actors/jdoe")
+	secret, _ := totp.GenerateSecret()
+	codes, _ := totp.RecoveryCodes(2)
+	repo := mockMetadata{
+		iri: st.Metadata{
+			TOTPSecret:    secret,
+			RecoveryCodes: []string{totp.HashRecoveryCode(codes[0]), totp.HashRecoveryCode(codes[1])},
+		},
+	}
+	l := logrus.New()
+	l.SetLevel(logrus.PanicLevel)
+	g := newLoginGuard(repo, l)
+	r := httptest.NewRequest("POST", "/oauth/login", nil)
+
+	if !g.SecondFactor(iri) {
+		t.Fatalf("expected the account to have the second factor enabled")
+	}
+	code, _ := totp.Code(secret, time.Now())
+	if err := g.CheckCode(iri, r, "client", code); err != nil {
+		t.Errorf("expected the current code to be accepted, got %s", err)
+	}
+	if err := g.CheckCode(iri, r, "client", code); err == nil {
+		t.Errorf("expected the code not to be accepted a second time")
+	}
+	if err := g.CheckCode(iri, r, "client", codes[1]); err != nil {
+		t.Errorf("expected the recovery code to be accepted, got %s", err)
+	}
+	if err := g.CheckCode(iri, r, "client", codes[1]); err == nil {
+		t.Errorf("expected the recovery code not to be accepted a second time")
+	}
+	if m := repo[iri]; len(m.RecoveryCodes) != 1 || m.FailedLogins != 1 {
+		t.Errorf("expected one recovery code and one failed attempt left, got %d and %d", len(m.RecoveryCodes), m.FailedLogins)
+	}
+}
+
+func TestPendingLogins(t *testing.T) {
+	p := newPendingLogins()
+	iri := pub.IRI("https://example.com/actors/jdoe")
+	tok, err := p.Add(iri, "client")
+	if err != nil {
+		t.Fatalf("Add() error = %s", err)
+	}
+	if l, ok := p.Get(tok); !ok || l.actor != iri || l.client != "client" {
+		t.Errorf("expected the pending login to be found, got %#v", l)
+	}
+	if _, ok := p.Get("invalid"); ok {
+		t.Errorf("expected an unknown token not to be found")
+	}
+	p.logins[tok] = pendingLogin{actor: iri, expires: time.Now().Add(-time.Second)}
+	if _, ok := p.Get(tok); ok {
+		t.Errorf("expected the expired login not to be found")
+	}
+	p.Remove(tok)
+	if len(p.logins) != 0 {
+		t.Errorf("expected the login to be removed")
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"net/url"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/webhooks"
+	"github.com/go-ap/jsonld"
+)
+
+// webhookRetryInterval is how often the queue of failed webhook deliveries is checked
+const webhookRetryInterval = 30 * time.Second
+
+// WebhookFilters returns the ap.Filters for the filter of a webhook
+func WebhookFilters(baseIRI pub.IRI, h webhooks.Hook) (*ap.Filters, error) {
+	if len(h.Filter) == 0 {
+		return nil, nil
+	}
+	u, err := baseIRI.URL()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = url.ParseQuery(h.Filter); err != nil {
+		return nil, err
+	}
+	u.RawQuery = h.Filter
+	return ap.FiltersFromIRI(pub.IRI(u.String()))
+}
+
+// fireWebhooks sends, in the background, the activity to the webhooks whose filters match it
+func (f FedBOX) fireWebhooks(it pub.Item) {
+	if f.hooks == nil || pub.IsNil(it) {
+		return
+	}
+	hooks, err := f.hooks.Store().Hooks()
+	if err != nil {
+		f.errFn("Unable to load the webhooks: %s", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	f.hooks.Go(func() {
+		f.deliverWebhooks(hooks, it)
+	})
+}
+
+func (f FedBOX) deliverWebhooks(hooks []webhooks.Hook, it pub.Item) {
+	baseIRI := pub.IRI(f.conf.BaseURL)
+	var matched pub.Item
+	var payload []byte
+	for _, h := range hooks {
+		ff, err := WebhookFilters(baseIRI, h)
+		if err != nil {
+			f.errFn("Invalid filter for webhook %s: %s", h.ID, err)
+			continue
+		}
+		if ff != nil {
+			// NOTE(marius): the filters can match the properties of the object, so it's loaded
+			// the first time one of the webhooks has a filter
+			if matched == nil {
+				matched = dereferenceObject(f, it)
+			}
+			if !ff.ItemsMatch(matched) {
+				continue
+			}
+		}
+		if payload == nil {
+			if payload, err = jsonld.Marshal(it); err != nil {
+				f.errFn("Unable to marshal %s for the webhooks: %s", it.GetLink(), err)
+				return
+			}
+		}
+		f.hooks.Fire(h, string(it.GetType()), payload)
+	}
+}
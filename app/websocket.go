@@ -0,0 +1,287 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/websocket"
+	"github.com/go-ap/jsonld"
+)
+
+// wsMessage is the message the WebSocket clients send to subscribe to a collection, or to unsubscribe from it.
+// The filter is an IRI with ap.Filters query parameters, relative to the IRI of the authenticated actor,
+// for example "inbox?type=Create&object.type=Note".
+type wsMessage struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Filter string `json:"filter,omitempty"`
+}
+
+// wsEvent is a message sent to the WebSocket clients
+type wsEvent struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	Event uint64          `json:"event,omitempty"`
+	Item  json.RawMessage `json:"item,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+const (
+	wsSubscribe    = "subscribe"
+	wsUnsubscribe  = "unsubscribe"
+	wsSubscribed   = "subscribed"
+	wsUnsubscribed = "unsubscribed"
+	wsItem         = "item"
+	wsError        = "error"
+)
+
+// maxWsSubscriptions is the number of subscriptions a WebSocket connection can have
+const maxWsSubscriptions = 32
+
+// wsSession is a WebSocket connection, with its subscriptions
+type wsSession struct {
+	fb      FedBOX
+	conn    *websocket.Conn
+	baseIRI pub.IRI
+	actor   *pub.Actor
+
+	mu   sync.Mutex
+	subs map[string]*events.Subscription
+}
+
+func (s *wsSession) send(e wsEvent) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(raw)
+}
+
+// filters resolves the filter of a subscription to a local collection, and loads its ap.Filters
+func (s *wsSession) filters(filter string) (*ap.Filters, pub.IRI, error) {
+	rel, err := url.Parse(filter)
+	if err != nil {
+		return nil, "", errors.NewNotValid(err, "invalid filter %s", filter)
+	}
+	base := s.baseIRI
+	if s.actor != nil {
+		base = s.actor.GetLink()
+	}
+	u, err := base.URL()
+	if err != nil {
+		return nil, "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u = u.ResolveReference(rel)
+	iri := pub.IRI(u.String())
+	if !iri.Contains(s.baseIRI, false) {
+		return nil, "", errors.NotValidf("%s is not a local collection", filter)
+	}
+	f, err := ap.FiltersFromIRI(iri)
+	if err != nil {
+		return nil, "", errors.NewNotValid(err, "invalid filter %s", filter)
+	}
+	if !ap.ValidCollection(f.Collection) {
+		return nil, "", errors.NotFoundf("collection '%s' not found", f.Collection)
+	}
+	f.Authenticated = s.actor
+	u.RawQuery = ""
+	return f, pub.IRI(u.String()), nil
+}
+
+// dereferenceObject returns a copy of an activity with its object loaded, when it's only an IRI,
+// so it can be matched by the filters
+func dereferenceObject(fb FedBOX, it pub.Item) pub.Item {
+	if !pub.ActivityTypes.Contains(it.GetType()) {
+		return it
+	}
+	act, err := pub.ToActivity(it)
+	if err != nil || pub.IsNil(act.Object) || !act.Object.IsLink() {
+		return it
+	}
+	ob, err := fb.Storage.Load(act.Object.GetLink())
+	if err != nil || pub.IsNil(ob) || pub.IsItemCollection(ob) {
+		return it
+	}
+	cp := *act
+	cp.Object = ob
+	return &cp
+}
+
+func (s *wsSession) subscribe(m wsMessage) error {
+	if len(m.ID) == 0 {
+		return errors.NotValidf("missing subscription id")
+	}
+	f, col, err := s.filters(m.Filter)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if _, ok := s.subs[m.ID]; ok {
+		s.mu.Unlock()
+		return errors.NotValidf("subscription %s already exists", m.ID)
+	}
+	if len(s.subs) >= maxWsSubscriptions {
+		s.mu.Unlock()
+		return errors.NotValidf("too many subscriptions")
+	}
+	sub, _ := events.Default.Subscribe(col, 0)
+	s.subs[m.ID] = sub
+	s.mu.Unlock()
+
+	go func() {
+		audience := f.Audience()
+		for e := range sub.C {
+			for _, it := range streamItems(s.fb, e, audience) {
+				it = dereferenceObject(s.fb, it)
+				if !f.ItemsMatch(it) {
+					continue
+				}
+				if r, ok := it.(pub.HasRecipients); ok {
+					r.Clean()
+				}
+				it = s.fb.proxy.rewrite(it)
+				raw, err := jsonld.Marshal(it)
+				if err != nil {
+					s.fb.errFn("unable to marshal %s: %s", e.Item, err)
+					continue
+				}
+				if err = s.send(wsEvent{Type: wsItem, ID: m.ID, Event: e.ID, Item: raw}); err != nil {
+					sub.Close()
+					return
+				}
+			}
+		}
+		// NOTE(marius): the subscription is closed when it doesn't keep up with the events
+		s.mu.Lock()
+		current := s.subs[m.ID] == sub
+		if current {
+			delete(s.subs, m.ID)
+		}
+		s.mu.Unlock()
+		if current {
+			s.send(wsEvent{Type: wsUnsubscribed, ID: m.ID})
+		}
+	}()
+	return s.send(wsEvent{Type: wsSubscribed, ID: m.ID})
+}
+
+func (s *wsSession) unsubscribe(m wsMessage) error {
+	s.mu.Lock()
+	sub, ok := s.subs[m.ID]
+	delete(s.subs, m.ID)
+	s.mu.Unlock()
+	if !ok {
+		return errors.NotFoundf("subscription %s not found", m.ID)
+	}
+	sub.Close()
+	return s.send(wsEvent{Type: wsUnsubscribed, ID: m.ID})
+}
+
+func (s *wsSession) close() {
+	s.mu.Lock()
+	subs := s.subs
+	s.subs = make(map[string]*events.Subscription)
+	s.mu.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// serve reads the messages of the client, and keeps the connection open with pings
+func (s *wsSession) serve(conn *websocket.Conn) {
+	s.conn = conn
+	defer s.close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(heartbeatInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.fb.closing:
+				// NOTE(marius): FedBOX is shutting down, closing the connection ends the read loop below
+				conn.CloseGoingAway()
+				return
+			case <-ping.C:
+				if err := conn.Ping(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		raw, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		m := wsMessage{}
+		if err = json.Unmarshal(raw, &m); err != nil {
+			s.send(wsEvent{Type: wsError, Error: "invalid message"})
+			continue
+		}
+		switch m.Type {
+		case wsSubscribe:
+			err = s.subscribe(m)
+		case wsUnsubscribe:
+			err = s.unsubscribe(m)
+		default:
+			err = errors.NotValidf("unknown message type %q", m.Type)
+		}
+		if err != nil {
+			s.send(wsEvent{Type: wsError, ID: m.ID, Error: err.Error()})
+		}
+	}
+}
+
+// wsPongWait is how long a WebSocket connection stays open when the client doesn't answer the pings
+const wsPongWait = 2 * heartbeatInterval
+
+// HandleWebSocket serves the WebSocket streaming API. The clients can subscribe to multiple collections
+// over one connection, and receive the items added to them which match the filters of each subscription.
+// The clients are authenticated with the OAuth2 bearer token, like for the rest of the requests.
+func HandleWebSocket(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := requireScope(r, ScopeRead); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		s := &wsSession{
+			fb:      fb,
+			baseIRI: pub.IRI(fb.Config().BaseURL),
+			subs:    make(map[string]*events.Subscription),
+		}
+		if act, ok := auth.ActorContext(r.Context()); ok {
+			s.actor = &act
+		}
+		websocket.Handler(wsPongWait, s.serve).ServeHTTP(w, r)
+	}
+}
+
+// BearerFromQuery moves the access_token query parameter of the WebSocket handshakes to the Authorization header,
+// as the browsers can't set headers for them.
+func BearerFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tok := r.URL.Query().Get("access_token"); len(tok) > 0 && len(r.Header.Get("Authorization")) == 0 {
+			r.Header.Set("Authorization", "Bearer "+tok)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
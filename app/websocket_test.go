@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+	h "github.com/go-ap/handlers"
+)
+
+func TestWsSession_filters(t *testing.T) {
+	h.Typer = pathTyper{}
+	s := wsSession{
+		baseIRI: "https://fedbox.example.com",
+		actor:   &pub.Actor{ID: "https://fedbox.example.com/actors/jdoe"},
+	}
+	tests := []struct {
+		filter string
+		col    pub.IRI
+		typ    h.CollectionType
+		err    bool
+	}{
+		{filter: "inbox?type=Create&object.type=Note", col: "https://fedbox.example.com/actors/jdoe/inbox", typ: h.Inbox},
+		{filter: "/actors/jdoe/outbox", col: "https://fedbox.example.com/actors/jdoe/outbox", typ: h.Outbox},
+		{filter: "https://remote.example.com/actors/jdoe/inbox", err: true},
+		{filter: "/unknown", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, col, err := s.filters(tt.filter)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error for %s", tt.filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if col != tt.col {
+				t.Errorf("expected collection %s, got %s", tt.col, col)
+			}
+			if f.Collection != tt.typ {
+				t.Errorf("expected collection type %s, got %s", tt.typ, f.Collection)
+			}
+			if f.Authenticated != s.actor {
+				t.Errorf("expected the filters to be for the authenticated actor")
+			}
+		})
+	}
+}
@@ -68,6 +68,10 @@ func main() {
 		cmd.OAuth2Cmd,
 		cmd.BootstrapCmd,
 		cmd.AccountsCmd,
+		cmd.DbCmd,
+		cmd.StorageCmd,
+		cmd.BackupCmd,
+		cmd.WebhookCmd,
 	}
 
 	if err := app.Run(os.Args); err != nil {
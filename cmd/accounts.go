@@ -9,6 +9,7 @@ import (
 	"github.com/go-ap/fedbox/storage"
 	"gopkg.in/urfave/cli.v2"
 	"os"
+	"path"
 	"time"
 )
 
@@ -18,17 +19,54 @@ var AccountsCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		exportAccountsMetadataCmd,
 		importAccountsMetadataCmd,
+		auditAccountCmd,
+		secondFactorCmd,
 	},
 }
 
 var exportAccountsMetadataCmd = &cli.Command{
-	Name:   "export",
-	Usage:  "Exports accounts metadata",
+	Name:      "export",
+	Usage:     "Exports accounts metadata, or the archive of an account",
+	ArgsUsage: "[ACTOR]",
+	Description: "Without arguments it exports the metadata of all the accounts.\n" +
+		"When an actor IRI or name is passed, it exports its archive: the actor, its outbox, likes, " +
+		"followers, following and blocked collections, and the references to its media.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "The file where to write the account archive",
+		},
+	},
 	Action: exportAccountsMetadata(&ctl),
 }
 
+func exportAccountArchive(ctl *Control, id, output string) error {
+	a, err := ctl.LoadAccountArchive(id)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = fmt.Sprintf("%s.zip", path.Base(a.Actor.GetLink().String()))
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = a.Save(f); err != nil {
+		os.Remove(output)
+		return errors.Annotatef(err, "unable to write account archive")
+	}
+	fmt.Printf("Exported %s to %s: %d activities, %d likes, %d following, %d followers, %d blocked, %d media\n",
+		a.Actor.GetLink(), output, len(a.Outbox), len(a.Likes), len(a.Following), len(a.Followers), len(a.Blocked), len(a.Media))
+	return nil
+}
+
 func exportAccountsMetadata(ctl *Control) cli.ActionFunc {
 	return func(c *cli.Context) error {
+		if c.Args().Len() > 0 {
+			return exportAccountArchive(ctl, c.Args().First(), c.String("output"))
+		}
 		metaLoader, ok := ctl.Storage.(storage.MetadataTyper)
 		if !ok {
 			return errors.Newf("")
@@ -83,8 +121,18 @@ func exportAccountsMetadata(ctl *Control) cli.ActionFunc {
 }
 
 var importAccountsMetadataCmd = &cli.Command{
-	Name:   "import",
-	Usage:  "Imports accounts metadata",
+	Name:      "import",
+	Usage:     "Imports accounts metadata, or account archives",
+	ArgsUsage: "FILE...",
+	Description: "The files can be JSON metadata exports, or account archives exported by FedBOX or Mastodon.\n" +
+		"The actors of the archives are created on this instance, unless they already exist.\n" +
+		"The archives don't contain the passwords of the accounts, use --password to set them when importing.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "password",
+			Usage: "Asks for the password of the accounts created from the archives",
+		},
+	},
 	Action: importAccountsMetadata(&ctl),
 }
 
@@ -96,6 +144,24 @@ func importAccountsMetadata(ctl *Control) cli.ActionFunc {
 			return errors.Newf("")
 		}
 		for _, name := range files {
+			if isAccountArchive(name) {
+				a, err := ReadAccountArchive(name)
+				if err != nil {
+					Errf("Error reading %s: %s", name, err)
+					continue
+				}
+				var pw []byte
+				if c.Bool("password") && !exists(ctl.Storage, a.Actor.GetLink()) {
+					if pw, err = loadPwFromStdin(true, "%s's", a.Actor.GetLink()); err != nil {
+						Errf("Error reading the password for %s: %s", name, err)
+						continue
+					}
+				}
+				if _, err = ctl.ImportAccountArchive(a, pw); err != nil {
+					Errf("Error importing %s: %s", name, err)
+				}
+				continue
+			}
 			f, err := os.Open(name)
 			if err != nil {
 				if os.IsNotExist(err) {
@@ -135,10 +201,10 @@ func importAccountsMetadata(ctl *Control) cli.ActionFunc {
 			}
 
 			tot := time.Now().Sub(start)
-			fmt.Printf("Ellapsed time:          %s\n", tot)
+			fmt.Printf("Elapsed time:           %s\n", tot)
 			if count > 0 {
 				perIt := time.Duration(int64(tot) / int64(count))
-				fmt.Printf("Ellapsed time per item: %s\n", perIt)
+				fmt.Printf("Elapsed time per item:  %s\n", perIt)
 			}
 		}
 		return nil
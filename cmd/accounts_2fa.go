@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-ap/errors"
+	s "github.com/go-ap/fedbox/storage"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var secondFactorCmd = &cli.Command{
+	Name:  "2fa",
+	Usage: "Second factor authentication helper",
+	Subcommands: []*cli.Command{
+		resetSecondFactorCmd,
+	},
+}
+
+var resetSecondFactorCmd = &cli.Command{
+	Name:      "reset",
+	Usage:     "Disables the second factor authentication of an account, and removes its recovery codes",
+	ArgsUsage: "ACTOR",
+	Action:    resetSecondFactorAct(&ctl),
+}
+
+func resetSecondFactorAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("Missing actor")
+		}
+		actor, err := c.loadActor(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		iri := actor.GetLink()
+		_, err = c.updateMetadata(iri, func(m *s.Metadata) {
+			m.TOTPSecret = ""
+			m.TOTPPendingSecret = ""
+			m.TOTPLastCounter = 0
+			m.RecoveryCodes = nil
+			m.Audit = append(m.Audit, s.AuditEntry{Time: time.Now().UTC(), Event: s.AuditTOTPReset, Detail: "fedboxctl"})
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Disabled the second factor authentication of %s\n", iri)
+		return nil
+	}
+}
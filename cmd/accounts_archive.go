@@ -0,0 +1,634 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal"
+	"github.com/go-ap/handlers"
+	"github.com/go-ap/jsonld"
+)
+
+// The files of an account archive. The actor, outbox and likes files follow the layout of the
+// archives Mastodon generates, and the followed accounts are in the CSV format of the Mastodon exports.
+// The others are specific to FedBOX.
+// The account's password, second factor and audit log are never part of the archive.
+const (
+	archiveActorName             = "actor.json"
+	archiveOutboxName            = "outbox.json"
+	archiveLikesName             = "likes.json"
+	archiveFollowingAccountsName = "following_accounts.csv"
+	archiveFollowersName         = "followers.json"
+	archiveBlockedName           = "blocked.json"
+	archiveMediaName             = "media.json"
+
+	// archiveFollowingName is the file older FedBOX archives kept the followed accounts in
+	archiveFollowingName = "following.json"
+)
+
+// followingAccountsHeader is the header of the following_accounts.csv Mastodon exports and imports
+var followingAccountsHeader = []string{"Account address", "Show boosts", "Notify on new posts", "Languages"}
+
+var archiveContext = []byte(`{"@context":["https://www.w3.org/ns/activitystreams","https://w3id.org/security/v1"]`)
+
+// accountArchive holds the contents of an account archive
+type accountArchive struct {
+	Actor     pub.Item
+	Outbox    pub.ItemCollection
+	Likes     pub.ItemCollection
+	Following pub.ItemCollection
+	// FollowingAccounts are the addresses of the followed accounts, in the user@host form when it is known
+	FollowingAccounts []string
+	Followers         pub.ItemCollection
+	Blocked           pub.ItemCollection
+	Media             pub.ItemCollection
+	// Skipped are the files of the archive which are not imported, like the media attachments
+	Skipped []string
+}
+
+// loadActor loads a local actor using its IRI or its preferred username
+func (c *Control) loadActor(id string) (pub.Item, error) {
+	if strings.Contains(id, "://") {
+		col, err := loadItems(c.Storage, pub.IRI(id))
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range col {
+			if it.GetLink().Equals(pub.IRI(id), false) {
+				return it, nil
+			}
+		}
+		return nil, errors.NotFoundf("actor %s not found", id)
+	}
+	f := ap.FiltersNew(
+		ap.IRI(ap.ActorsType.IRI(pub.IRI(c.Conf.BaseURL))),
+		ap.Name(id),
+	)
+	col, err := loadItems(c.Storage, f.GetLink())
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range col {
+		if pub.ActorTypes.Contains(it.GetType()) {
+			return it, nil
+		}
+	}
+	return nil, errors.NotFoundf("actor %s not found", id)
+}
+
+func (c *Control) loadIRIs(iri pub.IRI) pub.ItemCollection {
+	iris := make(pub.ItemCollection, 0)
+	col, err := loadItems(c.Storage, iri)
+	if err != nil {
+		return iris
+	}
+	for _, it := range col {
+		iris = append(iris, it.GetLink())
+	}
+	return iris
+}
+
+// appendMedia appends to media the attachments, the icon and the image of it
+func appendMedia(media pub.ItemCollection, it pub.Item) pub.ItemCollection {
+	add := func(m pub.Item) {
+		if pub.IsNil(m) {
+			return
+		}
+		if m.IsCollection() {
+			pub.OnCollectionIntf(m, func(c pub.CollectionInterface) error {
+				media = append(media, c.Collection()...)
+				return nil
+			})
+			return
+		}
+		media = append(media, m)
+	}
+	pub.OnObject(it, func(o *pub.Object) error {
+		add(o.Attachment)
+		add(o.Icon)
+		add(o.Image)
+		return nil
+	})
+	return media
+}
+
+// LoadAccountArchive loads from the storage the actor and everything that belongs to it
+func (c *Control) LoadAccountArchive(id string) (*accountArchive, error) {
+	actor, err := c.loadActor(id)
+	if err != nil {
+		return nil, err
+	}
+	a := accountArchive{Actor: actor}
+	a.Media = appendMedia(make(pub.ItemCollection, 0), actor)
+
+	outbox, err := loadItems(c.Storage, handlers.Outbox.IRI(actor))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load the outbox of %s", actor.GetLink())
+	}
+	for _, act := range outbox {
+		if act.IsLink() {
+			// NOTE(marius): the outbox can contain only the IRIs of the activities
+			col, err := loadItems(c.Storage, act.GetLink())
+			if err != nil || len(col) == 0 {
+				continue
+			}
+			act = col[0]
+		}
+		pub.OnActivity(act, func(act *pub.Activity) error {
+			if !pub.IsNil(act.Object) && act.Object.IsLink() {
+				if col, err := loadItems(c.Storage, act.Object.GetLink()); err == nil && len(col) > 0 {
+					act.Object = col[0]
+				}
+			}
+			a.Media = appendMedia(a.Media, act.Object)
+			return nil
+		})
+		a.Outbox = append(a.Outbox, act)
+	}
+	a.Likes = c.loadIRIs(handlers.Liked.IRI(actor))
+	a.Following = c.loadIRIs(handlers.Following.IRI(actor))
+	a.Followers = c.loadIRIs(handlers.Followers.IRI(actor))
+	a.Blocked = c.loadIRIs(ap.BlockedType.IRI(actor))
+	for _, iri := range a.Following {
+		a.FollowingAccounts = append(a.FollowingAccounts, c.accountAddress(iri.GetLink()))
+	}
+	return &a, nil
+}
+
+// accountAddress returns the user@host address of the actor, like Mastodon uses them in its exports.
+// When the actor is not in the storage we don't know its preferred username, so we return its IRI.
+func (c *Control) accountAddress(iri pub.IRI) string {
+	u, err := iri.URL()
+	if err != nil {
+		return iri.String()
+	}
+	name := ""
+	if col, err := loadItems(c.Storage, iri); err == nil {
+		for _, it := range col {
+			if !it.GetLink().Equals(iri, false) {
+				continue
+			}
+			pub.OnActor(it, func(a *pub.Actor) error {
+				name = a.PreferredUsername.First().Value.String()
+				return nil
+			})
+		}
+	}
+	if name == "" {
+		return iri.String()
+	}
+	return fmt.Sprintf("%s@%s", name, u.Host)
+}
+
+// resolveAccountAddress returns the IRI of the actor with the user@host address, using WebFinger
+func resolveAccountAddress(cl *http.Client, addr string) (pub.IRI, error) {
+	addr = strings.TrimPrefix(strings.TrimSpace(addr), "@")
+	if strings.Contains(addr, "://") {
+		return pub.IRI(addr), nil
+	}
+	at := strings.LastIndex(addr, "@")
+	if at <= 0 || at == len(addr)-1 {
+		return "", errors.NotValidf("invalid account address %q", addr)
+	}
+	q := url.Values{"resource": []string{"acct:" + addr}}
+	resp, err := cl.Get(fmt.Sprintf("https://%s/.well-known/webfinger?%s", addr[at+1:], q.Encode()))
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to resolve %s", addr)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.NotFoundf("unable to resolve %s: %s", addr, resp.Status)
+	}
+	wf := struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&wf); err != nil {
+		return "", errors.Annotatef(err, "invalid WebFinger response for %s", addr)
+	}
+	for _, l := range wf.Links {
+		if l.Rel == "self" && (l.Type == "application/activity+json" || strings.Contains(l.Type, "activitystreams")) {
+			return pub.IRI(l.Href), nil
+		}
+	}
+	return "", errors.NotFoundf("%s has no ActivityPub actor", addr)
+}
+
+// marshalArchiveItem marshals it adding the JSON-LD context, like Mastodon does for the files of its archives
+func marshalArchiveItem(it pub.Item) ([]byte, error) {
+	raw, err := jsonld.Marshal(it)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 2 || raw[0] != '{' {
+		return raw, nil
+	}
+	buf := bytes.Buffer{}
+	buf.Write(archiveContext)
+	if raw[1] != '}' {
+		buf.WriteByte(',')
+	}
+	buf.Write(raw[1:])
+	return buf.Bytes(), nil
+}
+
+func archiveCollection(id pub.IRI, items pub.ItemCollection) pub.Item {
+	return &pub.OrderedCollection{
+		ID:           id,
+		Type:         pub.OrderedCollectionType,
+		TotalItems:   uint(len(items)),
+		OrderedItems: items,
+	}
+}
+
+// Save writes the archive to w, as a zip file
+func (a accountArchive) Save(w io.Writer) error {
+	files := []struct {
+		name string
+		it   pub.Item
+	}{
+		{archiveActorName, a.Actor},
+		{archiveOutboxName, archiveCollection(archiveOutboxName, a.Outbox)},
+		{archiveLikesName, archiveCollection(archiveLikesName, a.Likes)},
+		{archiveFollowersName, archiveCollection(archiveFollowersName, a.Followers)},
+		{archiveBlockedName, archiveCollection(archiveBlockedName, a.Blocked)},
+		{archiveMediaName, archiveCollection(archiveMediaName, a.Media)},
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		raw, err := marshalArchiveItem(f.it)
+		if err != nil {
+			return errors.Annotatef(err, "unable to marshal %s", f.name)
+		}
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(raw); err != nil {
+			return err
+		}
+	}
+	fw, err := zw.Create(archiveFollowingAccountsName)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(fw)
+	cw.Write(followingAccountsHeader)
+	for _, addr := range a.FollowingAccounts {
+		cw.Write([]string{addr, "true", "false", ""})
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return errors.Annotatef(err, "unable to write %s", archiveFollowingAccountsName)
+	}
+	return zw.Close()
+}
+
+func isAccountArchive(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic, _ := bufio.NewReader(f).Peek(4)
+	return bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte{0x1f, 0x8b})
+}
+
+// ReadAccountArchive reads an account archive generated by FedBOX or by Mastodon.
+// Both the zip archives and the older tar.gz ones are supported.
+func ReadAccountArchive(name string) (*accountArchive, error) {
+	files := make(map[string][]byte)
+	skipped := make([]string, 0)
+	read := func(n string, r io.Reader) error {
+		if !(strings.HasSuffix(n, ".json") || n == archiveFollowingAccountsName) || strings.Contains(n, "/") {
+			skipped = append(skipped, n)
+			return nil
+		}
+		raw, err := ioutil.ReadAll(r)
+		files[n] = raw
+		return err
+	}
+
+	if zr, err := zip.OpenReader(name); err == nil {
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = read(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, errors.Annotatef(err, "unable to read %s", f.Name)
+			}
+		}
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s is not a valid account archive", name)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, errors.Annotatef(err, "%s is not a valid account archive", name)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err = read(strings.TrimPrefix(hdr.Name, "./"), tr); err != nil {
+				return nil, errors.Annotatef(err, "unable to read %s", hdr.Name)
+			}
+		}
+	}
+
+	raw, ok := files[archiveActorName]
+	if !ok {
+		return nil, errors.NotFoundf("%s is missing from the archive", archiveActorName)
+	}
+	a := accountArchive{Skipped: skipped}
+	var err error
+	if a.Actor, err = pub.UnmarshalJSON(raw); err != nil {
+		return nil, errors.Annotatef(err, "invalid %s", archiveActorName)
+	}
+	collections := []struct {
+		name  string
+		items *pub.ItemCollection
+	}{
+		{archiveOutboxName, &a.Outbox},
+		{archiveLikesName, &a.Likes},
+		{archiveFollowingName, &a.Following},
+		{archiveFollowersName, &a.Followers},
+		{archiveBlockedName, &a.Blocked},
+		{archiveMediaName, &a.Media},
+	}
+	for _, col := range collections {
+		raw, ok := files[col.name]
+		if !ok {
+			continue
+		}
+		it, err := pub.UnmarshalJSON(raw)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid %s", col.name)
+		}
+		pub.OnCollectionIntf(it, func(c pub.CollectionInterface) error {
+			*col.items = append(*col.items, c.Collection()...)
+			return nil
+		})
+	}
+	if raw, ok := files[archiveFollowingAccountsName]; ok {
+		rows, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid %s", archiveFollowingAccountsName)
+		}
+		for _, row := range rows {
+			if len(row) == 0 || len(row[0]) == 0 || row[0] == followingAccountsHeader[0] {
+				continue
+			}
+			a.FollowingAccounts = append(a.FollowingAccounts, row[0])
+		}
+	}
+	return &a, nil
+}
+
+// accountImport keeps the state of importing an account archive
+type accountImport struct {
+	c       *Control
+	from    pub.IRI
+	actor   pub.Item
+	local   bool
+	ids     map[pub.IRI]pub.IRI
+	replace internal.ReplaceFn
+
+	saved       int
+	memberships int
+	failed      int
+}
+
+func (i *accountImport) fail(format string, args ...interface{}) {
+	i.failed++
+	Errf(format+"\n", args...)
+}
+
+// owned returns true if it was created by the imported actor
+func (i *accountImport) owned(it pub.Item) bool {
+	if pub.IsNil(it) || it.IsLink() {
+		return false
+	}
+	if it.GetLink().Contains(i.from, false) {
+		return true
+	}
+	owned := false
+	pub.OnObject(it, func(o *pub.Object) error {
+		owned = !pub.IsNil(o.AttributedTo) && o.AttributedTo.GetLink().Equals(i.from, false)
+		return nil
+	})
+	if !owned && pub.ActivityTypes.Contains(it.GetType()) {
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			owned = !pub.IsNil(a.Actor) && a.Actor.GetLink().Equals(i.from, false)
+			return nil
+		})
+	}
+	return owned
+}
+
+// newID generates a local ID for an item of the archive, and remembers it
+// so the references to the old ID can be replaced
+func (i *accountImport) newID(it pub.Item) {
+	if i.local || pub.IsNil(it) || !i.owned(it) {
+		return
+	}
+	old := it.GetLink()
+	if _, ok := i.ids[old]; ok {
+		return
+	}
+	id, err := app.GenerateID(pub.IRI(i.c.Conf.BaseURL))(it, nil, i.actor)
+	if err != nil {
+		return
+	}
+	if len(old) > 0 {
+		i.ids[old] = id
+	}
+}
+
+func (i *accountImport) addTo(col pub.IRI, items pub.ItemCollection) {
+	present := iriSet(i.c.loadIRIs(col))
+	for _, it := range items {
+		iri := i.replace(it.GetLink())
+		if len(iri) == 0 || present[iri] {
+			continue
+		}
+		if err := i.c.Storage.AddTo(col, iri); err != nil {
+			i.fail("Unable to add %s to %s: %s", iri, col, err)
+			continue
+		}
+		present[iri] = true
+		i.memberships++
+	}
+}
+
+// importActor creates the local actor for the archive, with the pw password, unless the actor already exists
+// on this instance, in which case the archive is restored over it, keeping the IDs of the items
+func (i *accountImport) importActor(a *accountArchive, pw []byte) error {
+	if exists(i.c.Storage, i.from) {
+		i.actor = a.Actor
+		i.local = true
+		return nil
+	}
+	p := new(pub.Person)
+	err := pub.OnActor(a.Actor, func(old *pub.Actor) error {
+		p.Type = old.Type
+		p.Name = old.Name
+		p.Summary = old.Summary
+		p.Content = old.Content
+		p.PreferredUsername = old.PreferredUsername
+		p.Icon = old.Icon
+		p.Image = old.Image
+		p.Attachment = old.Attachment
+		p.Published = old.Published
+		p.Updated = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if p, err = i.c.AddActor(p, pw); err != nil {
+		return err
+	}
+	i.actor = p
+	return nil
+}
+
+// ImportAccountArchive creates a local account from the contents of the archive, with the pw password.
+// The IRIs of the actor, and of the activities and objects it created, are replaced with local ones.
+func (c *Control) ImportAccountArchive(a *accountArchive, pw []byte) (pub.Item, error) {
+	i := accountImport{
+		c:    c,
+		from: a.Actor.GetLink(),
+		ids:  make(map[pub.IRI]pub.IRI),
+	}
+	if err := i.importActor(a, pw); err != nil {
+		return nil, errors.Annotatef(err, "unable to create actor")
+	}
+	to := i.actor.GetLink()
+	fromActor := internal.HostReplacer(i.from, to)
+	i.replace = func(iri pub.IRI) pub.IRI {
+		if id, ok := i.ids[iri]; ok {
+			return id
+		}
+		return fromActor(iri)
+	}
+
+	// NOTE(marius): we generate first the new IDs for everything the actor created,
+	// so the references between them, like inReplyTo, are replaced too
+	for _, act := range a.Outbox {
+		i.newID(act)
+		pub.OnActivity(act, func(act *pub.Activity) error {
+			i.newID(act.Object)
+			return nil
+		})
+	}
+	for _, m := range a.Media {
+		i.newID(m)
+	}
+
+	for _, m := range a.Media {
+		if m.IsLink() {
+			continue
+		}
+		if _, err := c.Storage.Save(internal.ReplaceIRIsInItem(m, i.replace)); err != nil {
+			i.fail("Unable to save media %s: %s", m.GetLink(), err)
+			continue
+		}
+		i.saved++
+	}
+	outbox := handlers.Outbox.IRI(i.actor)
+	activities := make(pub.ItemCollection, 0, len(a.Outbox))
+	for _, act := range a.Outbox {
+		act = internal.ReplaceIRIsInItem(act, i.replace)
+		err := pub.OnActivity(act, func(act *pub.Activity) error {
+			if pub.IsNil(act.Object) || act.Object.IsLink() {
+				return nil
+			}
+			if _, err := c.Storage.Save(act.Object); err != nil {
+				return err
+			}
+			i.saved++
+			return nil
+		})
+		if err != nil {
+			i.fail("Unable to save the object of %s: %s", act.GetLink(), err)
+			continue
+		}
+		if _, err := c.Storage.Save(act); err != nil {
+			i.fail("Unable to save %s: %s", act.GetLink(), err)
+			continue
+		}
+		i.saved++
+		activities = append(activities, act.GetLink())
+	}
+	i.addTo(outbox, activities)
+	i.addTo(handlers.Liked.IRI(i.actor), a.Likes)
+	following := a.Following
+	if len(a.FollowingAccounts) > 0 {
+		cl := &http.Client{Timeout: 10 * time.Second}
+		for _, addr := range a.FollowingAccounts {
+			iri, err := resolveAccountAddress(cl, addr)
+			if err != nil {
+				i.fail("Unable to resolve followed account %s: %s", addr, err)
+				continue
+			}
+			following = append(following, iri)
+		}
+	}
+	i.addTo(handlers.Following.IRI(i.actor), following)
+	i.addTo(handlers.Followers.IRI(i.actor), a.Followers)
+	i.addTo(ap.BlockedType.IRI(i.actor), a.Blocked)
+
+	fmt.Printf("Imported %s as %s\n", i.from, to)
+	fmt.Printf("Saved items:            %d\n", i.saved)
+	fmt.Printf("Collection memberships: %d\n", i.memberships)
+	fmt.Printf("Skipped files:          %d\n", len(a.Skipped))
+	fmt.Printf("Failed:                 %d\n", i.failed)
+	if !i.local && len(pw) == 0 {
+		fmt.Println("The account has no password, the archives don't contain it")
+	}
+	if len(following) > 0 {
+		fmt.Println("The followed accounts were not notified, their servers don't know about the new actor yet")
+	}
+	if i.failed > 0 {
+		return i.actor, errors.Newf("%d items failed to import", i.failed)
+	}
+	return i.actor, nil
+}
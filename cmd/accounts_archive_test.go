@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestAccountArchiveSaveRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-account-archive")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	actor := pub.IRI("https://example.com/actors/1")
+	a := accountArchive{
+		Actor: &pub.Actor{ID: actor, Type: pub.PersonType},
+		Outbox: pub.ItemCollection{
+			&pub.Activity{
+				ID:     "https://example.com/activities/1",
+				Type:   pub.CreateType,
+				Actor:  actor,
+				Object: &pub.Object{ID: "https://example.com/objects/1", Type: pub.NoteType, AttributedTo: actor},
+			},
+		},
+		Following:         pub.ItemCollection{pub.IRI("https://remote.example.com/actors/2")},
+		FollowingAccounts: []string{"jdoe@remote.example.com", "https://other.example.com/actors/3"},
+	}
+
+	name := filepath.Join(dir, "archive.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Unable to create %s: %s", name, err)
+	}
+	err = a.Save(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Unable to save account archive: %s", err)
+	}
+	if !isAccountArchive(name) {
+		t.Errorf("%s should have been recognized as an account archive", name)
+	}
+
+	got, err := ReadAccountArchive(name)
+	if err != nil {
+		t.Fatalf("Unable to read account archive: %s", err)
+	}
+	if !got.Actor.GetLink().Equals(actor, true) {
+		t.Errorf("Invalid actor %s, expected %s", got.Actor.GetLink(), actor)
+	}
+	if len(got.Outbox) != 1 || !got.Outbox[0].GetLink().Equals(a.Outbox[0].GetLink(), true) {
+		t.Errorf("Invalid outbox %v, expected %v", got.Outbox, a.Outbox)
+	}
+	if len(got.FollowingAccounts) != len(a.FollowingAccounts) {
+		t.Fatalf("Invalid following accounts %v, expected %v", got.FollowingAccounts, a.FollowingAccounts)
+	}
+	for i, addr := range a.FollowingAccounts {
+		if got.FollowingAccounts[i] != addr {
+			t.Errorf("Invalid following account %q, expected %q", got.FollowingAccounts[i], addr)
+		}
+	}
+
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", name, err)
+	}
+	defer zr.Close()
+	found := false
+	for _, f := range zr.File {
+		if f.Name == "metadata.json" || f.Name == archiveFollowingName {
+			t.Errorf("the archive should not contain %s", f.Name)
+		}
+		found = found || f.Name == archiveFollowingAccountsName
+	}
+	if !found {
+		t.Errorf("the archive should contain %s", archiveFollowingAccountsName)
+	}
+}
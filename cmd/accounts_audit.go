@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-ap/errors"
+	s "github.com/go-ap/fedbox/storage"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var auditAccountCmd = &cli.Command{
+	Name:      "audit",
+	Usage:     "Shows the audit log of an account: its logins, token grants and password changes",
+	ArgsUsage: "ACTOR",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "unlock",
+			Usage: "Unlocks the account, if it's locked after too many failed login attempts",
+		},
+	},
+	Action: auditAccountAct(&ctl),
+}
+
+func auditAccountAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("Missing actor")
+		}
+		actor, err := c.loadActor(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		iri := actor.GetLink()
+		var m *s.Metadata
+		if ctx.Bool("unlock") {
+			m, err = c.updateMetadata(iri, func(m *s.Metadata) {
+				m.FailedLogins = 0
+				m.LockedUntil = time.Time{}
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Unlocked %s\n", iri)
+		} else {
+			metaLoader, ok := c.Storage.(s.MetadataTyper)
+			if !ok {
+				return errors.NotImplementedf("the storage does not support metadata")
+			}
+			if m, err = metaLoader.LoadMetadata(iri); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		if m == nil {
+			m = new(s.Metadata)
+		}
+		fmt.Printf("%s\n", iri)
+		if time.Now().Before(m.LockedUntil) {
+			fmt.Printf("\tLocked until %s, after %d failed login attempts\n", m.LockedUntil.Format(time.RFC3339), m.FailedLogins)
+		} else if m.FailedLogins > 0 {
+			fmt.Printf("\t%d failed login attempts\n", m.FailedLogins)
+		}
+		for _, e := range m.Audit {
+			fmt.Printf("%s %-16s", e.Time.Format(time.RFC3339), e.Event)
+			if len(e.IP) > 0 {
+				fmt.Printf(" ip=%s", e.IP)
+			}
+			if len(e.Client) > 0 {
+				fmt.Printf(" client=%s", e.Client)
+			}
+			if len(e.Detail) > 0 {
+				fmt.Printf(" %s", e.Detail)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+}
@@ -1,16 +1,21 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal"
 	s "github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/processing"
+	"github.com/go-ap/storage"
 	"gopkg.in/urfave/cli.v2"
+	"io"
 	"net/url"
 	"os"
 	"path"
@@ -38,6 +43,8 @@ var actorsCmd = &cli.Command{
 	Usage: "Actor management helper",
 	Subcommands: []*cli.Command{
 		addActor,
+		moveActorCmd,
+		aliasActorCmd,
 	},
 }
 
@@ -110,6 +117,7 @@ func (c *Control) AddActor(p *pub.Person, pw []byte) (*pub.Person, error) {
 			SharedInbox:                self.Inbox.GetLink(),
 			OauthAuthorizationEndpoint: self.ID.AddPath("/oauth/authorize"),
 			OauthTokenEndpoint:         self.ID.AddPath("/oauth/token"),
+			UploadMedia:                self.ID.AddPath("/uploadMedia"),
 		}
 	}
 
@@ -419,105 +427,337 @@ func (c *Control) Add(types []string) (pub.ItemCollection, error) {
 }
 
 var importCmd = &cli.Command{
-	Name:    "import",
-	Aliases: []string{"load"},
-	Usage:   "Imports ActivityPub objects",
+	Name:      "import",
+	Aliases:   []string{"load"},
+	Usage:     "Imports ActivityPub objects",
+	ArgsUsage: "[FILE...] (reads from stdin when no file, or '-', is given)",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:  "base",
-			Usage: fmt.Sprintf("The base IRI to replace"),
+			Usage: "The base IRI to replace",
+		},
+		&cli.BoolFlag{
+			Name:  "raw",
+			Usage: "Save the activities as they are, instead of processing them as client to server activities",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show what would be imported, without saving anything",
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "File where to write the items which failed to import, one JSON object per line",
 		},
 	},
 	Action: importPubObjects(&ctl),
 }
 
+// importError is an entry in the error report of an import
+type importError struct {
+	Source string                     `json:"source"`
+	Index  int                        `json:"index"`
+	ID     pub.IRI                    `json:"id,omitempty"`
+	Type   pub.ActivityVocabularyType `json:"type,omitempty"`
+	Error  string                     `json:"error"`
+}
+
+type importer struct {
+	st        storage.Store
+	processor processing.Processor
+	from      pub.IRI
+	to        pub.IRI
+	raw       bool
+	dryRun    bool
+	report    io.Writer
+
+	read        int
+	saved       int
+	processed   int
+	memberships int
+	skipped     int
+	failed      int
+}
+
 func importPubObjects(ctl *Control) cli.ActionFunc {
 	return func(c *cli.Context) error {
-		baseIRI := ctl.Conf.BaseURL
-		toReplace := c.String("base")
-		files := c.Args().Slice()
-
+		baseIRI := pub.IRI(ctl.Conf.BaseURL)
 		processor, _, err := processing.New(
-			processing.SetIRI(pub.IRI(baseIRI), app.InternalIRI),
+			processing.SetIRI(baseIRI, app.InternalIRI),
 			processing.SetStorage(ctl.Storage),
+			processing.SetIDGenerator(app.GenerateID(baseIRI)),
 		)
 		if err != nil {
 			Errf("Error initializing ActivityPub processor: %s", err)
 			return err
 		}
-		for _, name := range files {
-			f, err := os.Open(name)
+		imp := importer{
+			st:        ctl.Storage,
+			processor: processor,
+			from:      pub.IRI(c.String("base")),
+			to:        baseIRI,
+			raw:       c.Bool("raw"),
+			dryRun:    c.Bool("dry-run"),
+		}
+		if name := c.String("report"); name != "" {
+			f, err := os.Create(name)
 			if err != nil {
-				if os.IsNotExist(err) {
-					Errf("Invalid path %s", name)
-				} else {
-					Errf("Error %s", err)
-				}
+				return errors.Annotatef(err, "unable to create report file %s", name)
 			}
+			defer f.Close()
+			imp.report = f
+		}
 
-			s, err := f.Stat()
-			if err != nil {
-				Errf("Error %s", err)
+		files := c.Args().Slice()
+		if len(files) == 0 {
+			files = []string{"-"}
+		}
+		start := time.Now()
+		for _, name := range files {
+			if name == "-" {
+				imp.importFrom("stdin", os.Stdin)
 				continue
 			}
-			buf := make([]byte, s.Size())
-			size, err := f.Read(buf)
+			f, err := os.Open(name)
 			if err != nil {
-				Errf("Error %s", err)
+				imp.fail(name, 0, nil, err)
 				continue
 			}
-			if size == 0 {
-				Errf("Empty file %s", name)
-				continue
+			imp.importFrom(name, f)
+			f.Close()
+		}
+		imp.summary(time.Now().Sub(start))
+		if imp.failed > 0 {
+			return errors.Newf("%d items failed to import", imp.failed)
+		}
+		return nil
+	}
+}
+
+func (i *importer) fail(source string, idx int, it pub.Item, err error) {
+	i.failed++
+	e := importError{Source: source, Index: idx, Error: err.Error()}
+	if !pub.IsNil(it) {
+		e.ID = it.GetLink()
+		e.Type = it.GetType()
+	}
+	if i.report != nil {
+		if raw, err := json.Marshal(e); err == nil {
+			i.report.Write(append(raw, '\n'))
+		}
+		return
+	}
+	Errf("%s[%d] %s %s: %s", e.Source, e.Index, e.Type, e.ID, e.Error)
+}
+
+func (i *importer) summary(elapsed time.Duration) {
+	if i.dryRun {
+		fmt.Println("Dry run, nothing was saved")
+	}
+	fmt.Printf("Read items:             %d\n", i.read)
+	fmt.Printf("Saved objects:          %d\n", i.saved)
+	fmt.Printf("Processed activities:   %d\n", i.processed)
+	fmt.Printf("Collection memberships: %d\n", i.memberships)
+	fmt.Printf("Skipped:                %d\n", i.skipped)
+	fmt.Printf("Failed:                 %d\n", i.failed)
+	fmt.Printf("Elapsed time:           %s\n", elapsed)
+	if i.read > 0 {
+		perIt := time.Duration(int64(elapsed) / int64(i.read))
+		fmt.Printf("Elapsed time per item:  %s\n", perIt)
+	}
+}
+
+// importFrom reads the items from r and imports them one by one.
+// The input can be a single item, a JSON array of items, or one item per line (NDJSON).
+func (i *importer) importFrom(source string, r io.Reader) {
+	idx := 0
+	err := decodeItems(r, func(raw []byte, err error) {
+		idx++
+		if err != nil {
+			i.fail(source, idx, nil, errors.Annotatef(err, "invalid JSON"))
+			return
+		}
+		it, err := pub.UnmarshalJSON(raw)
+		if err != nil {
+			i.fail(source, idx, nil, errors.Annotatef(err, "invalid ActivityPub item"))
+			return
+		}
+		if len(i.from) > 0 {
+			it = internal.ReplaceHostInItem(it, i.from, i.to)
+		}
+		i.importItem(source, idx, it)
+	})
+	if err != nil {
+		i.fail(source, idx, nil, err)
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// decodeItems calls fn for every JSON value found in r, without loading the whole input in memory.
+// JSON arrays at the top level are split into their elements.
+// For NDJSON input every line is decoded on its own, so an invalid line doesn't stop the import.
+func decodeItems(r io.Reader, fn func([]byte, error)) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isSpace(b[0]) {
+			break
+		}
+		br.ReadByte()
+	}
+	first, _ := br.Peek(1)
+	if first[0] == '[' {
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			raw := json.RawMessage{}
+			if err := dec.Decode(&raw); err != nil {
+				return err
 			}
+			fn(raw, nil)
+		}
+		_, err := dec.Token()
+		return err
+	}
 
-			if len(toReplace) > 0 {
-				buf = bytes.Replace(buf, []byte(toReplace), []byte(baseIRI), -1)
+	line, err := br.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !json.Valid(bytes.TrimSpace(line)) {
+		// NOTE(marius): the first line is not a complete JSON value, so this is a single, indented, item
+		dec := json.NewDecoder(io.MultiReader(bytes.NewReader(line), br))
+		for {
+			raw := json.RawMessage{}
+			if err := dec.Decode(&raw); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
 			}
-			ob, err := pub.UnmarshalJSON(buf)
-			if err != nil {
-				Errf("Error unmarshaling JSON: %s", err)
-				continue
+			fn(raw, nil)
+		}
+	}
+	for {
+		if l := bytes.TrimSpace(line); len(l) > 0 {
+			if json.Valid(l) {
+				fn(l, nil)
+			} else {
+				fn(nil, errors.Newf("invalid JSON value"))
 			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if line, err = br.ReadBytes('\n'); err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
 
-			col := ob
-			if !ob.IsCollection() {
-				col = pub.ItemCollection{ob}
-			}
-			start := time.Now()
-			count := 0
-			pub.OnCollectionIntf(col, func(c pub.CollectionInterface) error {
-				for _, it := range c.Collection() {
-					typ := it.GetType()
-					fmt.Printf("Saving %s\n", it.GetID())
-
-					var err error
-					if pub.ActivityTypes.Contains(typ) || pub.IntransitiveActivityTypes.Contains(typ) {
-						err = pub.OnActivity(it, func(a *pub.Activity) error {
-							if a == nil {
-								return nil
-							}
-							_, err := processor.ProcessClientActivity(a)
-							return err
-						})
-					} else {
-						it, err = ctl.Storage.Save(it)
-					}
-					if err != nil {
-						Errf("Unable to save %s %s: %s", it.GetType(), it.GetID(), err)
-						continue
-					}
+func (i *importer) importItem(source string, idx int, it pub.Item) {
+	if pub.IsNil(it) {
+		return
+	}
+	if it.IsCollection() {
+		if len(it.GetLink()) == 0 || it.GetType() == pub.CollectionOfItems {
+			// NOTE(marius): a collection without an ID only groups the items we need to import
+			pub.OnCollectionIntf(it, func(c pub.CollectionInterface) error {
+				for _, el := range c.Collection() {
+					i.importItem(source, idx, el)
 				}
 				return nil
 			})
-			tot := time.Now().Sub(start)
-			fmt.Printf("Ellapsed time:          %s\n", tot)
-			if count > 0 {
-				perIt := time.Duration(int64(tot) / int64(count))
-				fmt.Printf("Ellapsed time per item: %s\n", perIt)
-			}
+			return
+		}
+		i.importCollection(source, idx, it)
+		return
+	}
+	if it.IsLink() {
+		i.skipped++
+		return
+	}
+	i.read++
+
+	typ := it.GetType()
+	if !i.raw && (pub.ActivityTypes.Contains(typ) || pub.IntransitiveActivityTypes.Contains(typ)) {
+		if i.dryRun {
+			fmt.Printf("Would process %s %s\n", typ, it.GetLink())
+			i.processed++
+			return
+		}
+		fmt.Printf("Processing %s %s\n", typ, it.GetLink())
+		err := pub.OnActivity(it, func(a *pub.Activity) error {
+			_, err := i.processor.ProcessClientActivity(a)
+			return err
+		})
+		if err != nil {
+			i.fail(source, idx, it, err)
+			return
 		}
+		i.processed++
+		return
+	}
+	if i.dryRun {
+		fmt.Printf("Would save %s %s\n", typ, it.GetLink())
+		i.saved++
+		return
+	}
+	fmt.Printf("Saving %s %s\n", typ, it.GetLink())
+	if _, err := i.st.Save(it); err != nil {
+		i.fail(source, idx, it, err)
+		return
+	}
+	i.saved++
+}
+
+// importCollection imports the items of the collection, and adds them to it
+func (i *importer) importCollection(source string, idx int, it pub.Item) {
+	col := it.GetLink()
+	items := make(pub.ItemCollection, 0)
+	pub.OnCollectionIntf(it, func(c pub.CollectionInterface) error {
+		items = append(items, c.Collection()...)
 		return nil
+	})
+	for _, el := range items {
+		if !el.IsLink() {
+			i.importItem(source, idx, el)
+		}
+	}
+	if i.dryRun {
+		fmt.Printf("Would add %d items to %s\n", len(items), col)
+		i.memberships += len(items)
+		return
+	}
+	existing, err := loadItems(i.st, col)
+	if err != nil {
+		if _, err := i.st.Create(&pub.OrderedCollection{ID: col, Type: pub.OrderedCollectionType}); err != nil {
+			i.fail(source, idx, it, errors.Annotatef(err, "unable to create collection"))
+			return
+		}
+	}
+	present := iriSet(existing)
+	fmt.Printf("Adding %d items to %s\n", len(items), col)
+	for _, el := range items {
+		if present[el.GetLink()] {
+			i.skipped++
+			continue
+		}
+		if err := i.st.AddTo(col, el.GetLink()); err != nil {
+			i.fail(source, idx, el, errors.Annotatef(err, "unable to add to %s", col))
+			continue
+		}
+		present[el.GetLink()] = true
+		i.memberships++
 	}
 }
 
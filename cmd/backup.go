@@ -0,0 +1,841 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-ap/errors"
+	fedbox "github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/openshift/osin"
+	"gopkg.in/urfave/cli.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var BackupCmd = &cli.Command{
+	Name:  "backup",
+	Usage: "Backup and restore helper",
+	Subcommands: []*cli.Command{
+		backupCreateCmd,
+		backupRestoreCmd,
+		backupVerifyCmd,
+		backupListCmd,
+	},
+}
+
+var backupDirFlag = &cli.StringFlag{
+	Name:  "dir",
+	Usage: "the folder where the backups are stored (default: the backups folder in the storage path)",
+}
+
+var backupCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "Creates a backup archive with a snapshot of the storage and of the OAuth2 storage",
+	Flags: []cli.Flag{
+		backupDirFlag,
+		&cli.BoolFlag{
+			Name:  "skip-tokens",
+			Usage: "create the backup without the OAuth2 authorization codes and access tokens, when the OAuth2 storage can not list them",
+		},
+	},
+	Action: backupCreateAct(&ctl),
+}
+
+var backupRestoreCmd = &cli.Command{
+	Name:      "restore",
+	Usage:     "Restores the storage and the OAuth2 storage from a backup archive",
+	ArgsUsage: "[archive]",
+	Flags: []cli.Flag{
+		backupDirFlag,
+		&cli.StringFlag{
+			Name:  "at",
+			Usage: "restore the most recent backup created at, or before, this time (RFC3339)",
+		},
+	},
+	Action: backupRestoreAct(&ctl),
+}
+
+var backupVerifyCmd = &cli.Command{
+	Name:      "verify",
+	Usage:     "Checks the integrity of a backup archive",
+	ArgsUsage: "archive...",
+	Action:    backupVerifyAct(&ctl),
+}
+
+var backupListCmd = &cli.Command{
+	Name:   "ls",
+	Usage:  "Lists the existing backups",
+	Flags:  []cli.Flag{backupDirFlag},
+	Action: backupListAct(&ctl),
+}
+
+const (
+	backupManifestName  = "manifest.json"
+	backupStorageName   = "storage.snapshot"
+	backupOAuthName     = "oauth.snapshot"
+	backupOAuthJSONName = "oauth.json"
+	backupOAuthJSON     = "json"
+	backupVersion       = 1
+)
+
+type backupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupManifest describes the contents of a backup archive
+type backupManifest struct {
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"createdAt"`
+	BaseURL   string             `json:"baseURL"`
+	Env       env.Type           `json:"env"`
+	Storage   config.StorageType `json:"storage"`
+	// OAuth is the storage type of the native OAuth2 snapshot, or "json" for the portable dump
+	OAuth string       `json:"oauth"`
+	Files []backupFile `json:"files"`
+}
+
+func (m backupManifest) file(name string) (backupFile, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return backupFile{}, false
+}
+
+func backupDir(c *Control, ctx *cli.Context) string {
+	if dir := ctx.String("dir"); dir != "" {
+		return dir
+	}
+	p := c.Conf.StoragePath
+	if !filepath.IsAbs(p) {
+		p, _ = filepath.Abs(p)
+	}
+	return filepath.Join(p, "backups")
+}
+
+func backupCreateAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		dir := backupDir(c, ctx)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.Annotatef(err, "unable to create backups folder %s", dir)
+		}
+		p, m, err := c.CreateBackup(dir, ctx.Bool("skip-tokens"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created backup %s (storage %s, OAuth2 %s)\n", p, m.Storage, m.OAuth)
+		return nil
+	}
+}
+
+func backupVerifyAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("missing backup archive")
+		}
+		failed := 0
+		for _, p := range ctx.Args().Slice() {
+			m, err := verifyBackup(p)
+			if err != nil {
+				Errf("%s: %s", p, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: ok, created at %s\n", p, m.CreatedAt.Format(time.RFC3339))
+		}
+		if failed > 0 {
+			return errors.Newf("%d archives failed verification", failed)
+		}
+		return nil
+	}
+}
+
+func backupListAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		backups, err := listBackups(backupDir(c, ctx))
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Printf("%s %s %s\n", b.manifest.CreatedAt.Format(time.RFC3339), b.manifest.Storage, b.path)
+		}
+		return nil
+	}
+}
+
+func backupRestoreAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		p := ctx.Args().First()
+		if p == "" {
+			at := time.Now().UTC()
+			if t := ctx.String("at"); t != "" {
+				var err error
+				if at, err = time.Parse(time.RFC3339, t); err != nil {
+					return errors.Annotatef(err, "invalid time %s", t)
+				}
+			}
+			backups, err := listBackups(backupDir(c, ctx))
+			if err != nil {
+				return err
+			}
+			for _, b := range backups {
+				if b.manifest.CreatedAt.After(at) {
+					break
+				}
+				p = b.path
+			}
+			if p == "" {
+				return errors.NotFoundf("no backup created before %s", at.Format(time.RFC3339))
+			}
+		}
+		m, err := c.RestoreBackup(p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored backup %s created at %s\n", p, m.CreatedAt.Format(time.RFC3339))
+		return nil
+	}
+}
+
+// CreateBackup writes a new backup archive in dir and returns its path.
+// When the OAuth2 storage has no native snapshot, and can't list its tokens, it fails unless skipTokens is set.
+func (c *Control) CreateBackup(dir string, skipTokens bool) (string, *backupManifest, error) {
+	b, ok := c.Storage.(s.Backuper)
+	if !ok {
+		return "", nil, errors.NotImplementedf("%s storage does not support backups", c.Conf.Storage)
+	}
+	tmp, err := ioutil.TempDir(dir, ".backup")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	m := backupManifest{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+		BaseURL:   c.Conf.BaseURL,
+		Env:       c.Conf.Env,
+		Storage:   c.Conf.Storage,
+		OAuth:     string(c.Conf.Storage),
+	}
+	f, err := writeBackupFile(tmp, backupStorageName, b.Backup)
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "unable to snapshot the storage")
+	}
+	m.Files = append(m.Files, f)
+
+	f, err = writeBackupFile(tmp, backupOAuthName, func(w io.Writer) error {
+		return oauthBackupFn(c.Conf, w)
+	})
+	if errors.IsNotImplemented(err) {
+		m.OAuth = backupOAuthJSON
+		f, err = writeBackupFile(tmp, backupOAuthJSONName, func(w io.Writer) error {
+			return dumpOAuth(c.AuthStorage, w, skipTokens)
+		})
+	}
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "unable to snapshot the OAuth2 storage")
+	}
+	m.Files = append(m.Files, f)
+
+	name := fmt.Sprintf("fedbox-%s-%s-%s.tar.gz", c.Conf.Host, c.Conf.Env, m.CreatedAt.Format("20060102T150405Z"))
+	p := filepath.Join(dir, name)
+	if err = writeBackupArchive(p, tmp, m); err != nil {
+		return "", nil, err
+	}
+	return p, &m, nil
+}
+
+// RestoreBackup verifies the backup archive found at p and replaces the storage and the OAuth2 storage with its contents.
+// The two storages are restored one after the other, so if restoring the OAuth2 storage fails,
+// both of them are put back from the snapshots taken before the restore.
+func (c *Control) RestoreBackup(p string) (*backupManifest, error) {
+	m, err := verifyBackup(p)
+	if err != nil {
+		return nil, errors.Annotatef(err, "backup %s failed verification", p)
+	}
+	if m.Storage != c.Conf.Storage {
+		return nil, errors.Newf("backup %s is for %s storage, current storage is %s; restore it with the same storage and use 'storage migrate'", p, m.Storage, c.Conf.Storage)
+	}
+	b, ok := c.Storage.(s.Backuper)
+	if !ok {
+		return nil, errors.NotImplementedf("%s storage does not support backups", c.Conf.Storage)
+	}
+	oauthName := backupOAuthName
+	oauthBackup := func(w io.Writer) error { return oauthBackupFn(c.Conf, w) }
+	oauthRestore := func(r io.Reader) error { return oauthRestoreFn(c.Conf, r) }
+	if m.OAuth == backupOAuthJSON {
+		oauthName = backupOAuthJSONName
+		// NOTE(marius): the snapshot has the tokens only if the storage can list them, but then
+		// the restore can't remove them either, so the ones it can't list are left unchanged
+		oauthBackup = func(w io.Writer) error { return dumpOAuth(c.AuthStorage, w, true) }
+		oauthRestore = func(r io.Reader) error { return loadOAuth(c.AuthStorage, r) }
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(p), ".restore")
+	if err != nil {
+		return nil, err
+	}
+	prev, err := writeBackupFile(tmp, backupStorageName, b.Backup)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, errors.Annotatef(err, "unable to snapshot the current storage")
+	}
+	prevPath := filepath.Join(tmp, prev.Name)
+	prevOAuth, err := writeBackupFile(tmp, oauthName, oauthBackup)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, errors.Annotatef(err, "unable to snapshot the current OAuth2 storage")
+	}
+	prevOAuthPath := filepath.Join(tmp, prevOAuth.Name)
+
+	if err = restoreArchiveFile(p, backupStorageName, b.Restore); err != nil {
+		os.RemoveAll(tmp)
+		return nil, errors.Annotatef(err, "unable to restore the storage from backup %s", p)
+	}
+	if err = restoreArchiveFile(p, oauthName, oauthRestore); err != nil {
+		failed := make([]string, 0)
+		if rerr := restoreFile(prevOAuthPath, oauthRestore); rerr != nil {
+			failed = append(failed, fmt.Sprintf("the OAuth2 storage may be partially restored: %s", rerr))
+		}
+		if rerr := restoreFile(prevPath, b.Restore); rerr != nil {
+			failed = append(failed, fmt.Sprintf("unable to put back the previous storage: %s", rerr))
+		}
+		if len(failed) > 0 {
+			// NOTE(marius): we keep the snapshots, so the previous storages can be restored by hand
+			return nil, errors.Annotatef(err, "unable to restore the OAuth2 storage from backup %s, the previous storages are saved in %s; %s", p, tmp, strings.Join(failed, "; "))
+		}
+		os.RemoveAll(tmp)
+		return nil, errors.Annotatef(err, "unable to restore the OAuth2 storage from backup %s, the storages were left unchanged", p)
+	}
+	os.RemoveAll(tmp)
+	if r, ok := c.Storage.(s.Resetter); ok {
+		r.Reset()
+	}
+	if m.OAuth == backupOAuthJSON {
+		_, okAuth := c.AuthStorage.(fedbox.AuthorizeLister)
+		_, okAccess := c.AuthStorage.(fedbox.AccessLister)
+		if !okAuth || !okAccess {
+			Errf("The OAuth2 storage can not list the authorization codes and the access tokens, the ones created after the backup were not removed")
+		}
+	}
+	return m, nil
+}
+
+// restoreArchiveFile calls fn with the contents of the file with the name from the archive found at p
+func restoreArchiveFile(p, name string, fn func(io.Reader) error) error {
+	found := false
+	err := readBackupArchive(p, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != name {
+			return nil
+		}
+		found = true
+		return fn(r)
+	})
+	if err == nil && !found {
+		err = errors.NotFoundf("missing %s in backup archive", name)
+	}
+	return err
+}
+
+func restoreFile(p string, fn func(io.Reader) error) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+func writeBackupFile(dir, name string, fn func(io.Writer) error) (backupFile, error) {
+	bf := backupFile{Name: name}
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return bf, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(f, h)}
+	if err = fn(cw); err != nil {
+		return bf, err
+	}
+	bf.Size = cw.n
+	bf.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return bf, f.Sync()
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeBackupArchive writes the manifest and the files from dir to a gzipped tar archive.
+// The archive is written to a temporary file which gets renamed at the end, so it's never left half written.
+func writeBackupArchive(p, dir string, m backupManifest) error {
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		zw := gzip.NewWriter(f)
+		tw := tar.NewWriter(zw)
+		raw, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(raw)), ModTime: m.CreatedAt}
+		if err = tw.WriteHeader(&hdr); err != nil {
+			return err
+		}
+		if _, err = tw.Write(raw); err != nil {
+			return err
+		}
+		for _, bf := range m.Files {
+			hdr := tar.Header{Name: bf.Name, Mode: 0600, Size: bf.Size, ModTime: m.CreatedAt}
+			if err = tw.WriteHeader(&hdr); err != nil {
+				return err
+			}
+			src, err := os.Open(filepath.Join(dir, bf.Name))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, src)
+			src.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if err = tw.Close(); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Annotatef(err, "unable to write backup archive %s", p)
+	}
+	return os.Rename(tmp, p)
+}
+
+// readBackupArchive calls fn for every file in the archive found at p, except the manifest
+func readBackupArchive(p string, fn func(*tar.Header, io.Reader) error) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == backupManifestName {
+			continue
+		}
+		if err = fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func loadBackupManifest(p string) (*backupManifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != backupManifestName {
+		return nil, errors.Newf("missing backup manifest")
+	}
+	m := backupManifest{}
+	if err = json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, errors.Annotatef(err, "invalid backup manifest")
+	}
+	return &m, nil
+}
+
+// verifyBackup checks that all the files listed in the manifest of the archive at p exist,
+// and that their sizes and checksums match
+func verifyBackup(p string) (*backupManifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != backupManifestName {
+		return nil, errors.Newf("missing backup manifest")
+	}
+	m := backupManifest{}
+	if err = json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, errors.Annotatef(err, "invalid backup manifest")
+	}
+	if m.Version > backupVersion {
+		return nil, errors.Newf("unsupported backup version %d", m.Version)
+	}
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		bf, ok := m.file(hdr.Name)
+		if !ok {
+			return nil, errors.Newf("unexpected file %s", hdr.Name)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, tr)
+		if err != nil {
+			return nil, err
+		}
+		if n != bf.Size {
+			return nil, errors.Newf("size mismatch for %s: %d, expected %d", bf.Name, n, bf.Size)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != bf.SHA256 {
+			return nil, errors.Newf("checksum mismatch for %s", bf.Name)
+		}
+		seen[bf.Name] = true
+	}
+	for _, bf := range m.Files {
+		if !seen[bf.Name] {
+			return nil, errors.Newf("missing file %s", bf.Name)
+		}
+	}
+	return &m, nil
+}
+
+type backupInfo struct {
+	path     string
+	manifest *backupManifest
+}
+
+// listBackups loads the backups found in dir, sorted by their creation time
+func listBackups(dir string) ([]backupInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read backups folder %s", dir)
+	}
+	backups := make([]backupInfo, 0)
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".tar.gz") {
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		m, err := loadBackupManifest(p)
+		if err != nil {
+			Errf("Skipping %s: %s", p, err)
+			continue
+		}
+		backups = append(backups, backupInfo{path: p, manifest: m})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].manifest.CreatedAt.Before(backups[j].manifest.CreatedAt)
+	})
+	return backups, nil
+}
+
+// oauthDump is the portable format for the OAuth2 storage, used when there's no native snapshot for it
+type oauthDump struct {
+	Clients   []oauthClient    `json:"clients"`
+	Authorize []oauthAuthorize `json:"authorize,omitempty"`
+	Access    []oauthAccess    `json:"access,omitempty"`
+}
+
+type oauthClient struct {
+	ID          string      `json:"id"`
+	Secret      string      `json:"secret"`
+	RedirectURI string      `json:"redirectUri"`
+	UserData    interface{} `json:"userData,omitempty"`
+}
+
+type oauthAuthorize struct {
+	ClientID            string      `json:"clientId"`
+	Code                string      `json:"code"`
+	ExpiresIn           int32       `json:"expiresIn"`
+	Scope               string      `json:"scope"`
+	RedirectURI         string      `json:"redirectUri"`
+	State               string      `json:"state"`
+	CreatedAt           time.Time   `json:"createdAt"`
+	UserData            interface{} `json:"userData,omitempty"`
+	CodeChallenge       string      `json:"codeChallenge,omitempty"`
+	CodeChallengeMethod string      `json:"codeChallengeMethod,omitempty"`
+}
+
+type oauthAccess struct {
+	ClientID     string      `json:"clientId"`
+	AccessToken  string      `json:"accessToken"`
+	RefreshToken string      `json:"refreshToken"`
+	ExpiresIn    int32       `json:"expiresIn"`
+	Scope        string      `json:"scope"`
+	RedirectURI  string      `json:"redirectUri"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	UserData     interface{} `json:"userData,omitempty"`
+}
+
+// dumpOAuth writes the portable dump of the OAuth2 storage to w. When the storage can't list
+// the authorization codes and the access tokens it fails, unless skipTokens is set.
+func dumpOAuth(st osin.Storage, w io.Writer, skipTokens bool) error {
+	dump := oauthDump{}
+	lister, ok := st.(fedbox.ClientLister)
+	if !ok {
+		return errors.NotImplementedf("the OAuth2 storage can not list clients")
+	}
+	clients, err := lister.ListClients()
+	if err != nil {
+		return err
+	}
+	for _, cl := range clients {
+		dump.Clients = append(dump.Clients, oauthClient{
+			ID:          cl.GetId(),
+			Secret:      cl.GetSecret(),
+			RedirectURI: cl.GetRedirectUri(),
+			UserData:    cl.GetUserData(),
+		})
+	}
+	if !skipTokens {
+		_, okAuth := st.(fedbox.AuthorizeLister)
+		_, okAccess := st.(fedbox.AccessLister)
+		if !okAuth || !okAccess {
+			return errors.NotImplementedf("the OAuth2 storage can not list the authorization codes and the access tokens, use --skip-tokens to backup without them")
+		}
+	}
+	if lister, ok := st.(fedbox.AuthorizeLister); ok {
+		auths, err := lister.ListAuthorize()
+		if err != nil {
+			return err
+		}
+		for _, a := range auths {
+			dump.Authorize = append(dump.Authorize, oauthAuthorize{
+				ClientID:            a.Client.GetId(),
+				Code:                a.Code,
+				ExpiresIn:           a.ExpiresIn,
+				Scope:               a.Scope,
+				RedirectURI:         a.RedirectUri,
+				State:               a.State,
+				CreatedAt:           a.CreatedAt,
+				UserData:            a.UserData,
+				CodeChallenge:       a.CodeChallenge,
+				CodeChallengeMethod: a.CodeChallengeMethod,
+			})
+		}
+	}
+	if lister, ok := st.(fedbox.AccessLister); ok {
+		tokens, err := lister.ListAccess()
+		if err != nil {
+			return err
+		}
+		for _, a := range tokens {
+			dump.Access = append(dump.Access, oauthAccess{
+				ClientID:     a.Client.GetId(),
+				AccessToken:  a.AccessToken,
+				RefreshToken: a.RefreshToken,
+				ExpiresIn:    a.ExpiresIn,
+				Scope:        a.Scope,
+				RedirectURI:  a.RedirectUri,
+				CreatedAt:    a.CreatedAt,
+				UserData:     a.UserData,
+			})
+		}
+	}
+	return json.NewEncoder(w).Encode(dump)
+}
+
+// loadOAuth replaces the contents of the OAuth2 storage with the dump read from r. The clients missing from the dump
+// are removed, and so are the authorization codes and the access tokens, when the storage can list them.
+func loadOAuth(st osin.Storage, r io.Reader) error {
+	dump := oauthDump{}
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return errors.Annotatef(err, "invalid OAuth2 dump")
+	}
+	saver, ok := st.(fedbox.ClientSaver)
+	if !ok {
+		return errors.NotImplementedf("the OAuth2 storage can not save clients")
+	}
+	if err := removeMissingOAuth(st, saver, dump); err != nil {
+		return err
+	}
+	for _, cl := range dump.Clients {
+		c := osin.DefaultClient{
+			Id:          cl.ID,
+			Secret:      cl.Secret,
+			RedirectUri: cl.RedirectURI,
+			UserData:    cl.UserData,
+		}
+		var err error
+		if old, _ := st.GetClient(cl.ID); old != nil {
+			err = saver.UpdateClient(&c)
+		} else {
+			err = saver.CreateClient(&c)
+		}
+		if err != nil {
+			return errors.Annotatef(err, "unable to save client %s", cl.ID)
+		}
+	}
+	for _, a := range dump.Authorize {
+		cl, err := st.GetClient(a.ClientID)
+		if err != nil {
+			return errors.Annotatef(err, "unable to load client %s", a.ClientID)
+		}
+		err = st.SaveAuthorize(&osin.AuthorizeData{
+			Client:              cl,
+			Code:                a.Code,
+			ExpiresIn:           a.ExpiresIn,
+			Scope:               a.Scope,
+			RedirectUri:         a.RedirectURI,
+			State:               a.State,
+			CreatedAt:           a.CreatedAt,
+			UserData:            a.UserData,
+			CodeChallenge:       a.CodeChallenge,
+			CodeChallengeMethod: a.CodeChallengeMethod,
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to save authorization code for client %s", a.ClientID)
+		}
+	}
+	for _, a := range dump.Access {
+		cl, err := st.GetClient(a.ClientID)
+		if err != nil {
+			return errors.Annotatef(err, "unable to load client %s", a.ClientID)
+		}
+		err = st.SaveAccess(&osin.AccessData{
+			Client:       cl,
+			AccessToken:  a.AccessToken,
+			RefreshToken: a.RefreshToken,
+			ExpiresIn:    a.ExpiresIn,
+			Scope:        a.Scope,
+			RedirectUri:  a.RedirectURI,
+			CreatedAt:    a.CreatedAt,
+			UserData:     a.UserData,
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to save access token for client %s", a.ClientID)
+		}
+	}
+	return nil
+}
+
+// removeMissingOAuth removes from the OAuth2 storage the clients, the authorization codes and the access tokens
+// which are not in the dump. The authorization codes and the access tokens are removed only if the storage can list them.
+func removeMissingOAuth(st osin.Storage, saver fedbox.ClientSaver, dump oauthDump) error {
+	lister, ok := st.(fedbox.ClientLister)
+	if !ok {
+		return errors.NotImplementedf("the OAuth2 storage can not list clients")
+	}
+	clients := make(map[string]bool)
+	for _, cl := range dump.Clients {
+		clients[cl.ID] = true
+	}
+	existing, err := lister.ListClients()
+	if err != nil {
+		return err
+	}
+	for _, cl := range existing {
+		if clients[cl.GetId()] {
+			continue
+		}
+		if err = saver.RemoveClient(cl.GetId()); err != nil {
+			return errors.Annotatef(err, "unable to remove client %s", cl.GetId())
+		}
+	}
+	if lister, ok := st.(fedbox.AuthorizeLister); ok {
+		codes := make(map[string]bool)
+		for _, a := range dump.Authorize {
+			codes[a.Code] = true
+		}
+		auths, err := lister.ListAuthorize()
+		if err != nil {
+			return err
+		}
+		for _, a := range auths {
+			if codes[a.Code] {
+				continue
+			}
+			if err = st.RemoveAuthorize(a.Code); err != nil {
+				return errors.Annotatef(err, "unable to remove an authorization code")
+			}
+		}
+	}
+	if lister, ok := st.(fedbox.AccessLister); ok {
+		tokens := make(map[string]bool)
+		refresh := make(map[string]bool)
+		for _, a := range dump.Access {
+			tokens[a.AccessToken] = true
+			refresh[a.RefreshToken] = true
+		}
+		access, err := lister.ListAccess()
+		if err != nil {
+			return err
+		}
+		for _, a := range access {
+			if !tokens[a.AccessToken] {
+				if err = st.RemoveAccess(a.AccessToken); err != nil {
+					return errors.Annotatef(err, "unable to remove an access token")
+				}
+			}
+			if a.RefreshToken != "" && !refresh[a.RefreshToken] {
+				if err = st.RemoveRefresh(a.RefreshToken); err != nil {
+					return errors.Annotatef(err, "unable to remove a refresh token")
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/go-ap/fedbox/storage/pgx"
 	"github.com/go-ap/fedbox/storage/sqlite"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
 )
 
 var bootstrapFn = func(conf config.Options) error {
@@ -68,3 +69,23 @@ var cleanFn = func(conf config.Options) error {
 	}
 	return errors.NotImplementedf("Invalid storage type %s", conf.Storage)
 }
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	switch conf.Storage {
+	case config.StorageBoltDB:
+		return boltdb.BackupFile(conf.BoltDBOAuth2(), w)
+	case config.StorageBadger:
+		return badger.BackupDir(conf.BadgerOAuth2(), w)
+	}
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	switch conf.Storage {
+	case config.StorageBoltDB:
+		return boltdb.RestoreFile(conf.BoltDBOAuth2(), r)
+	case config.StorageBadger:
+		return badger.RestoreDir(conf.BadgerOAuth2(), r)
+	}
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
@@ -2,8 +2,20 @@
 
 package cmd
 
-import "github.com/go-ap/fedbox/storage/badger"
+import (
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/badger"
+	"io"
+)
 
 var bootstrapFn = badger.Bootstrap
 
 var cleanFn = badger.Clean
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	return badger.BackupDir(conf.BadgerOAuth2(), w)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	return badger.RestoreDir(conf.BadgerOAuth2(), r)
+}
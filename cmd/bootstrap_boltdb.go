@@ -2,8 +2,20 @@
 
 package cmd
 
-import "github.com/go-ap/fedbox/storage/boltdb"
+import (
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/boltdb"
+	"io"
+)
 
 var bootstrapFn = boltdb.Bootstrap
 
 var cleanFn = boltdb.Clean
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	return boltdb.BackupFile(conf.BoltDBOAuth2(), w)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	return boltdb.RestoreFile(conf.BoltDBOAuth2(), r)
+}
@@ -2,8 +2,21 @@
 
 package cmd
 
-import "github.com/go-ap/fedbox/storage/fs"
+import (
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/fs"
+	"io"
+)
 
 var bootstrapFn = fs.Bootstrap
 
 var cleanFn = fs.Clean
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
@@ -4,9 +4,11 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/config"
 	"github.com/go-ap/fedbox/storage/pgx"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
 )
 
 var bootstrapFn = func(conf config.Options) error {
@@ -26,3 +28,11 @@ var cleanFn = func(conf config.Options) (err error) {
 	fmt.Println()
 	return pgx.Clean(conf, pgRoot, pgPw)
 }
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
@@ -4,8 +4,10 @@ package cmd
 
 import (
 	auth "github.com/go-ap/auth/sqlite"
+	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/config"
 	"github.com/go-ap/fedbox/storage/sqlite"
+	"io"
 )
 
 var bootstrapFn = func (conf config.Options) error {
@@ -18,3 +20,11 @@ var bootstrapFn = func (conf config.Options) error {
 var cleanFn = func (conf config.Options) error {
 	return sqlite.Clean(conf)
 }
+
+var oauthBackupFn = func(conf config.Options, w io.Writer) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
+
+var oauthRestoreFn = func(conf config.Options, r io.Reader) error {
+	return errors.NotImplementedf("no native snapshot for %s OAuth2 storage", conf.Storage)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/storage"
+	"gopkg.in/urfave/cli.v2"
+	"time"
+)
+
+var DbCmd = &cli.Command{
+	Name:  "db",
+	Usage: "Database schema helper",
+	Subcommands: []*cli.Command{
+		dbMigrateCmd,
+		dbStatusCmd,
+	},
+}
+
+var dbMigrateCmd = &cli.Command{
+	Name:   "migrate",
+	Usage:  "Applies the pending schema migrations",
+	Action: dbMigrateAct(&ctl),
+}
+
+var dbStatusCmd = &cli.Command{
+	Name:   "status",
+	Usage:  "Shows the applied and pending schema migrations",
+	Action: dbStatusAct(&ctl),
+}
+
+func migrator(c *Control) (storage.Migrator, error) {
+	m, ok := c.Storage.(storage.Migrator)
+	if !ok {
+		return nil, errors.NotImplementedf("storage %s does not support schema migrations", c.Conf.Storage)
+	}
+	return m, nil
+}
+
+func dbMigrateAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		m, err := migrator(c)
+		if err != nil {
+			return err
+		}
+		done, err := m.Migrate()
+		for _, st := range done {
+			fmt.Printf("Applied %d %s\n", st.Version, st.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return nil
+	}
+}
+
+func dbStatusAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		m, err := migrator(c)
+		if err != nil {
+			return err
+		}
+		status, err := m.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.Applied {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d %-32s %s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/go-ap/fedbox/internal/env"
 	"github.com/go-ap/fedbox/internal/log"
 	"gopkg.in/urfave/cli.v2"
-	"net/http/pprof"
 	"time"
 )
 
@@ -21,7 +20,7 @@ func NewApp(version string) *cli.App {
 		Flags: []cli.Flag{
 			&cli.DurationFlag{
 				Name:  "wait",
-				Usage: "the duration for which the server gracefully wait for existing connections to finish",
+				Usage: "the duration for which the server waits for the requests and the webhook deliveries in progress to finish, when stopping",
 				Value: defaultTimeout,
 			},
 			&cli.StringFlag{
@@ -53,13 +52,6 @@ func run(version string) cli.ActionFunc {
 			return err
 		}
 
-		// Register pprof handlers
-		a.R.HandleFunc("/debug/pprof/", pprof.Index)
-		a.R.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-		a.R.HandleFunc("/debug/pprof/profile", pprof.Profile)
-		a.R.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-		a.R.HandleFunc("/debug/pprof/trace", pprof.Trace)
-
 		return a.Run()
 	}
 }
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/app"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/handlers"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var moveActorCmd = &cli.Command{
+	Name:      "move",
+	Usage:     "Moves a local actor to another one, on this server or on a different one",
+	ArgsUsage: "ACTOR",
+	Description: "The target actor needs to have the moved actor in its aliases. A Move activity is sent to the followers " +
+		"of the actor, and the local actors following it will follow the target instead.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "The IRI of the actor to move to",
+		},
+	},
+	Action: moveActorAct(&ctl),
+}
+
+var aliasActorCmd = &cli.Command{
+	Name:      "alias",
+	Usage:     "Adds, or removes, IRIs to the aliases (alsoKnownAs) of a local actor, to allow accounts to be moved to it",
+	ArgsUsage: "ACTOR [IRI...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "remove",
+			Usage: "Remove the IRIs from the aliases",
+		},
+	},
+	Action: aliasActorAct(&ctl),
+}
+
+func (c *Control) updateMetadata(iri pub.IRI, fn func(m *s.Metadata)) (*s.Metadata, error) {
+	metaSaver, ok := c.Storage.(s.MetadataTyper)
+	if !ok {
+		return nil, errors.NotImplementedf("the storage does not support metadata")
+	}
+	return s.UpdateMetadata(metaSaver, iri, fn)
+}
+
+func aliasActorAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("Missing actor")
+		}
+		actor, err := c.loadActor(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		iris := ctx.Args().Slice()[1:]
+		m, err := c.updateMetadata(actor.GetLink(), func(m *s.Metadata) {
+			for _, iri := range iris {
+				aliases := make(pub.IRIs, 0, len(m.AlsoKnownAs))
+				for _, alias := range m.AlsoKnownAs {
+					if !alias.Equals(pub.IRI(iri), false) {
+						aliases = append(aliases, alias)
+					}
+				}
+				if !ctx.Bool("remove") {
+					aliases = append(aliases, pub.IRI(iri))
+				}
+				m.AlsoKnownAs = aliases
+			}
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Aliases of %s:\n", actor.GetLink())
+		for _, alias := range m.AlsoKnownAs {
+			fmt.Printf("\t%s\n", alias)
+		}
+		return nil
+	}
+}
+
+func moveActorAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("Missing actor")
+		}
+		to := pub.IRI(ctx.String("to"))
+		if len(to) == 0 {
+			return errors.Newf("Missing --to actor")
+		}
+		actor, err := c.loadActor(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		from := actor.GetLink()
+		baseIRI := pub.IRI(c.Conf.BaseURL)
+
+		aliases, err := app.LoadAlsoKnownAs(c.Storage, baseIRI, to)
+		if err != nil {
+			return errors.Annotatef(err, "unable to verify the aliases of %s", to)
+		}
+		if !aliases.Contains(from) {
+			return errors.Newf("%s needs to have %s in its aliases before moving", to, from)
+		}
+		if _, err = c.updateMetadata(from, func(m *s.Metadata) { m.MovedTo = to }); err != nil {
+			return err
+		}
+
+		move := &pub.Move{
+			Type:   pub.MoveType,
+			Actor:  from,
+			Object: from,
+			Target: to,
+			To:     pub.ItemCollection{handlers.Followers.IRI(actor)},
+		}
+		if _, err = c.Saver.ProcessClientActivity(move); err != nil {
+			return errors.Annotatef(err, "unable to send the Move activity")
+		}
+		count, err := app.RepointFollows(c.Storage, c.Saver.ProcessClientActivity, baseIRI, from, to)
+		fmt.Printf("Moved %s to %s, %d local followers now follow %s\n", from, to, count, to)
+		if err != nil {
+			return errors.Annotatef(err, "run the command again to move the rest of them")
+		}
+		return nil
+	}
+}
@@ -124,6 +124,16 @@ var tokenAdd = &cli.Command{
 			Name:  "actor",
 			Usage: "The actor identifier we want to generate the authorization for (ID)",
 		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "The space separated scopes of the token: read, write, follow, admin",
+			Value: fedbox.DefaultScope,
+		},
+		&cli.DurationFlag{
+			Name:  "expires",
+			Usage: "The duration after which the token expires",
+			Value: 24 * time.Hour,
+		},
 	},
 	Action: tokenAct(&ctl),
 }
@@ -147,7 +157,11 @@ func tokenAct(c *Control) cli.ActionFunc {
 		if clientID == "" {
 			return errors.Newf("Need to provide the actor identifier (ID)")
 		}
-		tok, err := ctl.GenAuthToken(clientID, actor, nil)
+		scope, err := fedbox.ParseScope(c.String("scope"))
+		if err != nil {
+			return err
+		}
+		tok, err := ctl.GenAuthToken(clientID, actor, scope, c.Duration("expires"), nil)
 		if err == nil {
 			fmt.Printf("Authorization: Bearer %s\n", tok)
 		}
@@ -230,7 +244,7 @@ func (c *Control) ListClients() ([]osin.Client, error) {
 	return nil, err
 }
 
-func (c *Control) GenAuthToken(clientID, actorIdentifier string, dat interface{}) (string, error) {
+func (c *Control) GenAuthToken(clientID, actorIdentifier, scope string, expires time.Duration, dat interface{}) (string, error) {
 	cl, err := c.AuthStorage.GetClient(clientID)
 	if err != nil {
 		return "", err
@@ -270,9 +284,10 @@ func (c *Control) GenAuthToken(clientID, actorIdentifier string, dat interface{}
 	aud := &osin.AuthorizeData{
 		Client:      cl,
 		CreatedAt:   now,
-		ExpiresIn:   86400,
+		ExpiresIn:   int32(expires.Seconds()),
 		RedirectUri: cl.GetRedirectUri(),
 		State:       "state",
+		Scope:       scope,
 	}
 
 	// generate token code
@@ -287,9 +302,9 @@ func (c *Control) GenAuthToken(clientID, actorIdentifier string, dat interface{}
 		AuthorizeData: aud,
 		Client:        cl,
 		RedirectUri:   cl.GetRedirectUri(),
-		Scope:         "scope",
+		Scope:         scope,
 		Authorized:    true,
-		Expiration:    86400,
+		Expiration:    int32(expires.Seconds()),
 	}
 
 	ad := &osin.AccessData{
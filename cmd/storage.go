@@ -0,0 +1,677 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	fedbox "github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal/config"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/handlers"
+	"github.com/go-ap/storage"
+	"github.com/openshift/osin"
+	"gopkg.in/urfave/cli.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var StorageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "Storage backends helper",
+	Subcommands: []*cli.Command{
+		storageMigrateCmd,
+	},
+}
+
+var storageMigrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "Copies all the data from one storage backend to another (needs a build with all the storage backends)",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "the storage backend to copy from (default: the configured storage)",
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    fmt.Sprintf("the storage backend to copy to. Possible values: %q", []config.StorageType{config.StorageBoltDB, config.StorageBadger, config.StorageFS, config.StorageSqlite}),
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "to-path",
+			Usage: "the storage path for the destination backend (default: the configured storage path)",
+		},
+		&cli.BoolFlag{
+			Name:  "restart",
+			Usage: "ignore the saved progress of a previous run and start over",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-only",
+			Usage: "only run the verification pass",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-tokens",
+			Usage: "migrate without the OAuth2 authorization codes and access tokens, when the source OAuth2 storage can not list them (the users will need to log in again)",
+		},
+	},
+	Action: storageMigrateAct(&ctl),
+}
+
+// migrateState holds the steps of a storage migration which have been completed,
+// so an interrupted migration can be resumed
+type migrateState struct {
+	path string
+	From config.StorageType   `json:"from"`
+	To   config.StorageType   `json:"to"`
+	Done map[string]time.Time `json:"done"`
+}
+
+func loadMigrateState(p string, from, to config.StorageType) (*migrateState, error) {
+	st := migrateState{path: p, From: from, To: to, Done: make(map[string]time.Time)}
+	raw, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &st, nil
+		}
+		return nil, errors.Annotatef(err, "unable to read migration state %s", p)
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse migration state %s", p)
+	}
+	if st.Done == nil {
+		st.Done = make(map[string]time.Time)
+	}
+	return &st, nil
+}
+
+func (m *migrateState) isDone(step string) bool {
+	_, ok := m.Done[step]
+	return ok
+}
+
+func (m *migrateState) done(step string) error {
+	m.Done[step] = time.Now().UTC()
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.path, raw, 0600)
+}
+
+// progress prints to stderr how far a step of the migration got
+type progress struct {
+	step    string
+	total   int
+	copied  int
+	skipped int
+	failed  int
+}
+
+func (p *progress) print() {
+	fmt.Fprintf(os.Stderr, "\r%s: %d/%d (copied %d, skipped %d, failed %d)", p.step, p.copied+p.skipped+p.failed, p.total, p.copied, p.skipped, p.failed)
+}
+
+func (p *progress) end() {
+	p.print()
+	fmt.Fprintln(os.Stderr)
+}
+
+type backend struct {
+	typ   config.StorageType
+	st    storage.Store
+	oauth osin.Storage
+}
+
+// close closes the storage and the OAuth2 storage of the backend
+func (b backend) close() {
+	if c, ok := b.st.(interface{ Close() error }); ok {
+		c.Close()
+	}
+	if b.oauth != nil {
+		b.oauth.Close()
+	}
+}
+
+type storageMigration struct {
+	from    backend
+	to      backend
+	baseIRI pub.IRI
+	service pub.IRI
+	state   *migrateState
+
+	// skipTokens allows migrating without the authorization codes and the access tokens,
+	// when the source OAuth2 storage can't list them
+	skipTokens bool
+}
+
+func storageMigrateAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		from := backend{typ: c.Conf.Storage, st: c.Storage, oauth: c.AuthStorage}
+		if typ := config.StorageType(ctx.String("from")); typ != "" && typ != from.typ {
+			fromConf := c.Conf
+			fromConf.Storage = typ
+			db, aDb, err := fedbox.Storage(fromConf, logger)
+			if err != nil {
+				return errors.Annotatef(err, "unable to open %s storage", typ)
+			}
+			from = backend{typ: typ, st: db, oauth: aDb}
+			defer from.close()
+		}
+
+		toConf := c.Conf
+		toConf.Storage = config.StorageType(ctx.String("to"))
+		if p := ctx.String("to-path"); p != "" {
+			toConf.StoragePath = p
+		}
+		if toConf.Storage == from.typ && toConf.BaseStoragePath() == c.Conf.BaseStoragePath() {
+			return errors.Newf("source and destination storage are the same: %s %s", from.typ, toConf.BaseStoragePath())
+		}
+
+		statePath := filepath.Join(toConf.BaseStoragePath(), fmt.Sprintf(".migrate-%s-%s.json", from.typ, toConf.Storage))
+		if ctx.Bool("restart") {
+			os.Remove(statePath)
+		}
+		state, err := loadMigrateState(statePath, from.typ, toConf.Storage)
+		if err != nil {
+			return err
+		}
+		if !ctx.Bool("skip-tokens") {
+			_, okAuth := from.oauth.(fedbox.AuthorizeLister)
+			_, okAccess := from.oauth.(fedbox.AccessLister)
+			if !okAuth || !okAccess {
+				return errors.NotImplementedf("%s OAuth2 storage can not list the authorization codes and the access tokens, so they can not be migrated; use --skip-tokens to migrate without them (the users will need to log in again)", from.typ)
+			}
+		}
+		if len(state.Done) > 0 {
+			fmt.Fprintf(os.Stderr, "Resuming migration from %s to %s, using %s\n", from.typ, toConf.Storage, statePath)
+		}
+		// NOTE(marius): a previous run can fail after bootstrapping the destination, but before completing the
+		// first step, and bootstrapping it again would fail, or clean it for some of the storage types
+		if !ctx.Bool("verify-only") && !bootstrapped(toConf) {
+			if err := bootstrapFn(toConf); err != nil {
+				return errors.Annotatef(err, "unable to bootstrap %s storage at %s", toConf.Storage, toConf.BaseStoragePath())
+			}
+		}
+
+		db, aDb, err := fedbox.Storage(toConf, logger)
+		if err != nil {
+			return errors.Annotatef(err, "unable to open %s storage", toConf.Storage)
+		}
+		m := storageMigration{
+			from:    from,
+			to:      backend{typ: toConf.Storage, st: db, oauth: aDb},
+			baseIRI: pub.IRI(c.Conf.BaseURL),
+			service: ap.DefaultServiceIRI(c.Conf.BaseURL),
+			state:   state,
+
+			skipTokens: ctx.Bool("skip-tokens"),
+		}
+		defer m.to.close()
+		if !ctx.Bool("verify-only") {
+			if err := m.run(); err != nil {
+				return err
+			}
+		}
+		if err := m.verify(); err != nil {
+			return err
+		}
+		if !ctx.Bool("verify-only") {
+			os.Remove(statePath)
+		}
+		fmt.Printf("Migration from %s to %s finished successfully\n", from.typ, toConf.Storage)
+		return nil
+	}
+}
+
+var topLevelCollections = handlers.CollectionTypes{ap.ActorsType, ap.ObjectsType, ap.ActivitiesType}
+
+// bootstrapped returns true if the storage has all the top level collections
+func bootstrapped(conf config.Options) bool {
+	db, aDb, err := fedbox.Storage(conf, logger)
+	if err != nil {
+		return false
+	}
+	defer backend{st: db, oauth: aDb}.close()
+	for _, typ := range topLevelCollections {
+		if it, err := db.Load(handlers.IRIf(pub.IRI(conf.BaseURL), typ)); err != nil || pub.IsNil(it) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m storageMigration) run() error {
+	steps := []struct {
+		name string
+		fn   func() (*progress, error)
+	}{
+		{name: "service", fn: m.copyService},
+		{name: "items:" + string(ap.ActorsType), fn: m.copyItems(ap.ActorsType)},
+		{name: "items:" + string(ap.ObjectsType), fn: m.copyItems(ap.ObjectsType)},
+		{name: "items:" + string(ap.ActivitiesType), fn: m.copyItems(ap.ActivitiesType)},
+		{name: "collections", fn: m.copyCollections},
+		{name: "metadata", fn: m.copyMetadata},
+		{name: "oauth:clients", fn: m.copyClients},
+		{name: "oauth:authorize", fn: m.copyAuthorize},
+		{name: "oauth:access", fn: m.copyAccess},
+	}
+	for _, step := range steps {
+		if m.state.isDone(step.name) {
+			fmt.Fprintf(os.Stderr, "%s: already done, skipping\n", step.name)
+			continue
+		}
+		p, err := step.fn()
+		if err != nil {
+			return errors.Annotatef(err, "migration step %s failed", step.name)
+		}
+		if p != nil && p.failed > 0 {
+			return errors.Newf("migration step %s had %d failures, run the command again to retry them", step.name, p.failed)
+		}
+		if err := m.state.done(step.name); err != nil {
+			return errors.Annotatef(err, "unable to save migration state")
+		}
+	}
+	return nil
+}
+
+func loadItems(st storage.Store, iri pub.IRI) (pub.ItemCollection, error) {
+	col := make(pub.ItemCollection, 0)
+	it, err := st.Load(iri)
+	if err != nil {
+		return col, err
+	}
+	if pub.IsNil(it) {
+		return col, nil
+	}
+	if it.IsCollection() {
+		err = pub.OnCollectionIntf(it, func(c pub.CollectionInterface) error {
+			col = append(col, c.Collection()...)
+			return nil
+		})
+		return col, err
+	}
+	return append(col, it), nil
+}
+
+func exists(st storage.Store, iri pub.IRI) bool {
+	col, err := loadItems(st, iri)
+	if err != nil {
+		return false
+	}
+	for _, it := range col {
+		if it.GetLink().Equals(iri, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func iriSet(col pub.ItemCollection) map[pub.IRI]bool {
+	set := make(map[pub.IRI]bool, len(col))
+	for _, it := range col {
+		set[it.GetLink()] = true
+	}
+	return set
+}
+
+func (m storageMigration) copyItem(p *progress, it pub.Item) {
+	if exists(m.to.st, it.GetLink()) {
+		p.skipped++
+		return
+	}
+	if _, err := m.to.st.Save(it); err != nil {
+		Errf("\nUnable to save %s: %s", it.GetLink(), err)
+		p.failed++
+		return
+	}
+	p.copied++
+}
+
+func (m storageMigration) copyService() (*progress, error) {
+	p := &progress{step: "service", total: 1}
+	it, err := loadItems(m.from.st, m.service)
+	if err != nil {
+		return p, err
+	}
+	for _, service := range it {
+		m.copyItem(p, service)
+	}
+	p.end()
+	return p, nil
+}
+
+func (m storageMigration) copyItems(typ handlers.CollectionType) func() (*progress, error) {
+	return func() (*progress, error) {
+		col, err := loadItems(m.from.st, handlers.IRIf(m.baseIRI, typ))
+		if err != nil {
+			return nil, err
+		}
+		p := &progress{step: "items:" + string(typ), total: len(col)}
+		for _, it := range col {
+			m.copyItem(p, it)
+			p.print()
+		}
+		p.end()
+		return p, nil
+	}
+}
+
+// itemCollections returns the IRIs of the collections which belong to an item
+func itemCollections(it pub.Item) pub.IRIs {
+	iris := make(pub.IRIs, 0)
+	add := func(col pub.Item) {
+		if !pub.IsNil(col) {
+			iris = append(iris, col.GetLink())
+		}
+	}
+	pub.OnObject(it, func(o *pub.Object) error {
+		add(o.Replies)
+		add(o.Likes)
+		add(o.Shares)
+		return nil
+	})
+	if pub.ActorTypes.Contains(it.GetType()) {
+		pub.OnActor(it, func(a *pub.Actor) error {
+			add(a.Inbox)
+			add(a.Outbox)
+			add(a.Following)
+			add(a.Followers)
+			add(a.Liked)
+			return nil
+		})
+		iris = append(iris, ap.BlockedType.IRI(it), ap.IgnoredType.IRI(it))
+	}
+	return iris
+}
+
+func (m storageMigration) allCollections() (pub.IRIs, error) {
+	iris := make(pub.IRIs, 0)
+	for _, typ := range topLevelCollections {
+		col, err := loadItems(m.from.st, handlers.IRIf(m.baseIRI, typ))
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range col {
+			iris = append(iris, itemCollections(it)...)
+		}
+	}
+	if service, err := loadItems(m.from.st, m.service); err == nil {
+		for _, it := range service {
+			iris = append(iris, itemCollections(it)...)
+		}
+	}
+	return iris, nil
+}
+
+func (m storageMigration) copyCollections() (*progress, error) {
+	iris, err := m.allCollections()
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{step: "collections", total: len(iris)}
+	for _, iri := range iris {
+		members, err := loadItems(m.from.st, iri)
+		if err != nil || len(members) == 0 {
+			p.skipped++
+			continue
+		}
+		existing, err := loadItems(m.to.st, iri)
+		if err != nil {
+			if _, err := m.to.st.Create(&pub.OrderedCollection{ID: iri, Type: pub.OrderedCollectionType}); err != nil {
+				Errf("\nUnable to create collection %s: %s", iri, err)
+				p.failed++
+				continue
+			}
+		}
+		present := iriSet(existing)
+		failed := false
+		for _, it := range members {
+			if present[it.GetLink()] {
+				continue
+			}
+			if err := m.to.st.AddTo(iri, it.GetLink()); err != nil {
+				Errf("\nUnable to add %s to %s: %s", it.GetLink(), iri, err)
+				failed = true
+			}
+		}
+		if failed {
+			p.failed++
+		} else {
+			p.copied++
+		}
+		p.print()
+	}
+	p.end()
+	return p, nil
+}
+
+func (m storageMigration) copyMetadata() (*progress, error) {
+	fromMeta, ok := m.from.st.(s.MetadataTyper)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "metadata: %s storage has no metadata, skipping\n", m.from.typ)
+		return nil, nil
+	}
+	toMeta, ok := m.to.st.(s.MetadataTyper)
+	if !ok {
+		return nil, errors.NotImplementedf("%s storage can not save metadata", m.to.typ)
+	}
+	actors, err := loadItems(m.from.st, handlers.IRIf(m.baseIRI, ap.ActorsType))
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{step: "metadata", total: len(actors)}
+	for _, it := range actors {
+		meta, err := fromMeta.LoadMetadata(it.GetLink())
+		if err != nil || meta == nil {
+			p.skipped++
+			continue
+		}
+		if err := toMeta.SaveMetadata(*meta, it.GetLink()); err != nil {
+			Errf("\nUnable to save metadata for %s: %s", it.GetLink(), err)
+			p.failed++
+			continue
+		}
+		p.copied++
+		p.print()
+	}
+	p.end()
+	return p, nil
+}
+
+func (m storageMigration) copyClients() (*progress, error) {
+	lister, ok := m.from.oauth.(fedbox.ClientLister)
+	if !ok {
+		return nil, errors.NotImplementedf("%s OAuth2 storage can not list clients", m.from.typ)
+	}
+	saver, ok := m.to.oauth.(fedbox.ClientSaver)
+	if !ok {
+		return nil, errors.NotImplementedf("%s OAuth2 storage can not save clients", m.to.typ)
+	}
+	clients, err := lister.ListClients()
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{step: "oauth:clients", total: len(clients)}
+	for _, cl := range clients {
+		if old, err := m.to.oauth.GetClient(cl.GetId()); err == nil && old != nil {
+			p.skipped++
+			continue
+		}
+		if err := saver.CreateClient(cl); err != nil {
+			Errf("\nUnable to save client %s: %s", cl.GetId(), err)
+			p.failed++
+			continue
+		}
+		p.copied++
+		p.print()
+	}
+	p.end()
+	return p, nil
+}
+
+func (m storageMigration) copyAuthorize() (*progress, error) {
+	lister, ok := m.from.oauth.(fedbox.AuthorizeLister)
+	if !ok {
+		if !m.skipTokens {
+			return nil, errors.NotImplementedf("%s OAuth2 storage can not list the authorization codes, use --skip-tokens to migrate without them", m.from.typ)
+		}
+		fmt.Fprintf(os.Stderr, "oauth:authorize: %s OAuth2 storage can not list authorization codes, skipping\n", m.from.typ)
+		return nil, nil
+	}
+	auths, err := lister.ListAuthorize()
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{step: "oauth:authorize", total: len(auths)}
+	for _, a := range auths {
+		if old, err := m.to.oauth.LoadAuthorize(a.Code); err == nil && old != nil {
+			p.skipped++
+			continue
+		}
+		if err := m.to.oauth.SaveAuthorize(a); err != nil {
+			Errf("\nUnable to save authorization code for client %s: %s", a.Client.GetId(), err)
+			p.failed++
+			continue
+		}
+		p.copied++
+		p.print()
+	}
+	p.end()
+	return p, nil
+}
+
+func (m storageMigration) copyAccess() (*progress, error) {
+	lister, ok := m.from.oauth.(fedbox.AccessLister)
+	if !ok {
+		if !m.skipTokens {
+			return nil, errors.NotImplementedf("%s OAuth2 storage can not list the access tokens, use --skip-tokens to migrate without them", m.from.typ)
+		}
+		fmt.Fprintf(os.Stderr, "oauth:access: %s OAuth2 storage can not list access tokens, skipping (users will need to log in again)\n", m.from.typ)
+		return nil, nil
+	}
+	tokens, err := lister.ListAccess()
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{step: "oauth:access", total: len(tokens)}
+	for _, a := range tokens {
+		if old, err := m.to.oauth.LoadAccess(a.AccessToken); err == nil && old != nil {
+			p.skipped++
+			continue
+		}
+		if err := m.to.oauth.SaveAccess(a); err != nil {
+			Errf("\nUnable to save access token for client %s: %s", a.Client.GetId(), err)
+			p.failed++
+			continue
+		}
+		p.copied++
+		p.print()
+	}
+	p.end()
+	return p, nil
+}
+
+// verify checks that everything in the source storage can be found in the destination
+func (m storageMigration) verify() error {
+	problems := 0
+	missing := func(what string, iri interface{}) {
+		Errf("verify: missing %s %s", what, iri)
+		problems++
+	}
+
+	for _, typ := range topLevelCollections {
+		src, err := loadItems(m.from.st, handlers.IRIf(m.baseIRI, typ))
+		if err != nil {
+			return err
+		}
+		dst, _ := loadItems(m.to.st, handlers.IRIf(m.baseIRI, typ))
+		present := iriSet(dst)
+		for _, it := range src {
+			if !present[it.GetLink()] {
+				missing("item", it.GetLink())
+			}
+		}
+		fmt.Fprintf(os.Stderr, "verify: %s %d in source, %d in destination\n", typ, len(src), len(dst))
+	}
+
+	iris, err := m.allCollections()
+	if err != nil {
+		return err
+	}
+	for _, iri := range iris {
+		src, err := loadItems(m.from.st, iri)
+		if err != nil {
+			continue
+		}
+		dst, _ := loadItems(m.to.st, iri)
+		present := iriSet(dst)
+		for _, it := range src {
+			if !present[it.GetLink()] {
+				missing(fmt.Sprintf("member of %s", iri), it.GetLink())
+			}
+		}
+	}
+
+	fromMeta, okFrom := m.from.st.(s.MetadataTyper)
+	toMeta, okTo := m.to.st.(s.MetadataTyper)
+	if okFrom && okTo {
+		actors, _ := loadItems(m.from.st, handlers.IRIf(m.baseIRI, ap.ActorsType))
+		for _, it := range actors {
+			srcMeta, err := fromMeta.LoadMetadata(it.GetLink())
+			if err != nil || srcMeta == nil {
+				continue
+			}
+			dstMeta, err := toMeta.LoadMetadata(it.GetLink())
+			if err != nil || dstMeta == nil {
+				missing("metadata for", it.GetLink())
+				continue
+			}
+			srcRaw, _ := json.Marshal(srcMeta)
+			dstRaw, _ := json.Marshal(dstMeta)
+			if string(srcRaw) != string(dstRaw) {
+				Errf("verify: metadata for %s differs", it.GetLink())
+				problems++
+			}
+		}
+	}
+
+	if lister, ok := m.from.oauth.(fedbox.ClientLister); ok {
+		clients, _ := lister.ListClients()
+		for _, cl := range clients {
+			if old, err := m.to.oauth.GetClient(cl.GetId()); err != nil || old == nil {
+				missing("OAuth2 client", cl.GetId())
+			}
+		}
+	}
+
+	if lister, ok := m.from.oauth.(fedbox.AuthorizeLister); ok {
+		auths, _ := lister.ListAuthorize()
+		for _, a := range auths {
+			if old, err := m.to.oauth.LoadAuthorize(a.Code); err != nil || old == nil {
+				missing("OAuth2 authorization code for client", a.Client.GetId())
+			}
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "verify: %s OAuth2 storage can not list the authorization codes, they were not checked\n", m.from.typ)
+	}
+
+	if lister, ok := m.from.oauth.(fedbox.AccessLister); ok {
+		tokens, _ := lister.ListAccess()
+		for _, a := range tokens {
+			if old, err := m.to.oauth.LoadAccess(a.AccessToken); err != nil || old == nil {
+				missing("OAuth2 access token for client", a.Client.GetId())
+			}
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "verify: %s OAuth2 storage can not list the access tokens, they were not checked\n", m.from.typ)
+	}
+
+	if problems > 0 {
+		return errors.Newf("verification failed with %d problems", problems)
+	}
+	fmt.Fprintln(os.Stderr, "verify: ok")
+	return nil
+}
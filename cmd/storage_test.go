@@ -0,0 +1,128 @@
+// +build storage_all !storage_pgx,!storage_boltdb,!storage_fs,!storage_badger,!storage_sqlite
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	fedbox "github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/openshift/osin"
+)
+
+func openTestBackend(t *testing.T, conf config.Options) backend {
+	if err := bootstrapFn(conf); err != nil {
+		t.Fatalf("Unable to bootstrap %s storage: %s", conf.Storage, err)
+	}
+	db, aDb, err := fedbox.Storage(conf, logger)
+	if err != nil {
+		t.Fatalf("Unable to open %s storage: %s", conf.Storage, err)
+	}
+	return backend{typ: conf.Storage, st: db, oauth: aDb}
+}
+
+func TestStorageMigration_FsToBoltDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-storage-migrate")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := config.Options{Env: env.TEST, Host: "example.com", BaseURL: "https://example.com"}
+	fromConf := base
+	fromConf.Storage = config.StorageFS
+	fromConf.StoragePath = filepath.Join(dir, "from")
+	toConf := base
+	toConf.Storage = config.StorageBoltDB
+	toConf.StoragePath = filepath.Join(dir, "to")
+
+	from := openTestBackend(t, fromConf)
+	defer from.close()
+
+	baseIRI := pub.IRI(base.BaseURL)
+	actorIRI := ap.ActorsType.IRI(baseIRI).AddPath("1")
+	outbox := actorIRI.AddPath("outbox")
+	actor := &pub.Actor{
+		ID:                actorIRI,
+		Type:              pub.PersonType,
+		PreferredUsername: pub.NaturalLanguageValues{{pub.NilLangRef, pub.Content("jdoe")}},
+		Outbox:            outbox,
+	}
+	object := &pub.Object{ID: ap.ObjectsType.IRI(baseIRI).AddPath("1"), Type: pub.NoteType, AttributedTo: actorIRI}
+	for _, it := range []pub.Item{actor, object} {
+		if _, err := from.st.Save(it); err != nil {
+			t.Fatalf("Unable to save %s: %s", it.GetLink(), err)
+		}
+	}
+	if _, err := from.st.Create(&pub.OrderedCollection{ID: outbox, Type: pub.OrderedCollectionType}); err != nil {
+		t.Fatalf("Unable to create %s: %s", outbox, err)
+	}
+	if err := from.st.AddTo(outbox, object.GetLink()); err != nil {
+		t.Fatalf("Unable to add to %s: %s", outbox, err)
+	}
+	if m, ok := from.st.(s.MetadataTyper); ok {
+		if err := m.SaveMetadata(s.Metadata{Pw: []byte("hash")}, actorIRI); err != nil {
+			t.Fatalf("Unable to save metadata: %s", err)
+		}
+	}
+	client := &osin.DefaultClient{Id: "1", Secret: "secret", RedirectUri: "https://example.com/callback"}
+	if saver, ok := from.oauth.(fedbox.ClientSaver); ok {
+		if err := saver.CreateClient(client); err != nil {
+			t.Fatalf("Unable to save OAuth2 client: %s", err)
+		}
+	}
+
+	to := openTestBackend(t, toConf)
+	defer to.close()
+
+	state, err := loadMigrateState(filepath.Join(dir, "state.json"), fromConf.Storage, toConf.Storage)
+	if err != nil {
+		t.Fatalf("Unable to load migration state: %s", err)
+	}
+	m := storageMigration{
+		from:    from,
+		to:      to,
+		baseIRI: baseIRI,
+		service: ap.DefaultServiceIRI(base.BaseURL),
+		state:   state,
+	}
+
+	_, listsTokens := from.oauth.(fedbox.AccessLister)
+	if !listsTokens {
+		if err := m.run(); err == nil {
+			t.Fatalf("expected the migration to fail when the tokens can not be copied without --skip-tokens")
+		}
+		m.skipTokens = true
+	}
+	if err := m.run(); err != nil {
+		t.Fatalf("Migration failed: %s", err)
+	}
+	if err := m.verify(); err != nil {
+		t.Fatalf("Verification failed: %s", err)
+	}
+
+	for _, iri := range []pub.IRI{actorIRI, object.GetLink()} {
+		if !exists(to.st, iri) {
+			t.Errorf("%s was not copied to the %s storage", iri, toConf.Storage)
+		}
+	}
+	members, err := loadItems(to.st, outbox)
+	if err != nil || !iriSet(members)[object.GetLink()] {
+		t.Errorf("%s was not copied to %s: %v", object.GetLink(), outbox, err)
+	}
+	if cl, err := to.oauth.GetClient(client.Id); err != nil || cl == nil || cl.GetRedirectUri() != client.RedirectUri {
+		t.Errorf("the OAuth2 client was not copied: %v, %v", cl, err)
+	}
+
+	// running it again resumes from the saved state and copies nothing
+	if err := m.run(); err != nil {
+		t.Errorf("Second migration run failed: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/app"
+	"github.com/go-ap/fedbox/internal/webhooks"
+	"github.com/go-ap/jsonld"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var WebhookCmd = &cli.Command{
+	Name:  "webhook",
+	Usage: "Webhooks helper",
+	Description: "The webhooks receive the activities processed by FedBOX which match their filters, in POST requests " +
+		"signed with their secret. The failed requests are retried with an exponential backoff.",
+	Subcommands: []*cli.Command{
+		webhookAddCmd,
+		webhookListCmd,
+		webhookRemoveCmd,
+		webhookTestCmd,
+	},
+}
+
+var webhookAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "Adds a webhook",
+	ArgsUsage: "URL",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "secret",
+			Usage: "The secret used for signing the requests (default: a random one)",
+		},
+		&cli.StringFlag{
+			Name:  "filter",
+			Usage: "The filters for the activities, as a query string, for example: type=Create&object.type=Note",
+		},
+	},
+	Action: webhookAddAct(&ctl),
+}
+
+var webhookListCmd = &cli.Command{
+	Name:   "ls",
+	Usage:  "Lists the webhooks",
+	Action: webhookListAct(&ctl),
+}
+
+var webhookRemoveCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "Removes webhooks, and their pending deliveries",
+	ArgsUsage: "ID...",
+	Action:    webhookRemoveAct(&ctl),
+}
+
+var webhookTestCmd = &cli.Command{
+	Name:      "test",
+	Usage:     "Sends a test activity to a webhook",
+	ArgsUsage: "ID",
+	Action:    webhookTestAct(&ctl),
+}
+
+func (c *Control) webhooks() *webhooks.Store {
+	return webhooks.New(c.Conf.WebhooksPath())
+}
+
+func webhookAddAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		u := ctx.Args().First()
+		if u == "" {
+			return errors.Newf("Missing webhook URL")
+		}
+		h := webhooks.Hook{URL: u, Secret: ctx.String("secret"), Filter: ctx.String("filter")}
+		if _, err := app.WebhookFilters(pub.IRI(c.Conf.BaseURL), h); err != nil {
+			return errors.NewNotValid(err, "invalid filter %s", h.Filter)
+		}
+		h, err := c.webhooks().Add(h)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added webhook %s\n", h.ID)
+		fmt.Printf("\tSecret: %s\n", h.Secret)
+		return nil
+	}
+}
+
+func webhookListAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		st := c.webhooks()
+		hooks, err := st.Hooks()
+		if err != nil {
+			return err
+		}
+		pending, err := st.Pending()
+		if err != nil {
+			return err
+		}
+		for _, h := range hooks {
+			count := 0
+			for _, d := range pending {
+				if d.Hook == h.ID {
+					count++
+				}
+			}
+			fmt.Printf("%s %s", h.ID, h.URL)
+			if len(h.Filter) > 0 {
+				fmt.Printf(" ?%s", h.Filter)
+			}
+			fmt.Printf(" // created %s, %d pending\n", h.Created.Format(time.Stamp), count)
+		}
+		return nil
+	}
+}
+
+func webhookRemoveAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.Newf("Missing webhook ID")
+		}
+		st := c.webhooks()
+		for _, id := range ctx.Args().Slice() {
+			if err := st.Remove(id); err != nil {
+				Errf("Unable to remove webhook %s: %s", id, err)
+				continue
+			}
+			fmt.Printf("Removed webhook %s\n", id)
+		}
+		return nil
+	}
+}
+
+func webhookTestAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		st := c.webhooks()
+		h, err := st.Hook(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		baseIRI := pub.IRI(c.Conf.BaseURL)
+		test := &pub.Create{
+			Type:  pub.CreateType,
+			Actor: baseIRI,
+			Object: &pub.Object{
+				Type:    pub.NoteType,
+				Content: pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: pub.Content("This is a test of the FedBOX webhook")}},
+			},
+			To:        pub.ItemCollection{pub.PublicNS},
+			Published: time.Now().UTC(),
+		}
+		payload, err := jsonld.Marshal(test)
+		if err != nil {
+			return err
+		}
+		d := webhooks.NewDispatcher(st, nil)
+		status, err := d.Send(h, webhooks.Delivery{ID: "test", Hook: h.ID, Event: "test", Payload: payload})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("The webhook %s responded with %d\n", h.URL, status)
+		return nil
+	}
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/metrics"
 	h "github.com/go-ap/handlers"
 	"path"
 	"sync"
@@ -25,24 +26,36 @@ func New(enabled bool, ) *store {
 	return &store{enabled: enabled, c: make(iriMap)}
 }
 
+// SetEnabled enables or disables the cache. Disabling it also empties it.
+func (r *store) SetEnabled(enabled bool) {
+	r.w.Lock()
+	defer r.w.Unlock()
+	r.enabled = enabled
+	if !enabled {
+		r.c = make(iriMap)
+	}
+}
+
 func (r *store) Get(iri pub.IRI) pub.Item {
+	r.w.RLock()
+	defer r.w.RUnlock()
 	if !r.enabled {
 		return nil
 	}
-	r.w.RLock()
-	defer r.w.RUnlock()
 	if it, ok := r.c[iri]; ok {
+		metrics.CacheRequests.Inc("hit")
 		return it
 	}
+	metrics.CacheRequests.Inc("miss")
 	return nil
 }
 
 func (r *store) Set(iri pub.IRI, it pub.Item) {
+	r.w.Lock()
+	defer r.w.Unlock()
 	if !r.enabled {
 		return
 	}
-	r.w.Lock()
-	defer r.w.Unlock()
 	if r.c == nil {
 		r.c = make(map[pub.IRI]pub.Item)
 	}
@@ -50,9 +63,6 @@ func (r *store) Set(iri pub.IRI, it pub.Item) {
 }
 
 func (r *store) Remove(iris ...pub.IRI) bool {
-	if !r.enabled {
-		return true
-	}
 	toInvalidate := pub.IRIs(iris)
 	for _, iri := range iris {
 		if h.ValidCollectionIRI(iri) {
@@ -65,6 +75,9 @@ func (r *store) Remove(iris ...pub.IRI) bool {
 	}
 	r.w.Lock()
 	defer r.w.Unlock()
+	if !r.enabled {
+		return true
+	}
 	for _, iri := range toInvalidate {
 		for key := range r.c {
 			// TODO(marius): I need to play around with this a bit
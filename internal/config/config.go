@@ -5,6 +5,7 @@ import (
 	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/env"
 	"github.com/go-ap/fedbox/internal/log"
+	"github.com/go-ap/fedbox/internal/ratelimit"
 	"github.com/joho/godotenv"
 	"os"
 	"path"
@@ -37,35 +38,136 @@ type Options struct {
 	BaseURL     string
 	Storage     StorageType
 	StoragePath string
+	// MaxUploadSize is the maximum size, in bytes, of the files uploaded to the uploadMedia endpoint
+	MaxUploadSize int64
+	// MediaProxy enables serving the remote media through the local media proxy
+	MediaProxy bool
+	// MediaCacheSize is the maximum size, in bytes, of the cache for the proxied media
+	MediaCacheSize int64
+	// MediaProxyMaxSize is the maximum size, in bytes, of a remote media file the proxy loads
+	MediaProxyMaxSize int64
+	// ThumbnailSizes are the sizes, in pixels, of the thumbnails generated for the uploaded images
+	ThumbnailSizes []int
+	// MaxImagePixels is the maximum number of pixels of the uploaded images, which are decoded in memory
+	MaxImagePixels int64
+	// Metrics enables the /metrics endpoint
+	Metrics bool
+	// MetricsAllow are the networks, in CIDR notation, allowed to access the metrics and the profiling endpoints
+	MetricsAllow []string
+	// MetricsToken is a bearer token which allows access to the metrics and the profiling endpoints from anywhere
+	MetricsToken string
+	// ACME enables the provisioning and the renewal of the TLS certificate of the Host from an ACME directory
+	ACME bool
+	// ACMEDirectory is the URL of the directory of the ACME certificate authority
+	ACMEDirectory string
+	// ACMEEmail is the contact address of the ACME account
+	ACMEEmail string
+	// ACMERootCA is a file with the PEM certificates trusted, besides the system ones,
+	// when connecting to the ACME directory. It's useful for testing against a local directory.
+	ACMERootCA string
+	// ACMEHTTPListen is the address where the ACME HTTP-01 challenges are answered, usually ":80".
+	// When it's missing, only the TLS-ALPN-01 challenges, on the Listen address, are used.
+	ACMEHTTPListen string
+	// TrustedProxies are the networks, in CIDR notation, of the reverse proxies whose X-Forwarded-For
+	// and X-Real-IP headers are used for the addresses of the clients
+	TrustedProxies []string
+	// RateLimitRead is the limit of the GET requests, for each actor, or IP for the anonymous requests
+	RateLimitRead ratelimit.Limit
+	// RateLimitC2S is the limit of the POST requests to the outboxes and to the media upload
+	RateLimitC2S ratelimit.Limit
+	// RateLimitS2S is the limit of the POST requests to the inboxes, for each remote actor
+	RateLimitS2S ratelimit.Limit
+	// RateLimitAuth is the limit of the requests to the OAuth2 endpoints, for each IP
+	RateLimitAuth ratelimit.Limit
+	// RateLimitAuthClient is the limit of the requests to the OAuth2 endpoints, for each client
+	RateLimitAuthClient ratelimit.Limit
+	// RateLimitRegister is the limit of the OAuth2 client registrations, for each IP
+	RateLimitRegister ratelimit.Limit
+	// ClientRegistration is the policy of the OAuth2 dynamic client registration:
+	// closed, open, or token, which requires a token with the admin scope
+	ClientRegistration string
+	// Cache enables the in memory cache of the loaded objects
+	Cache bool
+	// SocketMode are the permissions of the unix sockets the server listens on
+	SocketMode os.FileMode
+	// AdminListen is the address, or the unix socket prefixed with "unix:", where the metrics
+	// and the profiling endpoints are served instead of the public listener
+	AdminListen string
 }
 
 type StorageType string
 
 const (
-	KeyENV          = "ENV"
-	KeyTimeOut      = "TIME_OUT"
-	KeyLogLevel     = "LOG_LEVEL"
-	KeyHostname     = "HOSTNAME"
-	KeyHTTPS        = "HTTPS"
-	KeyCertPath     = "CERT_PATH"
-	KeyKeyPath      = "KEY_PATH"
-	KeyListen       = "LISTEN"
-	KeyDBHost       = "DB_HOST"
-	KeyDBPort       = "DB_PORT"
-	KeyDBName       = "DB_NAME"
-	KeyDBUser       = "DB_USER"
-	KeyDBPw         = "DB_PASSWORD"
-	KeyStorage      = "STORAGE"
-	KeyStoragePath  = "STORAGE_PATH"
-	StorageBoltDB   = StorageType("boltdb")
-	StorageFS       = StorageType("fs")
-	StorageBadger   = StorageType("badger")
-	StoragePostgres = StorageType("postgres")
-	StorageSqlite   = StorageType("sqlite")
+	KeyENV             = "ENV"
+	KeyTimeOut         = "TIME_OUT"
+	KeyLogLevel        = "LOG_LEVEL"
+	KeyHostname        = "HOSTNAME"
+	KeyHTTPS           = "HTTPS"
+	KeyCertPath        = "CERT_PATH"
+	KeyKeyPath         = "KEY_PATH"
+	KeyListen          = "LISTEN"
+	KeySocketMode      = "SOCKET_MODE"
+	KeyDBHost          = "DB_HOST"
+	KeyDBPort          = "DB_PORT"
+	KeyDBName          = "DB_NAME"
+	KeyDBUser          = "DB_USER"
+	KeyDBPw            = "DB_PASSWORD"
+	KeyStorage         = "STORAGE"
+	KeyStoragePath     = "STORAGE_PATH"
+	KeyMaxUpload       = "MAX_UPLOAD_SIZE"
+	KeyMediaProxy      = "MEDIA_PROXY"
+	KeyMediaProxyMax   = "MEDIA_PROXY_MAX_SIZE"
+	KeyMediaCache      = "MEDIA_CACHE_SIZE"
+	KeyThumbnails      = "THUMBNAIL_SIZES"
+	KeyMaxPixels       = "MAX_IMAGE_PIXELS"
+	KeyMetrics         = "METRICS"
+	KeyMetricsAllow    = "METRICS_ALLOW"
+	KeyMetricsToken    = "METRICS_TOKEN"
+	KeyAdminListen     = "ADMIN_LISTEN"
+	KeyCache           = "CACHE"
+	KeyLimitRead       = "RATE_LIMIT_READ"
+	KeyLimitC2S        = "RATE_LIMIT_C2S"
+	KeyLimitS2S        = "RATE_LIMIT_S2S"
+	KeyLimitAuth       = "RATE_LIMIT_AUTH"
+	KeyLimitAuthClient = "RATE_LIMIT_AUTH_CLIENT"
+	KeyLimitRegister   = "RATE_LIMIT_REGISTER"
+	KeyTrustedProxies  = "TRUSTED_PROXIES"
+	KeyRegistration    = "CLIENT_REGISTRATION"
+	KeyACME            = "ACME"
+	KeyACMEDir         = "ACME_DIRECTORY"
+	KeyACMEEmail       = "ACME_EMAIL"
+	KeyACMERootCA      = "ACME_ROOT_CA"
+	KeyACMEHTTP        = "ACME_HTTP_LISTEN"
+	StorageBoltDB      = StorageType("boltdb")
+	StorageFS          = StorageType("fs")
+	StorageBadger      = StorageType("badger")
+	StoragePostgres    = StorageType("postgres")
+	StorageSqlite      = StorageType("sqlite")
+	RegistrationClosed = "closed"
+	RegistrationOpen   = "open"
+	RegistrationToken  = "token"
 )
 
 const defaultPerm = os.ModeDir | os.ModePerm | 0700
 
+const (
+	defaultMaxUploadSize  = 40 << 20
+	defaultMediaCacheSize = 1 << 30
+	defaultProxyMaxSize   = 10 << 20
+	defaultThumbnailSizes = "160,640"
+	defaultMaxImagePixels = 40000000
+	defaultMetricsAllow   = "127.0.0.0/8,::1/128"
+	defaultSocketMode     = "0660"
+	defaultACMEDirectory  = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultLimitRead      = "600/m"
+	defaultLimitC2S       = "60/m"
+	defaultLimitS2S       = "600/m"
+	defaultLimitAuth      = "30/m"
+	defaultLimitRegister  = "10/h"
+	defaultLimitClient    = "300/m"
+	defaultTrustedProxies = "127.0.0.0/8,::1/128"
+)
+
 func (o Options) BaseStoragePath() string {
 	if !filepath.IsAbs(o.StoragePath) {
 		o.StoragePath, _ = filepath.Abs(o.StoragePath)
@@ -85,6 +187,30 @@ func (o Options) BaseStoragePath() string {
 	return basePath
 }
 
+// MediaPath is the folder where the uploaded media files are stored, next to the storage files
+func (o Options) MediaPath() string {
+	p := o.StoragePath
+	if !filepath.IsAbs(p) {
+		p, _ = filepath.Abs(p)
+	}
+	return path.Clean(path.Join(p, string(o.Env), o.Host, "media"))
+}
+
+// MediaCachePath is the folder where the media proxy caches the remote media files
+func (o Options) MediaCachePath() string {
+	return path.Join(path.Dir(o.MediaPath()), "media-cache")
+}
+
+// WebhooksPath is the folder where the webhooks and their delivery queue are stored
+func (o Options) WebhooksPath() string {
+	return path.Join(path.Dir(o.MediaPath()), "webhooks")
+}
+
+// ACMECachePath is the folder where the certificates from the ACME directory are cached
+func (o Options) ACMECachePath() string {
+	return path.Join(o.BaseStoragePath(), "acme")
+}
+
 func (o Options) BoltDBOAuth2() string {
 	return fmt.Sprintf("%s/oauth.bdb", o.BaseStoragePath())
 }
@@ -175,6 +301,9 @@ func LoadFromEnv(e env.Type, timeOut time.Duration) (Options, error) {
 	conf.CertPath = loadKeyFromEnv(KeyCertPath, "")
 
 	conf.Listen = loadKeyFromEnv(KeyListen, "")
+	if mode, err := strconv.ParseUint(loadKeyFromEnv(KeySocketMode, defaultSocketMode), 8, 32); err == nil {
+		conf.SocketMode = os.FileMode(mode)
+	}
 	envStorage := loadKeyFromEnv(KeyStorage, string(StorageFS))
 	conf.Storage = StorageType(strings.ToLower(envStorage))
 	conf.StoragePath = loadKeyFromEnv(KeyStoragePath, "")
@@ -182,6 +311,71 @@ func LoadFromEnv(e env.Type, timeOut time.Duration) (Options, error) {
 		conf.StoragePath = os.TempDir()
 	}
 	conf.StoragePath = path.Clean(conf.StoragePath)
+	conf.MaxUploadSize, _ = strconv.ParseInt(loadKeyFromEnv(KeyMaxUpload, ""), 10, 64)
+	if conf.MaxUploadSize <= 0 {
+		conf.MaxUploadSize = defaultMaxUploadSize
+	}
+	conf.MediaProxy, _ = strconv.ParseBool(loadKeyFromEnv(KeyMediaProxy, "false"))
+	conf.MediaCacheSize, _ = strconv.ParseInt(loadKeyFromEnv(KeyMediaCache, ""), 10, 64)
+	if conf.MediaCacheSize <= 0 {
+		conf.MediaCacheSize = defaultMediaCacheSize
+	}
+	conf.MediaProxyMaxSize, _ = strconv.ParseInt(loadKeyFromEnv(KeyMediaProxyMax, ""), 10, 64)
+	if conf.MediaProxyMaxSize <= 0 {
+		conf.MediaProxyMaxSize = defaultProxyMaxSize
+	}
+	for _, size := range strings.Split(loadKeyFromEnv(KeyThumbnails, defaultThumbnailSizes), ",") {
+		if s, err := strconv.Atoi(strings.TrimSpace(size)); err == nil && s > 0 {
+			conf.ThumbnailSizes = append(conf.ThumbnailSizes, s)
+		}
+	}
+	conf.MaxImagePixels, _ = strconv.ParseInt(loadKeyFromEnv(KeyMaxPixels, ""), 10, 64)
+	if conf.MaxImagePixels <= 0 {
+		conf.MaxImagePixels = defaultMaxImagePixels
+	}
+	conf.Metrics, _ = strconv.ParseBool(loadKeyFromEnv(KeyMetrics, "false"))
+	for _, n := range strings.Split(loadKeyFromEnv(KeyMetricsAllow, defaultMetricsAllow), ",") {
+		if n = strings.TrimSpace(n); len(n) > 0 {
+			conf.MetricsAllow = append(conf.MetricsAllow, n)
+		}
+	}
+	conf.MetricsToken = loadKeyFromEnv(KeyMetricsToken, "")
+	for _, n := range strings.Split(loadKeyFromEnv(KeyTrustedProxies, defaultTrustedProxies), ",") {
+		if n = strings.TrimSpace(n); len(n) > 0 {
+			conf.TrustedProxies = append(conf.TrustedProxies, n)
+		}
+	}
+	conf.AdminListen = loadKeyFromEnv(KeyAdminListen, "")
+	conf.ACME, _ = strconv.ParseBool(loadKeyFromEnv(KeyACME, "false"))
+	conf.ACMEDirectory = loadKeyFromEnv(KeyACMEDir, defaultACMEDirectory)
+	conf.ACMEEmail = loadKeyFromEnv(KeyACMEEmail, "")
+	conf.ACMERootCA = loadKeyFromEnv(KeyACMERootCA, "")
+	conf.ACMEHTTPListen = loadKeyFromEnv(KeyACMEHTTP, "")
+	loadLimit := func(key, def string) ratelimit.Limit {
+		if conf.Env.IsTest() {
+			// NOTE(marius): the tests make a lot of requests from the same address
+			def = "0"
+		}
+		if l, err := ratelimit.ParseLimit(loadKeyFromEnv(key, def)); err == nil {
+			return l
+		}
+		l, _ := ratelimit.ParseLimit(def)
+		return l
+	}
+	conf.RateLimitRead = loadLimit(KeyLimitRead, defaultLimitRead)
+	conf.RateLimitC2S = loadLimit(KeyLimitC2S, defaultLimitC2S)
+	conf.RateLimitS2S = loadLimit(KeyLimitS2S, defaultLimitS2S)
+	conf.RateLimitAuth = loadLimit(KeyLimitAuth, defaultLimitAuth)
+	conf.RateLimitAuthClient = loadLimit(KeyLimitAuthClient, defaultLimitClient)
+	conf.RateLimitRegister = loadLimit(KeyLimitRegister, defaultLimitRegister)
+	switch reg := loadKeyFromEnv(KeyRegistration, RegistrationClosed); reg {
+	case RegistrationOpen, RegistrationToken:
+		conf.ClientRegistration = reg
+	default:
+		conf.ClientRegistration = RegistrationClosed
+	}
+	defaultCache := !(conf.Env.IsTest() || conf.Env.IsDev())
+	conf.Cache, _ = strconv.ParseBool(loadKeyFromEnv(KeyCache, strconv.FormatBool(defaultCache)))
 
 	return conf, nil
 }
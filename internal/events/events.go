@@ -0,0 +1,140 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+// HistorySize is the number of events the Default bus keeps for the subscribers which reconnect
+const HistorySize = 1024
+
+// subscriberBuffer is the number of events which can wait for a subscriber before it gets dropped
+const subscriberBuffer = 64
+
+// Event is published when an item is added to a collection
+type Event struct {
+	ID         uint64
+	Collection pub.IRI
+	Item       pub.IRI
+	Time       time.Time
+}
+
+// Subscription receives the events for one collection on its C channel.
+// The channel is closed when the subscriber can't keep up with the events, or when it unsubscribes.
+type Subscription struct {
+	C   <-chan Event
+	ch  chan Event
+	col pub.IRI
+	bus *Bus
+}
+
+// Close removes the subscription from its bus
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus is an in-process publisher of collection events. It keeps the most recent events,
+// so the subscribers can resume from the last event they received.
+type Bus struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	next    int
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// Default is the bus the storage backends publish to
+var Default = New(HistorySize)
+
+// New returns a bus which keeps the last size events
+func New(size int) *Bus {
+	return &Bus{
+		// NOTE(marius): the IDs start from the current time, so they keep increasing after a restart
+		// and the clients which resume with an older ID don't get events they've already seen
+		seq:     uint64(time.Now().UnixNano()),
+		history: make([]Event, 0, size),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the event for it being added to the col collection to the Default bus
+func Publish(col, it pub.IRI) Event {
+	return Default.Publish(col, it)
+}
+
+// Publish sends the event for it being added to the col collection to the subscribers of the collection.
+// It never blocks: the subscribers which aren't receiving the events are dropped.
+func (b *Bus) Publish(col, it pub.IRI) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Event{ID: b.seq, Collection: col, Item: it, Time: time.Now().UTC()}
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, e)
+	} else if cap(b.history) > 0 {
+		b.history[b.next] = e
+		b.next = (b.next + 1) % cap(b.history)
+	}
+	for s := range b.subs {
+		if !s.col.Equals(col, false) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns a subscription to the events of the col collection, and the events
+// after lastID which are still in the history of the bus.
+func (b *Bus) Subscribe(col pub.IRI, lastID uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	missed := make([]Event, 0)
+	if lastID > 0 {
+		for i := range b.history {
+			e := b.history[(b.next+i)%len(b.history)]
+			if e.ID > lastID && e.Collection.Equals(col, false) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, ch: ch, col: col, bus: b}
+	if b.closed {
+		close(ch)
+		return s, missed
+	}
+	b.subs[s] = struct{}{}
+	return s, missed
+}
+
+// Close ends all the subscriptions, and the ones made after it are already closed.
+// It's used when shutting down, so the streaming clients disconnect.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestBus_Publish(t *testing.T) {
+	b := New(4)
+	inbox := pub.IRI("https://example.com/actors/jdoe/inbox")
+	outbox := pub.IRI("https://example.com/actors/jdoe/outbox")
+
+	s, missed := b.Subscribe(inbox, 0)
+	defer s.Close()
+	if len(missed) != 0 {
+		t.Errorf("expected no missed events for a new subscription, got %d", len(missed))
+	}
+	b.Publish(outbox, "https://example.com/activities/1")
+	e := b.Publish(inbox, "https://example.com/activities/2")
+
+	select {
+	case got := <-s.C:
+		if got.ID != e.ID || got.Item != e.Item {
+			t.Errorf("expected event %d for %s, got %d for %s", e.ID, e.Item, got.ID, got.Item)
+		}
+	default:
+		t.Errorf("expected an event for %s", inbox)
+	}
+	select {
+	case got := <-s.C:
+		t.Errorf("unexpected event for %s", got.Collection)
+	default:
+	}
+}
+
+func TestBus_Subscribe(t *testing.T) {
+	b := New(3)
+	inbox := pub.IRI("https://example.com/actors/jdoe/inbox")
+
+	first := b.Publish(inbox, "https://example.com/activities/1")
+	b.Publish(inbox, "https://example.com/activities/2")
+	b.Publish(inbox, "https://example.com/activities/3")
+	b.Publish(inbox, "https://example.com/activities/4")
+
+	s, missed := b.Subscribe(inbox, first.ID)
+	defer s.Close()
+	if len(missed) != 3 {
+		t.Fatalf("expected 3 missed events, got %d", len(missed))
+	}
+	for i, e := range missed {
+		if i > 0 && e.ID <= missed[i-1].ID {
+			t.Errorf("the missed events are not in order: %d after %d", e.ID, missed[i-1].ID)
+		}
+	}
+	if missed[2].Item != "https://example.com/activities/4" {
+		t.Errorf("expected the last missed event to be for activities/4, got %s", missed[2].Item)
+	}
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	b := New(0)
+	inbox := pub.IRI("https://example.com/actors/jdoe/inbox")
+	s, _ := b.Subscribe(inbox, 0)
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(inbox, "https://example.com/activities/1")
+	}
+	count := 0
+	for range s.C {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("expected %d events before the subscription is closed, got %d", subscriberBuffer, count)
+	}
+	// NOTE(marius): closing a dropped subscription must not panic
+	s.Close()
+}
+
+func TestBus_Close(t *testing.T) {
+	b := New(0)
+	inbox := pub.IRI("https://example.com/actors/jdoe/inbox")
+	s, _ := b.Subscribe(inbox, 0)
+	b.Close()
+	if _, ok := <-s.C; ok {
+		t.Errorf("expected the subscription to be closed")
+	}
+	late, _ := b.Subscribe(inbox, 0)
+	if _, ok := <-late.C; ok {
+		t.Errorf("expected the subscriptions made after closing to be closed")
+	}
+	// NOTE(marius): publishing after closing must not panic
+	b.Publish(inbox, "https://example.com/activities/1")
+	s.Close()
+	late.Close()
+}
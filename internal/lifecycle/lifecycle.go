@@ -0,0 +1,117 @@
+// Package lifecycle starts the subsystems of FedBOX in the order they're registered,
+// and stops them in the reverse order, so each one is stopped before the ones it depends on.
+package lifecycle
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-ap/errors"
+)
+
+// StartFn starts a subsystem. It must not block.
+type StartFn func() error
+
+// StopFn stops a subsystem, waiting for its pending work to finish until ctx is done
+type StopFn func(ctx context.Context) error
+
+// RunFn starts a subsystem, and returns the function which stops it
+type RunFn func() (StopFn, error)
+
+type hook struct {
+	name  string
+	start StartFn
+	stop  StopFn
+}
+
+// Manager keeps the start and stop hooks of the subsystems
+type Manager struct {
+	mu      sync.Mutex
+	hooks   []hook
+	started int
+	stopped bool
+	logFn   func(string, ...interface{})
+}
+
+// New returns an empty manager which logs the steps with logFn
+func New(logFn func(string, ...interface{})) *Manager {
+	if logFn == nil {
+		logFn = func(string, ...interface{}) {}
+	}
+	return &Manager{logFn: logFn}
+}
+
+// Register adds a subsystem. Either of the hooks can be nil.
+func (m *Manager) Register(name string, start StartFn, stop StopFn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, start: start, stop: stop})
+}
+
+// RegisterRun adds a subsystem which is started by run
+func (m *Manager) RegisterRun(name string, run RunFn) {
+	var stop StopFn
+	start := func() error {
+		var err error
+		stop, err = run()
+		return err
+	}
+	m.Register(name, start, func(ctx context.Context) error {
+		if stop == nil {
+			return nil
+		}
+		return stop(ctx)
+	})
+}
+
+// Start runs the start hooks in the order they were registered. If one of them fails,
+// the subsystems started before it are stopped, and its error is returned.
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	hooks := m.hooks[m.started:]
+	m.mu.Unlock()
+
+	for _, h := range hooks {
+		if h.start != nil {
+			if err := h.start(); err != nil {
+				m.Stop(context.Background())
+				return errors.Annotatef(err, "unable to start %s", h.name)
+			}
+		}
+		m.mu.Lock()
+		m.started++
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of the started subsystems in the reverse order they were registered.
+// All of them are run, even when ctx is done, so they can release their resources without waiting.
+// It returns the errors of the hooks which failed. Only the first call has any effect.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	hooks := m.hooks[:m.started]
+	m.mu.Unlock()
+
+	failed := make([]string, 0)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.stop == nil {
+			continue
+		}
+		m.logFn("Stopping %s", h.name)
+		if err := h.stop(ctx); err != nil {
+			failed = append(failed, h.name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Newf("unable to stop cleanly: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
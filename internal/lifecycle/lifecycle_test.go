@@ -0,0 +1,84 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type recorder []string
+
+func (r *recorder) start(name string, err error) StartFn {
+	return func() error {
+		*r = append(*r, "start "+name)
+		return err
+	}
+}
+
+func (r *recorder) stop(name string, err error) StopFn {
+	return func(context.Context) error {
+		*r = append(*r, "stop "+name)
+		return err
+	}
+}
+
+func TestManager(t *testing.T) {
+	steps := recorder{}
+	m := New(nil)
+	m.Register("storage", steps.start("storage", nil), steps.stop("storage", nil))
+	m.Register("workers", nil, steps.stop("workers", nil))
+	m.Register("http", steps.start("http", nil), steps.stop("http", nil))
+	m.RegisterRun("admin", func() (StopFn, error) {
+		steps = append(steps, "start admin")
+		return steps.stop("admin", nil), nil
+	})
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start() error: %s", err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error: %s", err)
+	}
+	want := recorder{"start storage", "start http", "start admin", "stop admin", "stop http", "stop workers", "stop storage"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+	if err := m.Stop(context.Background()); err != nil || len(steps) != len(want) {
+		t.Errorf("the second Stop() shouldn't run the hooks again")
+	}
+}
+
+func TestManager_StartError(t *testing.T) {
+	steps := recorder{}
+	m := New(nil)
+	m.Register("storage", steps.start("storage", nil), steps.stop("storage", nil))
+	m.Register("http", steps.start("http", fmt.Errorf("address in use")), steps.stop("http", nil))
+	m.Register("workers", steps.start("workers", nil), steps.stop("workers", nil))
+
+	if err := m.Start(); err == nil {
+		t.Fatalf("Start() expected an error")
+	}
+	want := recorder{"start storage", "start http", "stop storage"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+}
+
+func TestManager_StopError(t *testing.T) {
+	steps := recorder{}
+	m := New(nil)
+	m.Register("storage", nil, steps.stop("storage", nil))
+	m.Register("http", nil, steps.stop("http", fmt.Errorf("timed out")))
+	m.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Stop(ctx); err == nil {
+		t.Errorf("Stop() expected an error")
+	}
+	want := recorder{"stop http", "stop storage"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+}
@@ -0,0 +1,178 @@
+package media
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// ErrTooLarge is returned when saving to the Cache a file bigger than the allowed size
+var ErrTooLarge = errors.Newf("media file is too large")
+
+// Cache keeps remote media files on disk. When it grows over its maximum size,
+// the least recently used files are removed.
+type Cache struct {
+	path    string
+	maxSize int64
+
+	mu     sync.Mutex
+	size   int64
+	loaded bool
+}
+
+type cacheMeta struct {
+	URL       string       `json:"url"`
+	MediaType pub.MimeType `json:"mediaType"`
+}
+
+// NewCache returns a Cache which keeps the files in path
+func NewCache(path string, maxSize int64) *Cache {
+	return &Cache{path: path, maxSize: maxSize}
+}
+
+func (c *Cache) filePath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(c.path, hex.EncodeToString(sum[:]))
+}
+
+// Key returns the secret key stored in the cache folder, creating it if it doesn't exist
+func (c *Cache) Key() ([]byte, error) {
+	p := filepath.Join(c.path, ".key")
+	if key, err := ioutil.ReadFile(p); err == nil && len(key) > 0 {
+		return key, nil
+	}
+	if err := os.MkdirAll(c.path, 0700); err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(p, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Open returns the cached file for the u URL, and its media type
+func (c *Cache) Open(u string) (*os.File, pub.MimeType, error) {
+	p := c.filePath(u)
+	raw, err := ioutil.ReadFile(p + ".json")
+	if err != nil {
+		return nil, "", errors.NotFoundf("%s is not cached", u)
+	}
+	m := cacheMeta{}
+	if err = json.Unmarshal(raw, &m); err != nil || m.URL != u {
+		return nil, "", errors.NotFoundf("%s is not cached", u)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, "", errors.NotFoundf("%s is not cached", u)
+	}
+	// NOTE(marius): the modification time is used to find the least recently used files
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return f, m.MediaType, nil
+}
+
+// Save caches the contents of r for the u URL. It returns ErrTooLarge if r has more than max bytes.
+func (c *Cache) Save(u string, mt pub.MimeType, r io.Reader, max int64) (*os.File, error) {
+	if err := os.MkdirAll(c.path, 0700); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(c.path, ".fetch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > max {
+		return nil, ErrTooLarge
+	}
+	raw, err := json.Marshal(cacheMeta{URL: u, MediaType: mt})
+	if err != nil {
+		return nil, err
+	}
+	p := c.filePath(u)
+	if err = ioutil.WriteFile(p+".json", raw, 0600); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+	c.added(size)
+	return os.Open(p)
+}
+
+func (c *Cache) added(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		c.size, _ = c.evict(-1)
+		c.loaded = true
+	} else {
+		c.size += size
+	}
+	if c.size > c.maxSize {
+		c.size, _ = c.evict(c.maxSize)
+	}
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used files until the cache is smaller than max, and returns its size.
+// A negative max only computes the size of the cache.
+func (c *Cache) evict(max int64) (int64, error) {
+	files := make([]cacheFile, 0)
+	var total int64
+	err := filepath.Walk(c.path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		files = append(files, cacheFile{path: p, size: fi.Size(), modTime: fi.ModTime()})
+		total += fi.Size()
+		return nil
+	})
+	if err != nil || max < 0 {
+		return total, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= max {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		os.Remove(f.path + ".json")
+		total -= f.size
+	}
+	return total, nil
+}
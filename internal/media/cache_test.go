@@ -0,0 +1,49 @@
+package media
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache_SaveOpenEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-media-cache")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewCache(dir, 10)
+	first, second := "https://example.com/1.png", "https://example.com/2.png"
+	f, err := c.Save(first, "image/png", bytes.NewReader([]byte("012345")), 8)
+	if err != nil {
+		t.Fatalf("Unable to cache %s: %s", first, err)
+	}
+	f.Close()
+	// NOTE(marius): make sure the files have different modification times
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(c.filePath(first), old, old)
+
+	if _, err = c.Save(second, "image/png", bytes.NewReader([]byte("012345678")), 8); err != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge when caching a file over the limit, got %v", err)
+	}
+	f, err = c.Save(second, "image/png", bytes.NewReader([]byte("0123456")), 8)
+	if err != nil {
+		t.Fatalf("Unable to cache %s: %s", second, err)
+	}
+	f.Close()
+
+	if _, _, err = c.Open(first); err == nil {
+		t.Errorf("%s should have been evicted from the cache", first)
+	}
+	f, mt, err := c.Open(second)
+	if err != nil {
+		t.Fatalf("Unable to open %s from the cache: %s", second, err)
+	}
+	defer f.Close()
+	if mt != "image/png" {
+		t.Errorf("Invalid media type %s, expected image/png", mt)
+	}
+}
@@ -0,0 +1,252 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// Image is an uploaded image, re-encoded without its metadata
+type Image struct {
+	Data      []byte
+	MediaType string
+	Width     int
+	Height    int
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail is a smaller version of an uploaded image
+type Thumbnail struct {
+	Data      []byte
+	MediaType string
+	Width     int
+	Height    int
+}
+
+// ErrTooManyPixels is returned by ProcessImage for the images with more pixels than allowed
+var ErrTooManyPixels = errors.Newf("image has too many pixels")
+
+// CanProcess returns true for the media types ProcessImage supports
+func CanProcess(mt string) bool {
+	mt = strings.ToLower(mt)
+	return strings.HasPrefix(mt, "image/jpeg") || strings.HasPrefix(mt, "image/png") || strings.HasPrefix(mt, "image/gif")
+}
+
+// ProcessImage decodes an image and re-encodes it, which strips its EXIF metadata, including the GPS position.
+// The EXIF orientation of JPEG images is applied before stripping it.
+// It generates thumbnails which fit in squares of each of the sizes.
+// The GIF images are kept as they are, so their animations are not lost.
+// The images with more than maxPixels pixels are refused with ErrTooManyPixels, before decoding them.
+func ProcessImage(raw []byte, sizes []int, maxPixels int64) (*Image, error) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if maxPixels > 0 && int64(conf.Width)*int64(conf.Height) > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, format, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	res := Image{MediaType: "image/" + format}
+	switch format {
+	case "jpeg":
+		img = orient(img, jpegOrientation(raw))
+		if res.Data, err = encode(img, format); err != nil {
+			return nil, err
+		}
+	case "png":
+		if res.Data, err = encode(img, format); err != nil {
+			return nil, err
+		}
+	default:
+		res.Data = raw
+	}
+	b := img.Bounds()
+	res.Width, res.Height = b.Dx(), b.Dy()
+
+	thumbFormat := "jpeg"
+	if format == "png" {
+		// NOTE(marius): keep the transparency of PNG images
+		thumbFormat = "png"
+	}
+	for _, size := range sizes {
+		if size <= 0 || (res.Width <= size && res.Height <= size) {
+			continue
+		}
+		w, h := fit(res.Width, res.Height, size)
+		t := Thumbnail{MediaType: "image/" + thumbFormat, Width: w, Height: h}
+		if t.Data, err = encode(resize(img, w, h), thumbFormat); err != nil {
+			return nil, err
+		}
+		res.Thumbnails = append(res.Thumbnails, t)
+	}
+	return &res, nil
+}
+
+func encode(img image.Image, format string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	return buf.Bytes(), err
+}
+
+// fit returns the dimensions of a w x h image scaled down to fit in a size x size square
+func fit(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// resize scales img to w x h, averaging the source pixels that end up in each destination pixel
+func resize(img image.Image, w, h int) *image.NRGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(b.Min.X+sx, b.Min.Y+sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the value of the EXIF orientation tag of a JPEG image, or 1 if it's missing
+func jpegOrientation(raw []byte) int {
+	if len(raw) < 4 || raw[0] != 0xFF || raw[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(raw); {
+		if raw[i] != 0xFF {
+			return 1
+		}
+		marker := raw[i+1]
+		size := int(binary.BigEndian.Uint16(raw[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(raw) {
+			// NOTE(marius): the image data starts, there are no more metadata segments
+			return 1
+		}
+		seg := raw[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			o := int(order.Uint16(tiff[e+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient transforms img so it's displayed as the EXIF orientation says
+func orient(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		// NOTE(marius): orientations 5 to 8 swap the width and the height
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,64 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	img.Set(0, 0, color.NRGBA{B: 255, A: 255})
+	return img
+}
+
+func TestProcessImage(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, testImage(300, 200), nil); err != nil {
+		t.Fatalf("Unable to encode test image: %s", err)
+	}
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	raw := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, byte(len(exif) + 2)}
+	raw = append(append(raw, exif...), buf.Bytes()[2:]...)
+
+	if o := jpegOrientation(raw); o != 6 {
+		t.Errorf("Invalid orientation %d, expected 6", o)
+	}
+	img, err := ProcessImage(raw, []int{100, 1000}, 0)
+	if err != nil {
+		t.Fatalf("Unable to process image: %s", err)
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Errorf("The EXIF metadata was not stripped")
+	}
+	if img.Width != 200 || img.Height != 300 {
+		t.Errorf("Invalid dimensions %dx%d, expected the rotated 200x300", img.Width, img.Height)
+	}
+	if len(img.Thumbnails) != 1 {
+		t.Fatalf("Invalid number of thumbnails %d, expected 1", len(img.Thumbnails))
+	}
+	if th := img.Thumbnails[0]; th.Width != 66 || th.Height != 100 {
+		t.Errorf("Invalid thumbnail dimensions %dx%d, expected 66x100", th.Width, th.Height)
+	}
+}
+
+func TestProcessImage_TooManyPixels(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, testImage(300, 200)); err != nil {
+		t.Fatalf("Unable to encode test image: %s", err)
+	}
+	if _, err := ProcessImage(buf.Bytes(), nil, 300*200-1); err != ErrTooManyPixels {
+		t.Errorf("Expected ErrTooManyPixels for an image over the limit, got %v", err)
+	}
+	if _, err := ProcessImage(buf.Bytes(), nil, 300*200); err != nil {
+		t.Errorf("Unable to process an image at the limit: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package media stores the uploaded media files on disk, addressed by the SHA-256 hash of their content.
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// Blob is a media file saved in the Store
+type Blob struct {
+	Hash      string
+	Size      int64
+	MediaType pub.MimeType
+}
+
+// Store saves the blobs in a directory, sharded by the first bytes of their hash
+type Store struct {
+	path string
+}
+
+// New returns a Store which keeps the blobs in path
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func (s Store) blobPath(hash string) string {
+	return filepath.Join(s.path, hash[0:2], hash[2:4], hash)
+}
+
+// Save writes the contents of r to the store. When a blob with the same content exists, it is reused.
+func (s Store) Save(r io.Reader) (*Blob, error) {
+	if err := os.MkdirAll(s.path, 0700); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(s.path, ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(r, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	sniff = sniff[:n]
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.MultiReader(bytes.NewReader(sniff), r))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to save blob")
+	}
+	if err = tmp.Sync(); err != nil {
+		return nil, err
+	}
+
+	b := Blob{
+		Hash:      hex.EncodeToString(h.Sum(nil)),
+		Size:      size,
+		MediaType: pub.MimeType(http.DetectContentType(sniff)),
+	}
+	p := s.blobPath(b.Hash)
+	if _, err = os.Stat(p); err == nil {
+		return &b, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return nil, errors.Annotatef(err, "unable to save blob %s", b.Hash)
+	}
+	return &b, nil
+}
+
+// Open returns the blob with the hash
+func (s Store) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, errors.NotFoundf("invalid blob %s", hash)
+	}
+	f, err := os.Open(s.blobPath(hash))
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("blob %s not found", hash)
+	}
+	return f, err
+}
+
+// ObjectType returns the ActivityPub object type for a media type
+func ObjectType(mt pub.MimeType) pub.ActivityVocabularyType {
+	switch {
+	case strings.HasPrefix(string(mt), "image/"):
+		return pub.ImageType
+	case strings.HasPrefix(string(mt), "video/"):
+		return pub.VideoType
+	case strings.HasPrefix(string(mt), "audio/"):
+		return pub.AudioType
+	}
+	return pub.DocumentType
+}
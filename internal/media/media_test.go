@@ -0,0 +1,65 @@
+package media
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestStore_SaveOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-media")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(dir)
+	content := []byte("GIF89a some image")
+	b, err := s.Save(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unable to save blob: %s", err)
+	}
+	if b.Size != int64(len(content)) {
+		t.Errorf("Invalid blob size %d, expected %d", b.Size, len(content))
+	}
+	if b.MediaType != "image/gif" {
+		t.Errorf("Invalid blob media type %s, expected %s", b.MediaType, "image/gif")
+	}
+	again, err := s.Save(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unable to save blob again: %s", err)
+	}
+	if again.Hash != b.Hash {
+		t.Errorf("Same content has different hashes %s and %s", b.Hash, again.Hash)
+	}
+
+	f, err := s.Open(b.Hash)
+	if err != nil {
+		t.Fatalf("Unable to open blob %s: %s", b.Hash, err)
+	}
+	defer f.Close()
+	got, _ := ioutil.ReadAll(f)
+	if !bytes.Equal(got, content) {
+		t.Errorf("Invalid blob content %q, expected %q", got, content)
+	}
+	if _, err = s.Open("../../etc/passwd"); err == nil {
+		t.Errorf("Expected error when opening an invalid blob hash")
+	}
+}
+
+func TestObjectType(t *testing.T) {
+	tests := map[pub.MimeType]pub.ActivityVocabularyType{
+		"image/png":       pub.ImageType,
+		"video/mp4":       pub.VideoType,
+		"audio/ogg":       pub.AudioType,
+		"application/pdf": pub.DocumentType,
+	}
+	for mt, want := range tests {
+		if got := ObjectType(mt); got != want {
+			t.Errorf("ObjectType(%s) = %s, want %s", mt, got, want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import "time"
+
+// The metrics of FedBOX
+var (
+	HTTPRequests = NewHistogramVec("fedbox_http_request_duration_seconds",
+		"The duration of the HTTP requests, by route", DefBuckets, "route", "method", "code")
+	StorageOperations = NewHistogramVec("fedbox_storage_operation_duration_seconds",
+		"The duration of the storage operations, by backend and method", DefBuckets, "backend", "operation")
+	CacheRequests = NewCounterVec("fedbox_cache_requests_total",
+		"The lookups in the cache of items and collections, by result", "result")
+	OAuthTokens = NewCounterVec("fedbox_oauth_tokens_issued_total",
+		"The OAuth2 access tokens issued, by grant type", "grant_type")
+)
+
+func init() {
+	NewGaugeFunc("fedbox_cache_hit_ratio", "The ratio of the lookups in the cache which found the item", func() float64 {
+		hits, misses := CacheRequests.Value("hit"), CacheRequests.Value("miss")
+		if hits+misses == 0 {
+			return 0
+		}
+		return hits / (hits + misses)
+	})
+}
+
+// StorageTimer returns a function which observes the duration of a storage operation, for deferring it:
+//
+//	defer metrics.StorageTimer("boltdb", "Load")()
+func StorageTimer(backend, op string) func() {
+	start := time.Now()
+	return func() {
+		StorageOperations.Since(start, backend, op)
+	}
+}
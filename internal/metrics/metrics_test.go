@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	c := &CounterVec{n: "test_total", help: "Test counter", labels: []string{"result"}, values: map[string]float64{}, series: map[string][]string{}}
+	h := &HistogramVec{n: "test_seconds", help: "Test histogram", labels: []string{"route"}, buckets: []float64{0.1, 1}, series: map[string]*histogramSeries{}}
+	g := &GaugeFunc{n: "test_gauge", help: "Test gauge", fn: func() float64 { return 0.5 }}
+	r.register(c)
+	r.register(h)
+	r.register(g)
+
+	c.Inc("hit")
+	c.Inc("hit")
+	c.Inc(`mi"ss`)
+	h.Observe(0.05, "/{collection}")
+	h.Observe(0.5, "/{collection}")
+	h.Observe(5, "/{collection}")
+
+	buf := bytes.Buffer{}
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_gauge Test gauge
+# TYPE test_gauge gauge
+test_gauge 0.5
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/{collection}",le="0.1"} 1
+test_seconds_bucket{route="/{collection}",le="1"} 2
+test_seconds_bucket{route="/{collection}",le="+Inf"} 3
+test_seconds_sum{route="/{collection}"} 5.55
+test_seconds_count{route="/{collection}"} 3
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total{result="hit"} 2
+test_total{result="mi\"ss"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStorageTimer(t *testing.T) {
+	StorageTimer("test", "Load")()
+	buf := bytes.Buffer{}
+	StorageOperations.write(&buf)
+	if !strings.Contains(buf.String(), `fedbox_storage_operation_duration_seconds_count{backend="test",operation="Load"} 1`) {
+		t.Errorf("expected the storage operation to be observed, got\n%s", buf.String())
+	}
+}
@@ -0,0 +1,149 @@
+// Package ratelimit limits the rate of the requests with token buckets, one for each key.
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// cleanupInterval is how often the buckets which are full again are removed
+const cleanupInterval = time.Minute
+
+// MaxKeys is the maximum number of buckets a limiter keeps
+const MaxKeys = 100000
+
+// Limit is the rate at which the tokens of a bucket are refilled, and the size of the bucket
+type Limit struct {
+	// Rate is the number of tokens added each second
+	Rate float64
+	// Burst is the maximum number of tokens
+	Burst int
+}
+
+// Unlimited is the limit which allows all the requests
+var Unlimited = Limit{}
+
+// IsUnlimited returns true for the limits which allow all the requests
+func (l Limit) IsUnlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// ParseLimit parses the limits in the "count/period" format, where the period is one of "s", "m", "h",
+// or a duration like "10m". The count is also the burst. "0" or an empty string mean no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Unlimited, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 0 {
+		return Unlimited, errors.NotValidf("invalid rate limit %q", s)
+	}
+	period := time.Second
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "s":
+			period = time.Second
+		case "m":
+			period = time.Minute
+		case "h":
+			period = time.Hour
+		default:
+			if period, err = time.ParseDuration(parts[1]); err != nil || period <= 0 {
+				return Unlimited, errors.NotValidf("invalid rate limit period %q", parts[1])
+			}
+		}
+	}
+	return Limit{Rate: float64(count) / period.Seconds(), Burst: count}, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps the token buckets of the keys
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	maxKeys int
+	cleaned time.Time
+	now     func() time.Time
+}
+
+// New returns a limiter with the limit for each key
+func New(l Limit) *Limiter {
+	return &Limiter{limit: l, buckets: make(map[string]*bucket), maxKeys: MaxKeys, now: time.Now}
+}
+
+// Allow takes a token from the bucket of key. When there's none left, it returns false,
+// and the time until the next one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit.IsUnlimited() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.cleaned) > cleanupInterval {
+		l.cleanup(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxKeys {
+			l.evict()
+		}
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup removes the buckets which would be full, as they're the same as new ones
+func (l *Limiter) cleanup(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+	l.cleaned = now
+}
+
+// evictSamples is how many buckets are compared when one needs to be evicted
+const evictSamples = 8
+
+// evict makes room for a new bucket when there are too many keys, like when they're made up.
+// It removes the least recently used of a few buckets, which the iteration over the map picks at random,
+// so it doesn't need to go through all of them.
+func (l *Limiter) evict() {
+	var (
+		oldest string
+		last   time.Time
+		n      int
+	)
+	for k, b := range l.buckets {
+		if n == 0 || b.last.Before(last) {
+			oldest, last = k, b.last
+		}
+		if n++; n == evictSamples {
+			break
+		}
+	}
+	delete(l.buckets, oldest)
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "", want: Unlimited},
+		{in: "0", want: Unlimited},
+		{in: "10", want: Limit{Rate: 10, Burst: 10}},
+		{in: "60/m", want: Limit{Rate: 1, Burst: 60}},
+		{in: "3600/h", want: Limit{Rate: 1, Burst: 3600}},
+		{in: "30/30s", want: Limit{Rate: 1, Burst: 30}},
+		{in: "ten/m", wantErr: true},
+		{in: "10/week", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLimit(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	l := New(Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected the request %d to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatalf("expected the request over the burst to be limited")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s for the next token, got %s", wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Errorf("expected the other keys not to be limited")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Errorf("expected the request to be allowed after the bucket refilled")
+	}
+
+	now = now.Add(2 * cleanupInterval)
+	l.Allow("c")
+	if len(l.buckets) != 1 {
+		t.Errorf("expected the full buckets to be removed, got %d buckets", len(l.buckets))
+	}
+
+	if ok, _ := New(Unlimited).Allow("a"); !ok {
+		t.Errorf("expected the unlimited limiter to allow all the requests")
+	}
+}
+
+func TestLimiter_MaxKeys(t *testing.T) {
+	l := New(Limit{Rate: 1, Burst: 1})
+	l.maxKeys = 10
+	for i := 0; i < 100; i++ {
+		l.Allow(strconv.Itoa(i))
+	}
+	if len(l.buckets) > l.maxKeys {
+		t.Errorf("expected at most %d buckets, got %d", l.maxKeys, len(l.buckets))
+	}
+	if ok, _ := l.Allow("99"); ok {
+		t.Errorf("expected the bucket of the latest key to be kept")
+	}
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 secret of the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// NOTE(marius): the RFC vectors have 8 digits, these are their last 6
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %s", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, now)
+
+	c, ok := Validate(rfcSecret, code, now, 0)
+	if !ok || c != Counter(now) {
+		t.Fatalf("expected the current code to be valid")
+	}
+	if _, ok := Validate(rfcSecret, code, now, c); ok {
+		t.Errorf("expected the code not to be valid a second time")
+	}
+	if _, ok := Validate(rfcSecret, code, now.Add(Period), 0); !ok {
+		t.Errorf("expected the code of the previous step to be valid")
+	}
+	if _, ok := Validate(rfcSecret, code, now.Add(3*Period), 0); ok {
+		t.Errorf("expected the code to be invalid after %d steps", Skew)
+	}
+	if _, ok := Validate(rfcSecret, "12345", now, 0); ok {
+		t.Errorf("expected the short code to be invalid")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	s, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %s", err)
+	}
+	if _, err := Code(s, time.Now()); err != nil {
+		t.Errorf("expected the generated secret to be valid, got %s", err)
+	}
+	uri := URI("fedbox.git", "jdoe", s)
+	if !strings.HasPrefix(uri, "otpauth://totp/fedbox.git:jdoe?") || !strings.Contains(uri, "secret="+s) {
+		t.Errorf("invalid URI %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("RecoveryCodes() error = %s", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if !IsRecoveryCode(c) || len(c) != 17 {
+			t.Errorf("invalid recovery code %q", c)
+		}
+		h := HashRecoveryCode(c)
+		if seen[h] {
+			t.Errorf("duplicate recovery code %q", c)
+		}
+		seen[h] = true
+		if HashRecoveryCode(" "+strings.ToUpper(c)+" ") != h {
+			t.Errorf("expected the hash to ignore the case and the spaces of %q", c)
+		}
+	}
+}
@@ -1,147 +1,260 @@
 package internal
 
-import pub "github.com/go-ap/activitypub"
+import (
+	"strings"
 
-func replaceHostInIRI(iri *pub.IRI, repl pub.IRI) *pub.IRI {
-	if iri.Contains(repl, false) { return iri }
+	pub "github.com/go-ap/activitypub"
+)
 
-	u, eu := iri.URL()
-	if eu != nil { return iri }
+// ReplaceFn returns the IRI which replaces iri
+type ReplaceFn func(iri pub.IRI) pub.IRI
 
-	ru, er := repl.URL()
-	if er != nil { return iri }
+// ReplaceHostInItem rewrites the IRIs of the item, and of all the items it contains,
+// which are under the from IRI so they are under the to IRI.
+// The other IRIs, like the ones of remote actors or of the public namespace, are left unchanged.
+func ReplaceHostInItem(it pub.Item, from, to pub.IRI) pub.Item {
+	if len(from) == 0 || len(to) == 0 || from.Equals(to, false) {
+		return it
+	}
+	return replaceHostInItem(it, HostReplacer(from, to))
+}
+
+// ReplaceIRIsInItem rewrites all the IRIs of the item, and of all the items it contains, using fn
+func ReplaceIRIsInItem(it pub.Item, fn ReplaceFn) pub.Item {
+	return replaceHostInItem(it, fn)
+}
+
+// HostReplacer returns a ReplaceFn which moves the IRIs under the from IRI under the to IRI
+func HostReplacer(from, to pub.IRI) ReplaceFn {
+	fu, ef := from.URL()
+	tu, et := to.URL()
+	return func(iri pub.IRI) pub.IRI {
+		if ef != nil || et != nil || len(iri) == 0 {
+			return iri
+		}
+		u, err := iri.URL()
+		if err != nil {
+			return iri
+		}
+		if !strings.EqualFold(u.Host, fu.Host) || !strings.EqualFold(u.Scheme, fu.Scheme) {
+			return iri
+		}
+		fromPath := strings.TrimRight(fu.Path, "/")
+		if u.Path != fromPath && !strings.HasPrefix(u.Path, fromPath+"/") {
+			return iri
+		}
+		u.Scheme = tu.Scheme
+		u.Host = tu.Host
+		u.Path = strings.TrimRight(tu.Path, "/") + strings.TrimPrefix(u.Path, fromPath)
+		u.RawPath = ""
+		return pub.IRI(u.String())
+	}
+}
 
-	u.Scheme = ru.Scheme
-	u.Host = ru.Host
-	*iri = pub.IRI(u.String())
-	return iri
+func replaceIRI(iri *pub.IRI, fn ReplaceFn) {
+	if iri == nil || len(*iri) == 0 {
+		return
+	}
+	*iri = fn(*iri)
 }
 
-func replaceHostInActor(a *pub.Actor, repl pub.IRI) {
+func replaceHostInActor(a *pub.Actor, fn ReplaceFn) {
 	pub.OnObject(a, func(o *pub.Object) error {
-		replaceHostInObject(o, repl)
+		replaceHostInObject(o, fn)
 		return nil
 	})
+	a.Inbox = replaceHostInItem(a.Inbox, fn)
+	a.Outbox = replaceHostInItem(a.Outbox, fn)
+	a.Following = replaceHostInItem(a.Following, fn)
+	a.Followers = replaceHostInItem(a.Followers, fn)
+	a.Liked = replaceHostInItem(a.Liked, fn)
+	replaceIRI(&a.PublicKey.ID, fn)
+	replaceIRI(&a.PublicKey.Owner, fn)
+	if e := a.Endpoints; e != nil {
+		e.UploadMedia = replaceHostInItem(e.UploadMedia, fn)
+		e.OauthAuthorizationEndpoint = replaceHostInItem(e.OauthAuthorizationEndpoint, fn)
+		e.OauthTokenEndpoint = replaceHostInItem(e.OauthTokenEndpoint, fn)
+		e.ProvideClientKey = replaceHostInItem(e.ProvideClientKey, fn)
+		e.SignClientKey = replaceHostInItem(e.SignClientKey, fn)
+		e.SharedInbox = replaceHostInItem(e.SharedInbox, fn)
+	}
 }
 
-func replaceHostInObject(o *pub.Object, repl pub.IRI) {
-	replaceHostInIRI(&o.ID, repl)
-	replaceHostInItem(o.AttributedTo, repl)
-	replaceHostInItem(o.Attachment, repl)
-	replaceHostInItem(o.Audience, repl)
-	replaceHostInItem(o.Context, repl)
-	replaceHostInItem(o.Generator, repl)
-	replaceHostInItem(o.Icon, repl)
-	replaceHostInItem(o.Image, repl)
-	replaceHostInItem(o.InReplyTo, repl)
-	replaceHostInItem(o.Location, repl)
-	replaceHostInItem(o.Preview, repl)
-	replaceHostInItem(o.Replies, repl)
-	replaceHostInItem(o.Tag, repl)
-	replaceHostInItem(o.To, repl)
-	replaceHostInItem(o.Bto, repl)
-	replaceHostInItem(o.CC, repl)
-	replaceHostInItem(o.BCC, repl)
-	replaceHostInItem(o.Likes, repl)
-	replaceHostInItem(o.Shares, repl)
+func replaceHostInObject(o *pub.Object, fn ReplaceFn) {
+	replaceIRI(&o.ID, fn)
+	o.AttributedTo = replaceHostInItem(o.AttributedTo, fn)
+	o.Attachment = replaceHostInItem(o.Attachment, fn)
+	// NOTE(marius): the audience and the tags are collections, so their items get replaced in place
+	replaceHostInItem(o.Audience, fn)
+	o.Context = replaceHostInItem(o.Context, fn)
+	o.Generator = replaceHostInItem(o.Generator, fn)
+	o.Icon = replaceHostInItem(o.Icon, fn)
+	o.Image = replaceHostInItem(o.Image, fn)
+	o.InReplyTo = replaceHostInItem(o.InReplyTo, fn)
+	o.Location = replaceHostInItem(o.Location, fn)
+	o.Preview = replaceHostInItem(o.Preview, fn)
+	o.Replies = replaceHostInItem(o.Replies, fn)
+	replaceHostInItem(o.Tag, fn)
+	if u, ok := o.URL.(pub.IRI); ok {
+		o.URL = fn(u)
+	}
+	o.To = replaceHostInItemCollection(o.To, fn)
+	o.Bto = replaceHostInItemCollection(o.Bto, fn)
+	o.CC = replaceHostInItemCollection(o.CC, fn)
+	o.BCC = replaceHostInItemCollection(o.BCC, fn)
+	o.Likes = replaceHostInItem(o.Likes, fn)
+	o.Shares = replaceHostInItem(o.Shares, fn)
 }
 
-func replaceHostInActivity(o *pub.Activity, repl pub.IRI) {
+func replaceHostInActivity(o *pub.Activity, fn ReplaceFn) {
 	pub.OnIntransitiveActivity(o, func(o *pub.IntransitiveActivity) error {
-		replaceHostInIntransitiveActivity(o, repl)
+		replaceHostInIntransitiveActivity(o, fn)
 		return nil
 	})
-	replaceHostInItem(o.Object, repl)
+	o.Object = replaceHostInItem(o.Object, fn)
 }
 
-func replaceHostInIntransitiveActivity(o *pub.IntransitiveActivity, repl pub.IRI) {
+func replaceHostInIntransitiveActivity(o *pub.IntransitiveActivity, fn ReplaceFn) {
 	pub.OnObject(o, func(o *pub.Object) error {
-		replaceHostInObject(o, repl)
+		replaceHostInObject(o, fn)
+		return nil
+	})
+	o.Actor = replaceHostInItem(o.Actor, fn)
+	o.Target = replaceHostInItem(o.Target, fn)
+	o.Result = replaceHostInItem(o.Result, fn)
+	o.Origin = replaceHostInItem(o.Origin, fn)
+	o.Instrument = replaceHostInItem(o.Instrument, fn)
+}
+
+func replaceHostInOrderedCollection(c *pub.OrderedCollection, fn ReplaceFn) {
+	pub.OnObject(c, func(o *pub.Object) error {
+		replaceHostInObject(o, fn)
+		return nil
+	})
+	c.Current = replaceHostInItem(c.Current, fn)
+	c.First = replaceHostInItem(c.First, fn)
+	c.Last = replaceHostInItem(c.Last, fn)
+	replaceHostInCollectionOfItems(c.OrderedItems, fn)
+}
+
+func replaceHostInOrderedCollectionPage(c *pub.OrderedCollectionPage, fn ReplaceFn) {
+	pub.OnObject(c, func(o *pub.Object) error {
+		replaceHostInObject(o, fn)
+		return nil
+	})
+	c.Current = replaceHostInItem(c.Current, fn)
+	c.First = replaceHostInItem(c.First, fn)
+	c.Last = replaceHostInItem(c.Last, fn)
+	c.PartOf = replaceHostInItem(c.PartOf, fn)
+	c.Next = replaceHostInItem(c.Next, fn)
+	c.Prev = replaceHostInItem(c.Prev, fn)
+	replaceHostInCollectionOfItems(c.OrderedItems, fn)
+}
+
+func replaceHostInCollection(c *pub.Collection, fn ReplaceFn) {
+	pub.OnObject(c, func(o *pub.Object) error {
+		replaceHostInObject(o, fn)
+		return nil
+	})
+	c.Current = replaceHostInItem(c.Current, fn)
+	c.First = replaceHostInItem(c.First, fn)
+	c.Last = replaceHostInItem(c.Last, fn)
+	replaceHostInCollectionOfItems(c.Items, fn)
+}
+
+func replaceHostInCollectionPage(c *pub.CollectionPage, fn ReplaceFn) {
+	pub.OnObject(c, func(o *pub.Object) error {
+		replaceHostInObject(o, fn)
 		return nil
 	})
-	replaceHostInItem(o.Actor, repl)
-	replaceHostInItem(o.Target, repl)
-	replaceHostInItem(o.Result, repl)
-	replaceHostInItem(o.Origin, repl)
-	replaceHostInItem(o.Instrument, repl)
+	c.Current = replaceHostInItem(c.Current, fn)
+	c.First = replaceHostInItem(c.First, fn)
+	c.Last = replaceHostInItem(c.Last, fn)
+	c.PartOf = replaceHostInItem(c.PartOf, fn)
+	c.Next = replaceHostInItem(c.Next, fn)
+	c.Prev = replaceHostInItem(c.Prev, fn)
+	replaceHostInCollectionOfItems(c.Items, fn)
 }
 
-func replaceHostInOrderedCollection (c *pub.OrderedCollection, repl pub.IRI) { }
-func replaceHostInOrderedCollectionPage (c *pub.OrderedCollectionPage, repl pub.IRI) { }
-func replaceHostInCollection (c *pub.Collection, repl pub.IRI) { }
-func replaceHostInCollectionPage (c *pub.CollectionPage, repl pub.IRI) { }
-func replaceHostInCollectionOfItems (c pub.ItemCollection, repl pub.IRI) {
-	for _, it := range c {
-		replaceHostInItem(it, repl)
+func replaceHostInCollectionOfItems(c pub.ItemCollection, fn ReplaceFn) {
+	for i, it := range c {
+		c[i] = replaceHostInItem(it, fn)
 	}
 }
 
-func replaceHostInItem(it pub.Item, repl pub.IRI) {
+func replaceHostInItemCollection(c pub.ItemCollection, fn ReplaceFn) pub.ItemCollection {
+	replaceHostInCollectionOfItems(c, fn)
+	return c
+}
+
+func replaceHostInItem(it pub.Item, fn ReplaceFn) pub.Item {
 	if pub.IsNil(it) {
-		return
+		return it
 	}
 	if it.IsCollection() {
 		if it.GetType() == pub.OrderedCollectionType {
 			pub.OnOrderedCollection(it, func(c *pub.OrderedCollection) error {
-				replaceHostInOrderedCollection(c, repl)
+				replaceHostInOrderedCollection(c, fn)
 				return nil
 			})
 		}
 		if it.GetType() == pub.OrderedCollectionPageType {
 			pub.OnOrderedCollectionPage(it, func(c *pub.OrderedCollectionPage) error {
-				replaceHostInOrderedCollectionPage(c, repl)
+				replaceHostInOrderedCollectionPage(c, fn)
 				return nil
 			})
 		}
 		if it.GetType() == pub.CollectionType {
 			pub.OnCollection(it, func(c *pub.Collection) error {
-				replaceHostInCollection(c, repl)
+				replaceHostInCollection(c, fn)
 				return nil
 			})
 		}
 		if it.GetType() == pub.CollectionPageType {
 			pub.OnCollectionPage(it, func(c *pub.CollectionPage) error {
-				replaceHostInCollectionPage(c, repl)
+				replaceHostInCollectionPage(c, fn)
 				return nil
 			})
 		}
 		if it.GetType() == pub.CollectionOfItems {
 			pub.OnItemCollection(it, func(c *pub.ItemCollection) error {
-				replaceHostInCollectionOfItems(*c, repl)
+				replaceHostInCollectionOfItems(*c, fn)
 				return nil
 			})
 		}
+		return it
 	}
-	if it.IsObject() {
-		if pub.IntransitiveActivityTypes.Contains(it.GetType()) {
-			pub.OnIntransitiveActivity(it, func(a *pub.IntransitiveActivity) error {
-				replaceHostInIntransitiveActivity(a, repl)
-				return nil
-			})
-		}
-		if pub.ActivityTypes.Contains(it.GetType()) {
-			pub.OnActivity(it, func(a *pub.Activity) error {
-				replaceHostInActivity(a, repl)
-				return nil
-			})
-		}
-		if pub.ActorTypes.Contains(it.GetType()) {
-			pub.OnActor(it, func(a *pub.Actor) error {
-				replaceHostInActor(a, repl)
-				return nil
-			})
-		}
-		if pub.ObjectTypes.Contains(it.GetType()) {
-			pub.OnObject(it, func(o *pub.Object) error {
-				replaceHostInObject(o, repl)
-				return nil
-			})
-		}
+	if iri, ok := it.(pub.IRI); ok {
+		return fn(iri)
 	}
 	if it.IsLink() {
-		l := it.GetLink()
-		// FIXME(marius): because
-		it = *replaceHostInIRI(&l, repl)
+		pub.OnLink(it, func(l *pub.Link) error {
+			replaceIRI(&l.ID, fn)
+			replaceIRI(&l.Href, fn)
+			return nil
+		})
+		return it
+	}
+	if pub.ActivityTypes.Contains(it.GetType()) {
+		pub.OnActivity(it, func(a *pub.Activity) error {
+			replaceHostInActivity(a, fn)
+			return nil
+		})
+	} else if pub.IntransitiveActivityTypes.Contains(it.GetType()) {
+		pub.OnIntransitiveActivity(it, func(a *pub.IntransitiveActivity) error {
+			replaceHostInIntransitiveActivity(a, fn)
+			return nil
+		})
+	} else if pub.ActorTypes.Contains(it.GetType()) {
+		pub.OnActor(it, func(a *pub.Actor) error {
+			replaceHostInActor(a, fn)
+			return nil
+		})
+	} else {
+		pub.OnObject(it, func(o *pub.Object) error {
+			replaceHostInObject(o, fn)
+			return nil
+		})
 	}
+	return it
 }
-
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+const (
+	oldBase = pub.IRI("https://old.example.com")
+	newBase = pub.IRI("https://fedbox.example.com")
+)
+
+func TestReplaceHostInItem_IRI(t *testing.T) {
+	tests := map[string]struct {
+		in   pub.IRI
+		want pub.IRI
+	}{
+		"local":          {in: oldBase.AddPath("actors/1"), want: newBase.AddPath("actors/1")},
+		"local base":     {in: oldBase, want: newBase},
+		"remote":         {in: "https://remote.example.com/actors/1", want: "https://remote.example.com/actors/1"},
+		"public":         {in: pub.PublicNS, want: pub.PublicNS},
+		"similar host":   {in: "https://old.example.com.evil/actors/1", want: "https://old.example.com.evil/actors/1"},
+		"different port": {in: "https://old.example.com:8443/actors/1", want: "https://old.example.com:8443/actors/1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := ReplaceHostInItem(tt.in, oldBase, newBase)
+			if !got.GetLink().Equals(tt.want, true) {
+				t.Errorf("ReplaceHostInItem(%s) = %s, want %s", tt.in, got.GetLink(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceHostInItem_Path(t *testing.T) {
+	from := pub.IRI("https://old.example.com/fedbox")
+	to := pub.IRI("https://fedbox.example.com/")
+	tests := map[string]struct {
+		in   pub.IRI
+		want pub.IRI
+	}{
+		"under path":   {in: "https://old.example.com/fedbox/objects/1", want: "https://fedbox.example.com/objects/1"},
+		"outside path": {in: "https://old.example.com/other/objects/1", want: "https://old.example.com/other/objects/1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := ReplaceHostInItem(tt.in, from, to)
+			if !got.GetLink().Equals(tt.want, true) {
+				t.Errorf("ReplaceHostInItem(%s) = %s, want %s", tt.in, got.GetLink(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceHostInItem_Activity(t *testing.T) {
+	remote := pub.IRI("https://remote.example.com/actors/2")
+	act := &pub.Activity{
+		ID:    oldBase.AddPath("activities/1"),
+		Type:  pub.CreateType,
+		Actor: oldBase.AddPath("actors/1"),
+		To:    pub.ItemCollection{pub.PublicNS, remote},
+		CC:    pub.ItemCollection{oldBase.AddPath("actors/1/followers")},
+		Object: &pub.Object{
+			ID:           oldBase.AddPath("objects/1"),
+			Type:         pub.NoteType,
+			AttributedTo: oldBase.AddPath("actors/1"),
+			InReplyTo:    remote.AddPath("notes/1"),
+		},
+	}
+	ReplaceHostInItem(act, oldBase, newBase)
+
+	if !act.ID.Equals(newBase.AddPath("activities/1"), true) {
+		t.Errorf("Activity ID was not replaced: %s", act.ID)
+	}
+	if !act.Actor.GetLink().Equals(newBase.AddPath("actors/1"), true) {
+		t.Errorf("Activity actor was not replaced: %s", act.Actor.GetLink())
+	}
+	if !act.To[0].GetLink().Equals(pub.PublicNS, true) || !act.To[1].GetLink().Equals(remote, true) {
+		t.Errorf("Activity recipients should not have been replaced: %v", act.To)
+	}
+	if !act.CC[0].GetLink().Equals(newBase.AddPath("actors/1/followers"), true) {
+		t.Errorf("Activity local recipients were not replaced: %v", act.CC)
+	}
+	pub.OnObject(act.Object, func(o *pub.Object) error {
+		if !o.ID.Equals(newBase.AddPath("objects/1"), true) {
+			t.Errorf("Object ID was not replaced: %s", o.ID)
+		}
+		if !o.AttributedTo.GetLink().Equals(newBase.AddPath("actors/1"), true) {
+			t.Errorf("Object attributedTo was not replaced: %s", o.AttributedTo.GetLink())
+		}
+		if !o.InReplyTo.GetLink().Equals(remote.AddPath("notes/1"), true) {
+			t.Errorf("Object inReplyTo should not have been replaced: %s", o.InReplyTo.GetLink())
+		}
+		return nil
+	})
+}
+
+func TestReplaceHostInItem_Collection(t *testing.T) {
+	col := &pub.OrderedCollection{
+		ID:           oldBase.AddPath("actors/1/outbox"),
+		Type:         pub.OrderedCollectionType,
+		OrderedItems: pub.ItemCollection{oldBase.AddPath("activities/1"), oldBase.AddPath("activities/2")},
+	}
+	ReplaceHostInItem(col, oldBase, newBase)
+
+	if !col.ID.Equals(newBase.AddPath("actors/1/outbox"), true) {
+		t.Errorf("Collection ID was not replaced: %s", col.ID)
+	}
+	for i, it := range col.OrderedItems {
+		if !it.GetLink().Contains(newBase, false) {
+			t.Errorf("Collection item %d was not replaced: %s", i, it.GetLink())
+		}
+	}
+}
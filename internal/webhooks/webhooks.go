@@ -0,0 +1,426 @@
+// Package webhooks stores the webhooks configured by the admins, and delivers the activities to them.
+// The deliveries which fail are kept in a queue on disk, and retried with an exponential backoff.
+// The webhooks and the queue are JSON files in a folder, not in the storage backend FedBOX is configured with.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+const (
+	// MaxAttempts is the number of times a delivery is tried before it's dropped
+	MaxAttempts = 10
+	// RetryInterval is the time waited before the first retry. It doubles for each of the next ones.
+	RetryInterval = 30 * time.Second
+
+	hooksFile = "hooks.json"
+	queueDir  = "queue"
+)
+
+// The headers of the webhook requests
+const (
+	HeaderSignature = "X-Fedbox-Signature"
+	HeaderTimestamp = "X-Fedbox-Timestamp"
+	HeaderDelivery  = "X-Fedbox-Delivery"
+	HeaderEvent     = "X-Fedbox-Event"
+)
+
+// Hook is an endpoint which receives the activities matching its filter
+type Hook struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Filter is a query string with ap.Filters parameters, for example "type=Create&object.type=Note"
+	Filter  string    `json:"filter,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Delivery is a request to a webhook which hasn't succeeded yet
+type Delivery struct {
+	ID        string          `json:"id"`
+	Hook      string          `json:"hook"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	NextAt    time.Time       `json:"nextAt"`
+	LastError string          `json:"lastError,omitempty"`
+}
+
+// Store keeps the webhooks and the delivery queue in a folder.
+// The webhooks are cached until the file is changed, by the Store or by another process.
+type Store struct {
+	path string
+	mu   sync.Mutex
+
+	cached  []Hook
+	modTime time.Time
+	size    int64
+}
+
+// New returns a Store which keeps its files in path
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Hooks returns the webhooks
+func (s *Store) Hooks() ([]Hook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *Store) load() ([]Hook, error) {
+	hooks := make([]Hook, 0)
+	name := filepath.Join(s.path, hooksFile)
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		s.cached = nil
+		return hooks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cached != nil && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return append(hooks, s.cached...), nil
+	}
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(raw, &hooks); err != nil {
+		return nil, errors.Annotatef(err, "invalid webhooks file")
+	}
+	s.cached, s.modTime, s.size = hooks, fi.ModTime(), fi.Size()
+	return append(make([]Hook, 0, len(hooks)), hooks...), nil
+}
+
+func (s *Store) save(hooks []Hook) error {
+	if err := os.MkdirAll(s.path, 0700); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.path, "."+hooksFile)
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	s.cached = nil
+	return os.Rename(tmp, filepath.Join(s.path, hooksFile))
+}
+
+// Hook returns the webhook with the id
+func (s *Store) Hook(id string) (Hook, error) {
+	hooks, err := s.Hooks()
+	if err != nil {
+		return Hook{}, err
+	}
+	for _, h := range hooks {
+		if h.ID == id {
+			return h, nil
+		}
+	}
+	return Hook{}, errors.NotFoundf("webhook %s not found", id)
+}
+
+// Add saves a new webhook. The ID, and the secret if it's missing, are generated.
+func (s *Store) Add(h Hook) (Hook, error) {
+	if !strings.HasPrefix(h.URL, "https://") && !strings.HasPrefix(h.URL, "http://") {
+		return h, errors.NotValidf("invalid webhook URL %q", h.URL)
+	}
+	h.ID = randomID()
+	if len(h.Secret) == 0 {
+		h.Secret = randomID() + randomID()
+	}
+	h.Created = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks, err := s.load()
+	if err != nil {
+		return h, err
+	}
+	return h, s.save(append(hooks, h))
+}
+
+// Remove deletes the webhook with the id, and its pending deliveries
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks, err := s.load()
+	if err != nil {
+		return err
+	}
+	kept := make([]Hook, 0, len(hooks))
+	for _, h := range hooks {
+		if h.ID != id {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) == len(hooks) {
+		return errors.NotFoundf("webhook %s not found", id)
+	}
+	if err = s.save(kept); err != nil {
+		return err
+	}
+	pending, _ := s.queue()
+	for _, d := range pending {
+		if d.Hook == id {
+			os.Remove(s.deliveryPath(d.ID))
+		}
+	}
+	return nil
+}
+
+func (s *Store) deliveryPath(id string) string {
+	return filepath.Join(s.path, queueDir, id+".json")
+}
+
+// Enqueue saves a delivery to the queue
+func (s *Store) Enqueue(d Delivery) error {
+	if err := os.MkdirAll(filepath.Join(s.path, queueDir), 0700); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.deliveryPath(d.ID), raw, 0600)
+}
+
+// Pending returns the deliveries from the queue, the oldest first
+func (s *Store) Pending() ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue()
+}
+
+func (s *Store) queue() ([]Delivery, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.path, queueDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Delivery, 0, len(files))
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(s.path, queueDir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		d := Delivery{}
+		if err = json.Unmarshal(raw, &d); err != nil {
+			continue
+		}
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NextAt.Before(res[j].NextAt)
+	})
+	return res, nil
+}
+
+// Len returns the number of deliveries in the queue
+func (s *Store) Len() int {
+	files, _ := ioutil.ReadDir(filepath.Join(s.path, queueDir))
+	count := 0
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".json") {
+			count++
+		}
+	}
+	return count
+}
+
+// Sign returns the signature of a webhook request: the HMAC-SHA256 of the timestamp and the body, joined by a dot.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends the deliveries to the webhooks
+type Dispatcher struct {
+	st    *Store
+	cl    *http.Client
+	errFn func(string, ...interface{})
+	// wg tracks the deliveries in flight and the retry loop
+	wg sync.WaitGroup
+	// inFlight is the number of requests to the webhooks in progress
+	inFlight int64
+}
+
+// NewDispatcher returns a Dispatcher for the webhooks in st
+func NewDispatcher(st *Store, errFn func(string, ...interface{})) *Dispatcher {
+	if errFn == nil {
+		errFn = func(string, ...interface{}) {}
+	}
+	return &Dispatcher{st: st, cl: &http.Client{Timeout: 10 * time.Second}, errFn: errFn}
+}
+
+// Store returns the store of the dispatcher
+func (d *Dispatcher) Store() *Store {
+	return d.st
+}
+
+// InFlight returns the number of requests to the webhooks in progress
+func (d *Dispatcher) InFlight() int {
+	return int(atomic.LoadInt64(&d.inFlight))
+}
+
+// Send makes the request for the delivery to the webhook, and returns the status code of the response
+func (d *Dispatcher) Send(h Hook, del Delivery) (int, error) {
+	atomic.AddInt64(&d.inFlight, 1)
+	defer atomic.AddInt64(&d.inFlight, -1)
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("User-Agent", "FedBOX webhooks")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, del.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(h.Secret, ts, del.Payload))
+	resp, err := d.cl.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Newf("%s responded with %s", h.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Go runs fn in the background, Drain waits for it like for the deliveries in flight
+func (d *Dispatcher) Go(fn func()) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		fn()
+	}()
+}
+
+// Fire sends the payload to the webhook in the background. If it fails, it's queued for retrying.
+func (d *Dispatcher) Fire(h Hook, event string, payload []byte) {
+	del := Delivery{ID: randomID(), Hook: h.ID, Event: event, Payload: payload, NextAt: time.Now().Add(RetryInterval)}
+	// NOTE(marius): the delivery is queued before sending it, so it's not lost if FedBOX stops before it's done
+	if err := d.st.Enqueue(del); err != nil {
+		d.errFn("Unable to queue webhook delivery %s: %s", del.ID, err)
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if _, err := d.Send(h, del); err != nil {
+			d.retry(del, err)
+			return
+		}
+		os.Remove(d.st.deliveryPath(del.ID))
+	}()
+}
+
+func (d *Dispatcher) retry(del Delivery, err error) {
+	del.Attempts++
+	del.LastError = err.Error()
+	if del.Attempts >= MaxAttempts {
+		d.errFn("Dropping webhook delivery %s to %s after %d attempts: %s", del.ID, del.Hook, del.Attempts, err)
+		os.Remove(d.st.deliveryPath(del.ID))
+		return
+	}
+	del.NextAt = time.Now().Add(RetryInterval << uint(del.Attempts-1))
+	if err := d.st.Enqueue(del); err != nil {
+		d.errFn("Unable to queue webhook delivery %s: %s", del.ID, err)
+	}
+}
+
+// Retry sends the queued deliveries which are due
+func (d *Dispatcher) Retry() {
+	pending, err := d.st.Pending()
+	if err != nil {
+		d.errFn("Unable to load the webhook queue: %s", err)
+		return
+	}
+	now := time.Now()
+	for _, del := range pending {
+		if del.NextAt.After(now) {
+			break
+		}
+		h, err := d.st.Hook(del.Hook)
+		if err != nil {
+			os.Remove(d.st.deliveryPath(del.ID))
+			continue
+		}
+		if _, err = d.Send(h, del); err != nil {
+			d.retry(del, err)
+			continue
+		}
+		os.Remove(d.st.deliveryPath(del.ID))
+	}
+}
+
+// Run retries the queued deliveries every interval, until stop is closed
+func (d *Dispatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	d.wg.Add(1)
+	defer d.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			d.Retry()
+		}
+	}
+}
+
+// Drain waits for the deliveries in flight and for the retry loop to finish, until ctx is done.
+// The deliveries which don't finish in time are retried when FedBOX starts again.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(dir)
+	if _, err := s.Add(Hook{URL: "ftp://example.com"}); err == nil {
+		t.Errorf("expected an error for a non HTTP URL")
+	}
+	h, err := s.Add(Hook{URL: "https://example.com/hook", Filter: "type=Create"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.ID) == 0 || len(h.Secret) == 0 {
+		t.Errorf("expected the ID and the secret to be generated, got %q and %q", h.ID, h.Secret)
+	}
+	hooks, err := s.Hooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != h.ID || hooks[0].Filter != "type=Create" {
+		t.Errorf("expected the saved webhook, got %#v", hooks)
+	}
+	if err = s.Enqueue(Delivery{ID: "test", Hook: h.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Remove(h.ID); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := s.Pending(); len(pending) != 0 {
+		t.Errorf("expected the deliveries of the removed webhook to be removed, got %d", len(pending))
+	}
+	if err = s.Remove(h.ID); err == nil {
+		t.Errorf("expected an error removing a missing webhook")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fail := true
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign("secret", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	st := New(dir)
+	h, _ := st.Add(Hook{URL: srv.URL, Secret: "secret"})
+	d := NewDispatcher(st, nil)
+	del := Delivery{ID: "1", Hook: h.ID, Event: "Create", Payload: []byte(`{"type":"Create"}`)}
+	_, err = d.Send(h, del)
+	if err == nil {
+		t.Fatalf("expected the delivery to fail")
+	}
+	d.retry(del, err)
+	pending, _ := st.Pending()
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("expected the failed delivery to be queued, got %#v", pending)
+	}
+
+	// NOTE(marius): make the delivery due, and let the webhook accept it
+	pending[0].NextAt = time.Now().Add(-time.Second)
+	st.Enqueue(pending[0])
+	fail = false
+	d.Retry()
+	if pending, _ = st.Pending(); len(pending) != 0 {
+		t.Errorf("expected the queue to be empty after the retry, got %d deliveries", len(pending))
+	}
+	if string(body) != `{"type":"Create"}` {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
+
+func TestDispatcher_Drain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	st := New(dir)
+	h, _ := st.Add(Hook{URL: srv.URL})
+	d := NewDispatcher(st, nil)
+	d.Fire(h, "Create", []byte(`{"type":"Create"}`))
+	if st.Len() != 1 {
+		t.Errorf("expected the delivery in flight to be queued, got %d deliveries", st.Len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Drain(ctx); err == nil {
+		t.Errorf("expected Drain to time out while the delivery is in flight")
+	}
+
+	close(release)
+	if err := d.Drain(context.Background()); err != nil {
+		t.Errorf("Drain error: %s", err)
+	}
+	if st.Len() != 0 {
+		t.Errorf("expected the queue to be empty after the delivery, got %d deliveries", st.Len())
+	}
+}
+
+func TestStore_HooksCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := New(dir)
+	h, err := st.Add(Hook{URL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("Unable to add webhook: %s", err)
+	}
+	if hooks, _ := st.Hooks(); len(hooks) != 1 || hooks[0].ID != h.ID {
+		t.Fatalf("expected the added webhook, got %#v", hooks)
+	}
+
+	// NOTE(marius): another process, like ctl, changes the file
+	other := New(dir)
+	if _, err = other.Add(Hook{URL: "https://example.com/other"}); err != nil {
+		t.Fatalf("Unable to add webhook: %s", err)
+	}
+	if hooks, _ := st.Hooks(); len(hooks) != 2 {
+		t.Errorf("expected the cached webhooks to be reloaded after the file changed, got %d", len(hooks))
+	}
+	hooks, _ := st.Hooks()
+	hooks[0].URL = "https://example.com/changed"
+	if again, _ := st.Hooks(); again[0].URL == hooks[0].URL {
+		t.Errorf("the cached webhooks should not be changed through the returned ones")
+	}
+}
@@ -0,0 +1,179 @@
+// Package websocket serves the WebSocket connections of the streaming API, using golang.org/x/net/websocket.
+// It adds what the streaming API needs on top of it: pings, and closing the connections of the clients
+// which stop answering them.
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"golang.org/x/net/websocket"
+)
+
+// MaxMessageSize is the size of the largest message the clients can send
+const MaxMessageSize = 64 << 10
+
+// writeTimeOut is how long we wait for writing a message to a client
+const writeTimeOut = 10 * time.Second
+
+// Conn is a WebSocket connection. The messages can be written from multiple goroutines,
+// but only one goroutine should read them.
+type Conn struct {
+	ws  *websocket.Conn
+	raw net.Conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsUpgrade returns true if r is a WebSocket handshake request
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// aliveConn extends the read deadline of the connection every time the client sends something,
+// the pongs for our pings included, so only the connections of the clients which went away time out.
+type aliveConn struct {
+	net.Conn
+	wait time.Duration
+}
+
+func (c *aliveConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.wait))
+	}
+	return n, err
+}
+
+// hijacker gives to the x/net/websocket server an aliveConn instead of the connection of the request
+type hijacker struct {
+	http.ResponseWriter
+	wait time.Duration
+	conn *aliveConn
+}
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.NotImplementedf("WebSocket connections are not supported")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	h.conn = &aliveConn{Conn: conn, wait: h.wait}
+	h.conn.SetReadDeadline(time.Now().Add(h.wait))
+	// NOTE(marius): the bytes the HTTP server has already buffered are read before the ones from the connection
+	r := io.MultiReader(io.LimitReader(rw.Reader, int64(rw.Reader.Buffered())), h.conn)
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(h.conn)), nil
+}
+
+// Handler returns the handler which accepts the WebSocket handshakes and calls fn with the connection,
+// which is closed when fn returns. The connection is closed too when the client sends nothing, not even
+// the pongs for the pings, for longer than wait.
+func Handler(wait time.Duration, fn func(*Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errors.HandleError(errors.MethodNotAllowedf("WebSocket handshakes need to use GET")).ServeHTTP(w, r)
+			return
+		}
+		if !IsUpgrade(r) {
+			errors.HandleError(errors.BadRequestf("not a WebSocket handshake")).ServeHTTP(w, r)
+			return
+		}
+		h := &hijacker{ResponseWriter: w, wait: wait}
+		srv := websocket.Server{
+			// NOTE(marius): the clients are authenticated with their tokens, not with cookies,
+			// so we accept the connections from any origin
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				ws.MaxPayloadBytes = MaxMessageSize
+				c := &Conn{ws: ws, raw: h.conn}
+				defer c.Close()
+				fn(c)
+			},
+		}
+		srv.ServeHTTP(h, r)
+	})
+}
+
+// WriteMessage sends a text message
+func (c *Conn) WriteMessage(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(writeTimeOut))
+	return websocket.Message.Send(c.ws, string(data))
+}
+
+func (c *Conn) writeFrame(typ byte, data []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeTimeOut))
+	c.ws.PayloadType = typ
+	_, err := c.ws.Write(data)
+	return err
+}
+
+// Ping sends a ping to the client. Its pong, like any other message, keeps the connection open.
+func (c *Conn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	return c.writeFrame(websocket.PingFrame, nil)
+}
+
+// ReadMessage returns the next message, the pings are answered while waiting for it.
+// When the client closes the connection it returns io.EOF.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+		if err == websocket.ErrFrameTooLarge {
+			c.close([]byte{0x03, 0xF1}) // 1009: message too big
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *Conn) close(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.writeFrame(websocket.CloseFrame, payload)
+	return c.raw.Close()
+}
+
+// Close sends a close frame to the client and closes the connection
+func (c *Conn) Close() error {
+	return c.close([]byte{0x03, 0xE8}) // 1000: normal closure
+}
+
+// CloseGoingAway closes the connection telling the client that the server is shutting down
+func (c *Conn) CloseGoingAway() error {
+	return c.close([]byte{0x03, 0xE9}) // 1001: going away
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func dial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	return ws
+}
+
+func TestConn(t *testing.T) {
+	srv := httptest.NewServer(Handler(time.Minute, func(c *Conn) {
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(msg)
+		}
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv)
+	defer ws.Close()
+	if err := websocket.Message.Send(ws, "hello world"); err != nil {
+		t.Fatalf("Unable to send message: %s", err)
+	}
+	var got string
+	if err := websocket.Message.Receive(ws, &got); err != nil {
+		t.Fatalf("Unable to receive message: %s", err)
+	}
+	if got != "hello world" {
+		t.Errorf("expected %q, got %q", "hello world", got)
+	}
+
+	// the messages over the size limit close the connection
+	if err := websocket.Message.Send(ws, strings.Repeat("x", MaxMessageSize+1)); err != nil {
+		t.Fatalf("Unable to send message: %s", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.Message.Receive(ws, &got); err != io.EOF {
+		t.Errorf("expected the connection to be closed after a message which is too large, got %v", err)
+	}
+}
+
+func TestConn_ReadTimeOut(t *testing.T) {
+	wait := 100 * time.Millisecond
+	start := time.Now()
+	result := make(chan error, 1)
+	srv := httptest.NewServer(Handler(wait, func(c *Conn) {
+		_, err := c.ReadMessage()
+		result <- err
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv)
+	defer ws.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("expected ReadMessage to fail for a client which doesn't send anything")
+		}
+		if elapsed := time.Since(start); elapsed < wait {
+			t.Errorf("ReadMessage failed after %s, before the read deadline", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("ReadMessage didn't time out for a client which doesn't send anything")
+	}
+}
+
+func TestConn_PongsKeepTheConnection(t *testing.T) {
+	wait := 200 * time.Millisecond
+	result := make(chan error, 1)
+	srv := httptest.NewServer(Handler(wait, func(c *Conn) {
+		go func() {
+			for i := 0; i < 10; i++ {
+				time.Sleep(wait / 4)
+				c.Ping()
+			}
+			c.WriteMessage([]byte("done"))
+		}()
+		_, err := c.ReadMessage()
+		result <- err
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv)
+	defer ws.Close()
+	// NOTE(marius): the x/net/websocket client answers the pings while it waits for a message
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil || msg != "done" {
+		t.Fatalf("expected the connection to stay open while the client answers the pings, got %q: %v", msg, err)
+	}
+	websocket.Message.Send(ws, "bye")
+	if err := <-result; err != nil {
+		t.Errorf("ReadMessage() error = %s", err)
+	}
+}
+
+func TestHandler_Invalid(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	Handler(time.Minute, func(*Conn) {
+		t.Errorf("the handler should not be called for a request which is not a WebSocket handshake")
+	}).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
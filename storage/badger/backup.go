@@ -0,0 +1,72 @@
+// +build storage_badger storage_all !storage_pgx,!storage_boltdb,!storage_fs,!storage_sqlite
+
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-ap/errors"
+	"io"
+	"os"
+)
+
+// Backup writes a consistent snapshot of the badger database to w, using the repository's database
+func (r *repo) Backup(w io.Writer) error {
+	if r.path == "" {
+		return errors.Newf("unable to backup in memory storage")
+	}
+	if err := r.Open(); err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err := r.d.Backup(w, 0)
+	return err
+}
+
+// Restore replaces the badger database with the snapshot read from rd
+func (r *repo) Restore(rd io.Reader) error {
+	return RestoreDir(r.path, rd)
+}
+
+// BackupDir writes a full snapshot of the badger database found at path to w.
+// It is used for the databases which aren't opened by a repository, like the OAuth2 one.
+// Badger allows only one process to open a database, so it fails while FedBOX is running.
+func BackupDir(path string, w io.Writer) error {
+	if path == "" {
+		return errors.Newf("unable to backup in memory storage")
+	}
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+	if err != nil {
+		return errors.Annotatef(err, "unable to open storage %s", path)
+	}
+	defer db.Close()
+	_, err = db.Backup(w, 0)
+	return err
+}
+
+// RestoreDir replaces the badger database found at path with the snapshot read from rd.
+// The snapshot is loaded in a new database next to the old one, which is replaced only if loading succeeds.
+func RestoreDir(path string, rd io.Reader) error {
+	if path == "" {
+		return errors.Newf("unable to restore in memory storage")
+	}
+	tmp := path + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	db, err := badger.Open(badger.DefaultOptions(tmp).WithLogger(nil))
+	if err != nil {
+		return errors.Annotatef(err, "unable to open storage %s", tmp)
+	}
+	if err = db.Load(rd, 256); err != nil {
+		db.Close()
+		os.RemoveAll(tmp)
+		return errors.Annotatef(err, "invalid badger snapshot")
+	}
+	if err = db.Close(); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(path); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
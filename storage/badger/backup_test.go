@@ -0,0 +1,72 @@
+// +build storage_badger storage_all !storage_pgx,!storage_boltdb,!storage_fs,!storage_sqlite
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func TestRepo_BackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-badger-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := New(Config{Path: filepath.Join(dir, "src")})
+	if err != nil {
+		t.Fatalf("Unable to create badger storage: %s", err)
+	}
+	key, val := []byte("https://example.com/objects/1"), []byte(`{"id":"https://example.com/objects/1"}`)
+	if err = src.Open(); err != nil {
+		t.Fatalf("Unable to open %s: %s", src.path, err)
+	}
+	err = src.d.Update(func(tx *badger.Txn) error {
+		return tx.Set(key, val)
+	})
+	src.Close()
+	if err != nil {
+		t.Fatalf("Unable to write to %s: %s", src.path, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err = src.Backup(&buf); err != nil {
+		t.Fatalf("Unable to backup %s: %s", src.path, err)
+	}
+	dst, err := New(Config{Path: filepath.Join(dir, "dst")})
+	if err != nil {
+		t.Fatalf("Unable to create badger storage: %s", err)
+	}
+	if err = dst.Restore(&buf); err != nil {
+		t.Fatalf("Unable to restore to %s: %s", dst.path, err)
+	}
+	if _, err = os.Stat(dst.path + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("Temporary restore folder was not removed")
+	}
+
+	if err = dst.Open(); err != nil {
+		t.Fatalf("Unable to open restored %s: %s", dst.path, err)
+	}
+	defer dst.Close()
+	err = dst.d.View(func(tx *badger.Txn) error {
+		it, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		return it.Value(func(got []byte) error {
+			if !bytes.Equal(got, val) {
+				t.Errorf("Restored value for %s is %s, expected %s", key, got, val)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Errorf("Unable to load %s from the restored storage: %s", key, err)
+	}
+}
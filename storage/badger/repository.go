@@ -10,6 +10,8 @@ import (
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/jsonld"
@@ -71,6 +73,13 @@ func New(c Config) (*repo, error) {
 	return &b, nil
 }
 
+// SetCacheEnabled enables or disables the in memory cache of the loaded objects
+func (r *repo) SetCacheEnabled(enabled bool) {
+	if c, ok := r.cache.(interface{ SetEnabled(bool) }); ok {
+		c.SetEnabled(enabled)
+	}
+}
+
 // Open opens the badger database if possible.
 func (r *repo) Open() error {
 	var (
@@ -98,6 +107,7 @@ func (r *repo) Close() error {
 
 // Load
 func (r *repo) Load(i pub.IRI) (pub.Item, error) {
+	defer metrics.StorageTimer("badger", "Load")()
 	var err error
 	if r.Open(); err != nil {
 		return nil, err
@@ -129,6 +139,7 @@ func (r *repo) Create(col pub.CollectionInterface) (pub.CollectionInterface, err
 
 // Save
 func (r *repo) Save(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("badger", "Save")()
 	var err error
 	err = r.Open()
 	if err != nil {
@@ -233,17 +244,25 @@ func addCollectionOnObject(r *repo, col pub.IRI) error {
 
 // AddTo
 func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
+	defer metrics.StorageTimer("badger", "AddTo")()
 	addCollectionOnObject(r, col)
-	return onCollection(r, col, it, func(iris pub.IRIs) (pub.IRIs, error) {
+	added := false
+	err := onCollection(r, col, it, func(iris pub.IRIs) (pub.IRIs, error) {
 		if iris.Contains(it.GetLink()) {
 			return iris, nil
 		}
+		added = true
 		return append(iris, it.GetLink()), nil
 	})
+	if err == nil && added {
+		events.Publish(col, it.GetLink())
+	}
+	return err
 }
 
 // Delete
 func (r *repo) Delete(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("badger", "Delete")()
 	var err error
 	err = r.Open()
 	if err != nil {
@@ -270,32 +289,13 @@ func getMetadataKey(p []byte) []byte {
 
 // PasswordSet
 func (r *repo) PasswordSet(it pub.Item, pw []byte) error {
-	path := itemPath(it.GetLink())
-	err := r.Open()
+	pw, err := bcrypt.GenerateFromPassword(pw, -1)
 	if err != nil {
-		return err
+		return errors.Annotatef(err, "could not generate pw hash")
 	}
-	defer r.Close()
-
-	err = r.d.Update(func(tx *badger.Txn) error {
-		pw, err = bcrypt.GenerateFromPassword(pw, -1)
-		if err != nil {
-			return errors.Annotatef(err, "Could not encrypt the pw")
-		}
-		m := storage.Metadata{
-			Pw: pw,
-		}
-		entryBytes, err := jsonld.Marshal(m)
-		if err != nil {
-			return errors.Annotatef(err, "Could not marshal metadata")
-		}
-		err = tx.Set(getMetadataKey(path), entryBytes)
-		if err != nil {
-			return errors.Annotatef(err, "Could not insert entry: %s", path)
-		}
-		return nil
+	_, err = storage.UpdateMetadata(r, it.GetLink(), func(m *storage.Metadata) {
+		m.Pw = pw
 	})
-
 	return err
 }
 
@@ -341,8 +341,11 @@ func (r *repo) LoadMetadata(iri pub.IRI) (*storage.Metadata, error) {
 	var m *storage.Metadata
 	err = r.d.View(func(tx *badger.Txn) error {
 		i, err := tx.Get(getMetadataKey(path))
+		if err == badger.ErrKeyNotFound {
+			return errors.NewNotFound(err, "Could not find metadata in path %s", path)
+		}
 		if err != nil {
-			return errors.Annotatef(err, "Could not find metadata in path %s", path)
+			return errors.Annotatef(err, "Could not load metadata in path %s", path)
 		}
 		m = new(storage.Metadata)
 		return i.Value(func(raw []byte) error {
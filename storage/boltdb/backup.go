@@ -0,0 +1,70 @@
+// +build storage_boltdb storage_all !storage_pgx,!storage_fs,!storage_badger,!storage_sqlite
+
+package boltdb
+
+import (
+	"github.com/go-ap/errors"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+)
+
+// Backup writes a consistent snapshot of the bolt database to w, from a read transaction
+// of the repository's database, so it doesn't block the other readers.
+func (r *repo) Backup(w io.Writer) error {
+	if err := r.Open(); err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeSnapshot(r.d, w)
+}
+
+// Restore replaces the bolt database with the snapshot read from rd
+func (r *repo) Restore(rd io.Reader) error {
+	return RestoreFile(r.path, rd)
+}
+
+func writeSnapshot(db *bolt.DB, w io.Writer) error {
+	return db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// BackupFile writes a consistent snapshot of the bolt database found at path to w.
+// It is used for the databases which aren't opened by a repository, like the OAuth2 one,
+// and it fails if the lock of the database can't be acquired in openTimeout.
+func BackupFile(path string, w io.Writer) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		return errors.Annotatef(err, "could not open db %s", path)
+	}
+	defer db.Close()
+	return writeSnapshot(db, w)
+}
+
+// RestoreFile replaces the bolt database found at path with the snapshot read from rd.
+// The snapshot is written next to the database and checked before replacing it.
+func RestoreFile(path string, rd io.Reader) error {
+	tmp := path + ".restore"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotatef(err, "could not create %s", tmp)
+	}
+	if _, err = io.Copy(f, rd); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Annotatef(err, "could not write %s", tmp)
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	db, err := bolt.Open(tmp, 0600, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Annotatef(err, "invalid bolt snapshot")
+	}
+	db.Close()
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,84 @@
+// +build storage_boltdb storage_all !storage_pgx,!storage_fs,!storage_badger,!storage_sqlite
+
+package boltdb
+
+import (
+	"bytes"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupFileRestoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-bolt-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.bdb")
+	dst := filepath.Join(dir, "dst.bdb")
+	key, val := []byte(objectKey), []byte(`{"id":"https://example.com"}`)
+
+	db, err := bolt.Open(src, 0600, nil)
+	if err != nil {
+		t.Fatalf("Unable to open boltdb %s: %s", src, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(rootBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, val)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Unable to write to boltdb %s: %s", src, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err = BackupFile(src, &buf); err != nil {
+		t.Fatalf("Unable to backup %s: %s", src, err)
+	}
+	if err = RestoreFile(dst, &buf); err != nil {
+		t.Fatalf("Unable to restore to %s: %s", dst, err)
+	}
+	if _, err = os.Stat(dst + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("Temporary restore file was not removed")
+	}
+
+	db, err = bolt.Open(dst, 0600, nil)
+	if err != nil {
+		t.Fatalf("Unable to open restored boltdb %s: %s", dst, err)
+	}
+	defer db.Close()
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(rootBucket))
+		if b == nil {
+			t.Errorf("Could not find bucket %s in restored boltdb", rootBucket)
+			return nil
+		}
+		if got := b.Get(key); !bytes.Equal(got, val) {
+			t.Errorf("Restored value for %s is %s, expected %s", key, got, val)
+		}
+		return nil
+	})
+}
+
+func TestRestoreFileInvalidSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-bolt-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "dst.bdb")
+	if err = RestoreFile(dst, bytes.NewBufferString("not a bolt database")); err == nil {
+		t.Errorf("Expected error when restoring an invalid snapshot")
+	}
+	if _, err = os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Invalid snapshot should not have replaced %s", dst)
+	}
+}
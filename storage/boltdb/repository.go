@@ -8,6 +8,8 @@ import (
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/jsonld"
@@ -303,6 +305,7 @@ func (r repo) buildIRIs(c handlers.CollectionType, hashes ...ap.Hash) pub.IRIs {
 
 // Load
 func (r *repo) Load(i pub.IRI) (pub.Item, error) {
+	defer metrics.StorageTimer("boltdb", "Load")()
 	var err error
 	if r.Open(); err != nil {
 		return nil, err
@@ -579,6 +582,7 @@ func save(r *repo, it pub.Item) (pub.Item, error) {
 
 // Save
 func (r *repo) Save(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("boltdb", "Save")()
 	var err error
 	err = r.Open()
 	if err != nil {
@@ -699,17 +703,25 @@ func addCollectionOnObject(r *repo, col pub.IRI) error {
 
 // AddTo
 func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
+	defer metrics.StorageTimer("boltdb", "AddTo")()
 	addCollectionOnObject(r, col)
-	return onCollection(r, col, it, func(iris pub.IRIs) (pub.IRIs, error) {
+	added := false
+	err := onCollection(r, col, it, func(iris pub.IRIs) (pub.IRIs, error) {
 		if iris.Contains(it.GetLink()) {
 			return iris, nil
 		}
+		added = true
 		return append(iris, it.GetLink()), nil
 	})
+	if err == nil && added {
+		events.Publish(col, it.GetLink())
+	}
+	return err
 }
 
 // Delete
 func (r *repo) Delete(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("boltdb", "Delete")()
 	var err error
 	err = r.Open()
 	if err != nil {
@@ -730,13 +742,16 @@ func (r *repo) Delete(it pub.Item) (pub.Item, error) {
 	return it, err
 }
 
+// openTimeout is how long we wait for the lock of a bolt database which is used by another process
+const openTimeout = 5 * time.Second
+
 // Open opens the boltdb database if possible.
 func (r *repo) Open() error {
 	if r == nil {
 		return errors.Newf("Unable to open uninitialized db")
 	}
 	var err error
-	r.d, err = bolt.Open(r.path, 0600, nil)
+	r.d, err = bolt.Open(r.path, 0600, &bolt.Options{Timeout: openTimeout})
 	if err != nil {
 		return errors.Annotatef(err, "Could not open db %s", r.path)
 	}
@@ -756,51 +771,13 @@ func (r *repo) Close() error {
 
 // PasswordSet
 func (r *repo) PasswordSet(it pub.Item, pw []byte) error {
-	path := itemBucketPath(it.GetLink())
-	err := r.Open()
+	pw, err := bcrypt.GenerateFromPassword(pw, -1)
 	if err != nil {
-		return err
+		return errors.Annotatef(err, "could not generate pw hash")
 	}
-	defer r.Close()
-
-	err = r.d.Update(func(tx *bolt.Tx) error {
-		root, err := tx.CreateBucketIfNotExists(r.root)
-		if err != nil {
-			return errors.Errorf("Not able to write to root bucket %s", r.root)
-		}
-		if root == nil {
-			return ErrorInvalidRoot(r.root)
-		}
-		if !root.Writable() {
-			return errors.Errorf("Non writeable bucket %s", r.root)
-		}
-		var b *bolt.Bucket
-		b, _, err = descendInBucket(root, path, true)
-		if err != nil {
-			return errors.Newf("Unable to find %s in root bucket", path)
-		}
-		if !b.Writable() {
-			return errors.Errorf("Non writeable bucket %s", path)
-		}
-
-		pw, err = bcrypt.GenerateFromPassword(pw, -1)
-		if err != nil {
-			return errors.Annotatef(err, "Could not encrypt the pw")
-		}
-		m := storage.Metadata{
-			Pw: pw,
-		}
-		entryBytes, err := encodeFn(m)
-		if err != nil {
-			return errors.Annotatef(err, "Could not marshal metadata")
-		}
-		err = b.Put([]byte(metaDataKey), entryBytes)
-		if err != nil {
-			return errors.Errorf("Could not insert entry: %s", err)
-		}
-		return nil
+	_, err = storage.UpdateMetadata(r, it.GetLink(), func(m *storage.Metadata) {
+		m.Pw = pw
 	})
-
 	return err
 }
 
@@ -855,9 +832,12 @@ func (r *repo) LoadMetadata(iri pub.IRI) (*storage.Metadata, error) {
 		var b *bolt.Bucket
 		b, path, err = descendInBucket(root, path, false)
 		if err != nil {
-			return errors.Newf("Unable to find %s in root bucket", path)
+			return errors.NotFoundf("Unable to find %s in root bucket", path)
 		}
 		entryBytes := b.Get([]byte(metaDataKey))
+		if entryBytes == nil {
+			return errors.NotFoundf("Could not find metadata in path %s", path)
+		}
 		m = new(storage.Metadata)
 		return json.Unmarshal(entryBytes, m)
 	})
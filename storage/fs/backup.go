@@ -0,0 +1,141 @@
+// +build storage_fs storage_all !storage_boltdb,!storage_badger,!storage_pgx,!storage_sqlite
+
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// Backup writes the contents of the storage folder to w, as a tar archive.
+// The filesystem storage doesn't have a snapshot mechanism, so the archive is consistent
+// only if nothing writes to the storage while the backup runs.
+func (r *repo) Backup(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(r.path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(r.path, p)
+		if err != nil || name == "." {
+			return err
+		}
+		link := ""
+		if isSymLink(fi) {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return errors.Annotatef(err, "unable to backup %s", r.path)
+	}
+	return tw.Close()
+}
+
+// Restore replaces the contents of the storage folder with the tar archive read from rd.
+// The archive is extracted next to the storage folder, which is replaced only if extracting succeeds.
+func (r *repo) Restore(rd io.Reader) error {
+	tmp := r.path + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := extract(tar.NewReader(rd), tmp); err != nil {
+		os.RemoveAll(tmp)
+		return errors.Annotatef(err, "invalid fs snapshot")
+	}
+	if err := os.RemoveAll(r.path); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func extract(tr *tar.Reader, dir string) error {
+	if err := mkDirIfNotExists(dir); err != nil {
+		return err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(p, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return errors.Newf("invalid path in archive %s", hdr.Name)
+		}
+		// NOTE(marius): the storage links to the items with absolute symlinks, so we can't refuse the ones
+		// pointing outside dir, but nothing gets written through them
+		if err = noSymlinks(dir, p); err != nil {
+			return errors.Annotatef(err, "invalid path in archive %s", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(p, os.FileMode(hdr.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err = os.Symlink(hdr.Linkname, p); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// noSymlinks returns an error if p, or any of the folders between dir and p, is a symlink
+func noSymlinks(dir, p string) error {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return err
+	}
+	cur := dir
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		cur = filepath.Join(cur, name)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isSymLink(fi) {
+			return errors.Newf("%s is a symlink", cur)
+		}
+	}
+	return nil
+}
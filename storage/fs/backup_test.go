@@ -0,0 +1,127 @@
+// +build storage_fs storage_all !storage_boltdb,!storage_badger,!storage_pgx,!storage_sqlite
+
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepo_BackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-fs-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := New(Config{StoragePath: dir, BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Unable to create fs storage: %s", err)
+	}
+	file := filepath.Join(r.path, "example.com", "__raw")
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatalf("Unable to create %s: %s", filepath.Dir(file), err)
+	}
+	val := []byte(`{"id":"https://example.com"}`)
+	if err = ioutil.WriteFile(file, val, 0600); err != nil {
+		t.Fatalf("Unable to write %s: %s", file, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err = r.Backup(&buf); err != nil {
+		t.Fatalf("Unable to backup %s: %s", r.path, err)
+	}
+	if err = ioutil.WriteFile(file, []byte("changed"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %s", file, err)
+	}
+	if err = r.Restore(&buf); err != nil {
+		t.Fatalf("Unable to restore %s: %s", r.path, err)
+	}
+	if _, err = os.Stat(r.path + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("Temporary restore folder was not removed")
+	}
+	if got, err := ioutil.ReadFile(file); err != nil || !bytes.Equal(got, val) {
+		t.Errorf("Restored value is %s, expected %s: %v", got, val, err)
+	}
+}
+
+func TestRepo_RestoreInvalidSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-fs-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := New(Config{StoragePath: dir, BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Unable to create fs storage: %s", err)
+	}
+	file := filepath.Join(r.path, "keep")
+	if err = ioutil.WriteFile(file, []byte("keep"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %s", file, err)
+	}
+	if err = r.Restore(bytes.NewBufferString("not a tar archive")); err == nil {
+		t.Errorf("Expected error when restoring an invalid snapshot")
+	}
+	if _, err = os.Stat(file); err != nil {
+		t.Errorf("Invalid snapshot should not have replaced %s", r.path)
+	}
+}
+
+func TestRepo_RestoreSymlinkEscape(t *testing.T) {
+	tests := map[string][]tar.Header{
+		"file through a symlinked folder": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "%s"},
+			{Name: "evil/pwned", Typeflag: tar.TypeReg, Mode: 0600, Size: 5},
+		},
+		"file over a symlink": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "%s/pwned"},
+			{Name: "evil", Typeflag: tar.TypeReg, Mode: 0600, Size: 5},
+		},
+	}
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fedbox-fs-backup")
+			if err != nil {
+				t.Fatalf("Unable to create temp dir: %s", err)
+			}
+			defer os.RemoveAll(dir)
+			outside, err := ioutil.TempDir("", "fedbox-fs-outside")
+			if err != nil {
+				t.Fatalf("Unable to create temp dir: %s", err)
+			}
+			defer os.RemoveAll(outside)
+
+			r, err := New(Config{StoragePath: dir, BaseURL: "https://example.com"})
+			if err != nil {
+				t.Fatalf("Unable to create fs storage: %s", err)
+			}
+			buf := bytes.Buffer{}
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range headers {
+				if hdr.Typeflag == tar.TypeSymlink {
+					hdr.Linkname = fmt.Sprintf(hdr.Linkname, outside)
+				}
+				if err = tw.WriteHeader(&hdr); err != nil {
+					t.Fatalf("Unable to write archive: %s", err)
+				}
+				if hdr.Typeflag == tar.TypeReg {
+					tw.Write([]byte("pwned"))
+				}
+			}
+			tw.Close()
+
+			if err = r.Restore(&buf); err == nil {
+				t.Errorf("Expected error when restoring an archive which writes through a symlink")
+			}
+			if _, err = os.Stat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+				t.Errorf("Restore wrote outside the storage folder")
+			}
+		})
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/jsonld"
@@ -72,6 +74,13 @@ type repo struct {
 	errFn         loggerFn
 }
 
+// SetCacheEnabled enables or disables the in memory cache of the loaded objects
+func (r *repo) SetCacheEnabled(enabled bool) {
+	if c, ok := r.cache.(interface{ SetEnabled(bool) }); ok {
+		c.SetEnabled(enabled)
+	}
+}
+
 // Open
 func (r *repo) Open() error {
 	if r.opened {
@@ -107,6 +116,7 @@ func (r *repo) CreateService(service pub.Service) error {
 
 // Load
 func (r *repo) Load(i pub.IRI) (pub.Item, error) {
+	defer metrics.StorageTimer("fs", "Load")()
 	err := r.Open()
 	defer r.Close()
 	if err != nil {
@@ -133,6 +143,7 @@ func (r *repo) Create(col pub.CollectionInterface) (pub.CollectionInterface, err
 
 // Save
 func (r *repo) Save(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("fs", "Save")()
 	err := r.Open()
 	if err != nil {
 		return nil, err
@@ -209,6 +220,7 @@ func isHardLink(fi os.FileInfo) bool {
 
 // AddTo
 func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
+	defer metrics.StorageTimer("fs", "AddTo")()
 	err := r.Open()
 	defer r.Close()
 	if err != nil {
@@ -239,7 +251,8 @@ func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
 	fullLink := path.Join(linkPath, path.Base(itPath))
 
 	// we create a symlink to the persisted object in the current collection
-	return onCollection(r, col, it, func(p string) error {
+	added := false
+	err = onCollection(r, col, it, func(p string) error {
 		err := mkDirIfNotExists(p)
 		if err != nil {
 			return errors.Annotatef(err, "Unable to create collection folder %s", p)
@@ -272,12 +285,21 @@ func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
 
 		// NOTE(marius): we can't use hard links as we're linking to folders :(
 		// This would have been tremendously easier (as in, not having to compute paths) with hard-links.
-		return os.Symlink(itPath, fullLink)
+		if err := os.Symlink(itPath, fullLink); err != nil {
+			return err
+		}
+		added = true
+		return nil
 	})
+	if err == nil && added {
+		events.Publish(col, it.GetLink())
+	}
+	return err
 }
 
 // Delete
 func (r *repo) Delete(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("fs", "Delete")()
 	err := r.Open()
 	defer r.Close()
 	if err != nil {
@@ -326,10 +348,10 @@ func (r *repo) PasswordSet(it pub.Item, pw []byte) error {
 	if err != nil {
 		return errors.Annotatef(err, "could not generate pw hash")
 	}
-	m := storage.Metadata{
-		Pw: pw,
-	}
-	return r.SaveMetadata(m, it.GetLink())
+	_, err = storage.UpdateMetadata(r, it.GetLink(), func(m *storage.Metadata) {
+		m.Pw = pw
+	})
+	return err
 }
 
 // PasswordCheck
@@ -355,8 +377,11 @@ func (r *repo) LoadMetadata(iri pub.IRI) (*storage.Metadata, error) {
 	m := new(storage.Metadata)
 	p := r.itemPath(iri)
 	raw, err := loadRawFromPath(getMetadataKey(p))
+	if os.IsNotExist(err) {
+		return nil, errors.NewNotFound(err, "Could not find metadata in path %s", p)
+	}
 	if err != nil {
-		return nil, errors.Annotatef(err, "Could not find metadata in path %s", p)
+		return nil, errors.Annotatef(err, "Could not load metadata in path %s", p)
 	}
 	err = decodeFn(raw, m)
 	if err != nil {
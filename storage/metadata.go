@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"sync"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// metadataMu serializes the metadata updates, as they load the metadata and save all of it back
+var metadataMu sync.Mutex
+
+// UpdateMetadata loads the metadata of iri, changes it with fn and saves it back. The updates made through it
+// are serialized, so the concurrent ones, like a failed login and a password change, don't overwrite each other.
+// When the metadata doesn't exist yet it's created, but when it can't be loaded for any other reason nothing
+// is saved, so the password, the second factor secrets and the rest of it aren't replaced with empty values.
+func UpdateMetadata(repo MetadataTyper, iri pub.IRI, fn func(m *Metadata)) (*Metadata, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	m, err := repo.LoadMetadata(iri)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, errors.Annotatef(err, "unable to load the metadata of %s", iri)
+	}
+	if err != nil || m == nil {
+		m = new(Metadata)
+	}
+	fn(m)
+	if err = repo.SaveMetadata(*m, iri); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the metadata of %s", iri)
+	}
+	return m, nil
+}
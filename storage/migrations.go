@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// MigrationLog is implemented by the storage backends for the runner of the schema migrations.
+// It keeps the queries of each backend, and the runner keeps the logic they share.
+type MigrationLog interface {
+	// AppliedMigrations returns the versions of the applied migrations, and when they were applied
+	AppliedMigrations() (map[int]time.Time, error)
+	// ApplyMigration runs the migration and records it as applied, in the same transaction
+	ApplyMigration(MigrationStatus) error
+}
+
+// MigrationsStatus returns the status of the migrations, ordered by their version
+func MigrationsStatus(all []Migration, applied map[int]time.Time) []MigrationStatus {
+	result := make([]MigrationStatus, 0, len(all))
+	for _, m := range all {
+		st := MigrationStatus{Migration: m}
+		st.AppliedAt, st.Applied = applied[m.Version]
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result
+}
+
+// RunMigrations applies, in the order of their versions, the migrations which are still pending.
+// It stops at the first one which fails, and returns the ones applied before it.
+func RunMigrations(l MigrationLog, all []Migration) ([]MigrationStatus, error) {
+	applied, err := l.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	done := make([]MigrationStatus, 0)
+	for _, st := range MigrationsStatus(all, applied) {
+		if st.Applied {
+			continue
+		}
+		st.AppliedAt = time.Now().UTC()
+		if err := l.ApplyMigration(st); err != nil {
+			return done, err
+		}
+		st.Applied = true
+		done = append(done, st)
+	}
+	return done, nil
+}
+
+// LoadMigrationsStatus returns the status of all the migrations, as recorded by the backend
+func LoadMigrationsStatus(l MigrationLog, all []Migration) ([]MigrationStatus, error) {
+	applied, err := l.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return MigrationsStatus(all, applied), nil
+}
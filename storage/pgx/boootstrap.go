@@ -7,6 +7,7 @@ import (
 	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/config"
 	"github.com/go-ap/fedbox/internal/log"
+	"github.com/go-ap/fedbox/storage"
 	"github.com/jackc/pgx"
 	"github.com/sirupsen/logrus"
 )
@@ -82,20 +83,7 @@ func Bootstrap(opt config.Options, rootUser string, rootPw []byte) error {
 		return err
 	}
 	defer conn.Close()
-	err = exec(createActivityPubObjects)
-	if err != nil {
-		return err
-	}
-	err = exec(createActivityPubActivities)
-	if err != nil {
-		return err
-	}
-	err = exec(createActivityPubActors)
-	if err != nil {
-		return err
-	}
-	err = exec(createActivityPubCollections)
-	if err != nil {
+	if _, err = storage.RunMigrations(migrationLog{conn}, migrations); err != nil {
 		return err
 	}
 	return nil
@@ -84,7 +84,7 @@ create table instances
 ); `
 
 createActivityPubActors = `
-create table actors (
+create table if not exists actors (
   "id" serial not null constraint actors_pkey primary key,
   "key" char(32) constraint actors_key_key unique,
   "account_id" int default NULL, -- the account for this actor
@@ -108,7 +108,7 @@ create table actors (
 ); `
 
 createActivityPubActivities = `
-create table activities (
+create table if not exists activities (
   "id" serial not null constraint activities_pkey primary key,
   "key" char(32) constraint activities_key_key unique,
   "pub_id" varchar, -- the activitypub Object ID
@@ -123,7 +123,7 @@ create table activities (
 ); `
 
 createActivityPubObjects = `
-create table objects (
+create table if not exists objects (
   "id" serial not null constraint objects_pkey primary key,
   "key" char(32) constraint objects_key_key unique,
   "pub_id" varchar, -- the activitypub Object ID
@@ -134,8 +134,38 @@ create table objects (
   "updated" timestamp default CURRENT_TIMESTAMP
 ); `
 
+createActivityPubColumns = `
+alter table actors add column if not exists "iri" varchar;
+alter table actors add column if not exists "raw" jsonb;
+alter table actors add column if not exists "created_at" timestamp default CURRENT_TIMESTAMP;
+alter table actors add column if not exists "updated_at" timestamp default CURRENT_TIMESTAMP;
+alter table activities add column if not exists "iri" varchar;
+alter table activities add column if not exists "type" varchar;
+alter table activities add column if not exists "raw" jsonb;
+alter table activities add column if not exists "created_at" timestamp default CURRENT_TIMESTAMP;
+alter table activities add column if not exists "updated_at" timestamp default CURRENT_TIMESTAMP;
+alter table objects add column if not exists "iri" varchar;
+alter table objects add column if not exists "raw" jsonb;
+alter table objects add column if not exists "created_at" timestamp default CURRENT_TIMESTAMP;
+alter table objects add column if not exists "updated_at" timestamp default CURRENT_TIMESTAMP;
+alter table collections add column if not exists "type" varchar;
+alter table collections add column if not exists "created_at" timestamp default CURRENT_TIMESTAMP;
+alter table collections add column if not exists "updated_at" timestamp default CURRENT_TIMESTAMP;
+alter table collections add column if not exists "elements" varchar[] default '{}';
+alter table collections add column if not exists "count" int default 0;
+alter table collections alter column "collection" drop not null;
+`
+
+createMigrations = `
+create table if not exists schema_migrations (
+  "version" int not null constraint schema_migrations_pkey primary key,
+  "name" varchar,
+  "applied_at" timestamptz default CURRENT_TIMESTAMP
+);
+`
+
 createActivityPubCollections = `
-create table collections (
+create table if not exists collections (
   "id" serial not null constraint collections_pkey primary key,
   "collection" varchar not null,
   "iri" varchar not null
@@ -0,0 +1,99 @@
+// +build storage_pgx storage_all !storage_boltdb,!storage_fs,!storage_badger,!storage_sqlite
+
+package pgx
+
+import (
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/storage"
+	"github.com/jackc/pgx"
+	"time"
+)
+
+// migrations holds the ordered list of schema changes for the postgres storage.
+// New entries must only be appended, with a version greater than the last one.
+var migrations = []storage.Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      createActivityPubObjects + createActivityPubActivities + createActivityPubActors + createActivityPubCollections,
+	},
+	{
+		Version: 2,
+		Name:    "iri, raw and timestamp columns",
+		Up:      createActivityPubColumns,
+	},
+}
+
+// execQuerier is the common part of pgx.Conn and pgx.ConnPool that the migrations use
+type execQuerier interface {
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+	Begin() (*pgx.Tx, error)
+}
+
+// Migrate applies the pending schema migrations
+func (r *repo) Migrate() ([]storage.MigrationStatus, error) {
+	if r.conn == nil {
+		if err := r.Open(); err != nil {
+			return nil, err
+		}
+		// NOTE(marius): the connections opened for the migrations are closed, the ones of the running instance are left open
+		defer r.Close()
+	}
+	return storage.RunMigrations(migrationLog{r.conn}, migrations)
+}
+
+// MigrationStatus returns the status of the known schema migrations
+func (r *repo) MigrationStatus() ([]storage.MigrationStatus, error) {
+	if r.conn == nil {
+		if err := r.Open(); err != nil {
+			return nil, err
+		}
+		defer r.Close()
+	}
+	return storage.LoadMigrationsStatus(migrationLog{r.conn}, migrations)
+}
+
+// migrationLog records the applied migrations in the schema_migrations table
+type migrationLog struct {
+	conn execQuerier
+}
+
+func (l migrationLog) AppliedMigrations() (map[int]time.Time, error) {
+	if _, err := l.conn.Exec(createMigrations); err != nil {
+		return nil, errors.Annotatef(err, "unable to create migrations table")
+	}
+	rows, err := l.conn.Query("SELECT version, applied_at FROM schema_migrations ORDER BY version;")
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load applied migrations")
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int32
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, errors.Annotatef(err, "scan values error")
+		}
+		applied[int(version)] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (l migrationLog) ApplyMigration(st storage.MigrationStatus) error {
+	tx, err := l.conn.Begin()
+	if err != nil {
+		return errors.Annotatef(err, "unable to start transaction for migration %d", st.Version)
+	}
+	if _, err = tx.Exec(st.Up); err != nil {
+		tx.Rollback()
+		return errors.Annotatef(err, "unable to apply migration %d: %s", st.Version, st.Name)
+	}
+	ins := "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3);"
+	if _, err = tx.Exec(ins, st.Version, st.Name, st.AppliedAt); err != nil {
+		tx.Rollback()
+		return errors.Annotatef(err, "unable to save migration %d", st.Version)
+	}
+	return tx.Commit()
+}
@@ -7,7 +7,9 @@ import (
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/events"
 	"github.com/go-ap/fedbox/internal/log"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/jsonld"
@@ -72,6 +74,7 @@ func New(conf Config, url string, lp logrus.FieldLogger) (*repo, error) {
 }
 
 func (r repo) Load(i pub.IRI) (pub.Item, error) {
+	defer metrics.StorageTimer("postgres", "Load")()
 	f, err := ap.FiltersFromIRI(i)
 	if err != nil {
 		return nil, err
@@ -172,6 +175,7 @@ func loadFromDb(conn *pgx.ConnPool, table string, f s.Filterable) (pub.ItemColle
 
 // Save
 func (r repo) Save(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("postgres", "Save")()
 	if pub.IsNil(it) {
 		return it, errors.Newf("not saving nil item")
 	}
@@ -262,6 +266,7 @@ func (r repo) RemoveFrom(col pub.IRI, it pub.Item) error {
 
 // AddTo
 func (r repo) AddTo(col pub.IRI, it pub.Item) error {
+	defer metrics.StorageTimer("postgres", "AddTo")()
 	if pub.IsNil(it) {
 		return errors.Newf("unable to add nil element to collection")
 	}
@@ -295,6 +300,7 @@ func (r repo) AddTo(col pub.IRI, it pub.Item) error {
 		}, "query error")
 		return errors.Annotatef(err, "query error, Invalid updated rows")
 	}
+	events.Publish(col, it.GetLink())
 
 	return nil
 }
@@ -365,6 +371,7 @@ func (r repo) updateItem(table string, it pub.Item) (pub.Item, error) {
 
 // Delete
 func (r repo) Delete(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("postgres", "Delete")()
 	if pub.IsNil(it) {
 		return it, errors.Newf("not saving nil item")
 	}
@@ -440,7 +447,11 @@ func (r *repo) Open() error {
 
 // Close closes the underlying db connections
 func (r *repo) Close() error {
+	if r.conn == nil {
+		return nil
+	}
 	r.conn.Close()
+	r.conn = nil
 	return nil
 }
 
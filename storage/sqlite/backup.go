@@ -0,0 +1,81 @@
+// +build storage_sqlite storage_all !sqlite_fs,!storage_boltdb,!storage_badger,!storage_pgx
+
+package sqlite
+
+import (
+	"database/sql"
+	"github.com/go-ap/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Backup writes a consistent snapshot of the sqlite database to w.
+// It uses VACUUM INTO, which copies the database in a single read transaction.
+func (r *repo) Backup(w io.Writer) error {
+	tmp, err := ioutil.TempDir("", "fedbox-sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	snapshot := filepath.Join(tmp, filepath.Base(r.path))
+
+	if err := r.Open(); err != nil {
+		return err
+	}
+	_, err = r.conn.Exec("VACUUM INTO ?;", snapshot)
+	r.Close()
+	if err != nil {
+		return errors.Annotatef(err, "unable to snapshot %s", r.path)
+	}
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Restore replaces the sqlite database with the snapshot read from rd.
+// The snapshot is written next to the database and checked before replacing it.
+func (r *repo) Restore(rd io.Reader) error {
+	tmp := r.path + ".restore"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotatef(err, "could not create %s", tmp)
+	}
+	if _, err = io.Copy(f, rd); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Annotatef(err, "could not write %s", tmp)
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = checkIntegrity(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Remove(r.path + "-wal")
+	os.Remove(r.path + "-shm")
+	return os.Rename(tmp, r.path)
+}
+
+func checkIntegrity(path string) error {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var result string
+	if err = conn.QueryRow("PRAGMA integrity_check;").Scan(&result); err != nil {
+		return errors.Annotatef(err, "invalid sqlite snapshot")
+	}
+	if result != "ok" {
+		return errors.Newf("invalid sqlite snapshot: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// +build storage_sqlite storage_all !sqlite_fs,!storage_boltdb,!storage_badger,!storage_pgx
+
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepo_BackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-sqlite-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := repo{path: filepath.Join(dir, "src.sqlite"), logFn: defaultLogFn, errFn: defaultLogFn}
+	if _, err = src.Migrate(); err != nil {
+		t.Fatalf("Unable to create the schema: %s", err)
+	}
+	iri := "https://example.com/objects/1"
+	conn, err := sql.Open("sqlite", src.path)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", src.path, err)
+	}
+	_, err = conn.Exec(`INSERT INTO collections ("iri", "object") VALUES (?, ?);`, "https://example.com/objects", iri)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Unable to insert into %s: %s", src.path, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err = src.Backup(&buf); err != nil {
+		t.Fatalf("Unable to backup %s: %s", src.path, err)
+	}
+	dst := repo{path: filepath.Join(dir, "dst.sqlite"), logFn: defaultLogFn, errFn: defaultLogFn}
+	if err = dst.Restore(&buf); err != nil {
+		t.Fatalf("Unable to restore to %s: %s", dst.path, err)
+	}
+	if _, err = os.Stat(dst.path + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("Temporary restore file was not removed")
+	}
+
+	conn, err = sql.Open("sqlite", dst.path)
+	if err != nil {
+		t.Fatalf("Unable to open restored %s: %s", dst.path, err)
+	}
+	defer conn.Close()
+	var got string
+	if err = conn.QueryRow(`SELECT "object" FROM collections;`).Scan(&got); err != nil || got != iri {
+		t.Errorf("Restored value is %q, expected %q: %v", got, iri, err)
+	}
+}
+
+func TestRepo_RestoreInvalidSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-sqlite-backup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := repo{path: filepath.Join(dir, "dst.sqlite"), logFn: defaultLogFn, errFn: defaultLogFn}
+	if err = dst.Restore(bytes.NewBufferString("not a sqlite database")); err == nil {
+		t.Errorf("Expected error when restoring an invalid snapshot")
+	}
+	if _, err = os.Stat(dst.path); !os.IsNotExist(err) {
+		t.Errorf("Invalid snapshot should not have replaced %s", dst.path)
+	}
+}
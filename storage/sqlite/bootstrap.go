@@ -50,16 +50,7 @@ func Bootstrap(conf config.Options) error {
 		return nil
 	}
 
-	if err = exec(createObjectsQuery); err != nil {
-		return err
-	}
-	if err = exec(createActivitiesQuery); err != nil {
-		return err
-	}
-	if err = exec(createActorsQuery); err != nil {
-		return err
-	}
-	if err = exec(createCollectionsQuery); err != nil {
+	if _, err = r.Migrate(); err != nil {
 		return err
 	}
 	if err = exec(tuneQuery); err != nil {
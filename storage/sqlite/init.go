@@ -5,7 +5,7 @@ package sqlite
 const (
 
 createActorsQuery = `
-create table actors (
+create table if not exists actors (
   "id" integer constraint actors_pkey primary key,
   "iri" varchar constraint actors_key_key unique,
   "type" varchar not null,
@@ -20,7 +20,7 @@ create table actors (
 );`
 
 createActivitiesQuery = `
-create table activities (
+create table if not exists activities (
   "id" integer constraint activities_pkey primary key,
   "iri" varchar constraint activities_key_key unique,
   "type" varchar not null,
@@ -37,7 +37,7 @@ create table activities (
 );`
 
 createObjectsQuery = `
-create table objects (
+create table if not exists objects (
   "id" integer constraint objects_pkey primary key,
   "iri" varchar constraint objects_key_key unique,
   "type" varchar not null,
@@ -53,13 +53,24 @@ create table objects (
 );`
 
 createCollectionsQuery = `
-create table collections (
+create table if not exists collections (
   "id" integer constraint collections_pkey primary key, 
   "published" timestamp default CURRENT_TIMESTAMP,
   "iri" varchar,
   "object" varchar
 );`
 
+createCollectionsIndexQuery = `
+create index if not exists collections_iri_idx on collections ("iri");
+create index if not exists collections_object_idx on collections ("object");`
+
+createMigrationsQuery = `
+create table if not exists schema_migrations (
+  "version" integer constraint schema_migrations_pkey primary key,
+  "name" varchar,
+  "applied_at" timestamp default CURRENT_TIMESTAMP
+);`
+
 tuneQuery = `
 -- Use WAL mode (writers don't block readers):
 -- PRAGMA journal_mode = 'WAL';
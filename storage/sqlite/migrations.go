@@ -0,0 +1,98 @@
+// +build storage_sqlite storage_all !sqlite_fs,!storage_boltdb,!storage_badger,!storage_pgx
+
+package sqlite
+
+import (
+	"database/sql"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/storage"
+	"time"
+)
+
+// migrations holds the ordered list of schema changes for the sqlite storage.
+// New entries must only be appended, with a version greater than the last one.
+var migrations = []storage.Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      createObjectsQuery + createActivitiesQuery + createActorsQuery + createCollectionsQuery,
+	},
+	{
+		Version: 2,
+		Name:    "collections indexes",
+		Up:      createCollectionsIndexQuery,
+	},
+}
+
+// Migrate applies the pending schema migrations
+func (r *repo) Migrate() ([]storage.MigrationStatus, error) {
+	if err := r.Open(); err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return storage.RunMigrations(migrationLog{r.conn}, migrations)
+}
+
+// MigrationStatus returns the status of the known schema migrations
+func (r *repo) MigrationStatus() ([]storage.MigrationStatus, error) {
+	if err := r.Open(); err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return storage.LoadMigrationsStatus(migrationLog{r.conn}, migrations)
+}
+
+// migrationLog records the applied migrations in the schema_migrations table
+type migrationLog struct {
+	conn *sql.DB
+}
+
+func (l migrationLog) AppliedMigrations() (map[int]time.Time, error) {
+	if _, err := l.conn.Exec(createMigrationsQuery); err != nil {
+		return nil, errors.Annotatef(err, "unable to create migrations table")
+	}
+	rows, err := l.conn.Query("SELECT version, applied_at FROM schema_migrations ORDER BY version;")
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load applied migrations")
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, errors.Annotatef(err, "scan values error")
+		}
+		applied[version] = parseTimestamp(appliedAt)
+	}
+	return applied, rows.Err()
+}
+
+func (l migrationLog) ApplyMigration(st storage.MigrationStatus) error {
+	tx, err := l.conn.Begin()
+	if err != nil {
+		return errors.Annotatef(err, "unable to start transaction for migration %d", st.Version)
+	}
+	if _, err = tx.Exec(st.Up); err != nil {
+		tx.Rollback()
+		return errors.Annotatef(err, "unable to apply migration %d: %s", st.Version, st.Name)
+	}
+	ins := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);"
+	if _, err = tx.Exec(ins, st.Version, st.Name, st.AppliedAt.Format(timestampLayout)); err != nil {
+		tx.Rollback()
+		return errors.Annotatef(err, "unable to save migration %d", st.Version)
+	}
+	return tx.Commit()
+}
+
+const timestampLayout = "2006-01-02 15:04:05"
+
+func parseTimestamp(s string) time.Time {
+	for _, layout := range []string{timestampLayout, time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
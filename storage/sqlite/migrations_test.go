@@ -0,0 +1,132 @@
+// +build storage_sqlite storage_all !sqlite_fs,!storage_boltdb,!storage_badger,!storage_pgx
+
+package sqlite
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ap/fedbox/storage"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "fedbox-sqlite-migrations")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	conn, err := sql.Open("sqlite", filepath.Join(dir, "storage.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unable to open sqlite: %s", err)
+	}
+	return conn, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func tableExists(t *testing.T, conn *sql.DB, name string) bool {
+	var count int
+	q := "SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?;"
+	if err := conn.QueryRow(q, name).Scan(&count); err != nil {
+		t.Fatalf("Unable to query sqlite_master: %s", err)
+	}
+	return count > 0
+}
+
+func TestMigrate_Ordering(t *testing.T) {
+	conn, cleanup := openTestDB(t)
+	defer cleanup()
+
+	// NOTE(marius): the second table references the first one, so they must be created in the order of their versions
+	all := []storage.Migration{
+		{Version: 2, Name: "second", Up: `create table second (first_id integer references first(id));
+insert into second (first_id) select id from first;`},
+		{Version: 1, Name: "first", Up: `create table first (id integer primary key);
+insert into first (id) values (1);`},
+	}
+	done, err := storage.RunMigrations(migrationLog{conn}, all)
+	if err != nil {
+		t.Fatalf("RunMigrations() error = %s", err)
+	}
+	if len(done) != 2 || done[0].Version != 1 || done[1].Version != 2 {
+		t.Fatalf("RunMigrations() applied %v, expected the versions 1 and 2 in order", done)
+	}
+	var count int
+	if err = conn.QueryRow("SELECT count(*) FROM second;").Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected the second migration to see the rows of the first one, got %d rows: %v", count, err)
+	}
+
+	// running them again does nothing
+	done, err = storage.RunMigrations(migrationLog{conn}, all)
+	if err != nil {
+		t.Fatalf("RunMigrations() second run error = %s", err)
+	}
+	if len(done) != 0 {
+		t.Errorf("RunMigrations() second run applied %v, expected nothing", done)
+	}
+	status, err := storage.LoadMigrationsStatus(migrationLog{conn}, all)
+	if err != nil {
+		t.Fatalf("LoadMigrationsStatus() error = %s", err)
+	}
+	for _, st := range status {
+		if !st.Applied || st.AppliedAt.IsZero() {
+			t.Errorf("migration %d is not recorded as applied: %#v", st.Version, st)
+		}
+	}
+}
+
+func TestMigrate_RollsBackFailedStep(t *testing.T) {
+	conn, cleanup := openTestDB(t)
+	defer cleanup()
+
+	all := []storage.Migration{
+		{Version: 1, Name: "valid", Up: `create table valid (id integer);`},
+		{Version: 2, Name: "broken", Up: `create table partial (id integer);
+insert into missing_table (id) values (1);`},
+		{Version: 3, Name: "after", Up: `create table after (id integer);`},
+	}
+	done, err := storage.RunMigrations(migrationLog{conn}, all)
+	if err == nil {
+		t.Fatalf("RunMigrations() expected an error for the broken migration")
+	}
+	if len(done) != 1 || done[0].Version != 1 {
+		t.Errorf("RunMigrations() applied %v, expected only version 1", done)
+	}
+	if tableExists(t, conn, "partial") {
+		t.Errorf("the table created by the failed migration should have been rolled back")
+	}
+	if tableExists(t, conn, "after") {
+		t.Errorf("the migrations after the failed one should not run")
+	}
+	status, err := storage.LoadMigrationsStatus(migrationLog{conn}, all)
+	if err != nil {
+		t.Fatalf("LoadMigrationsStatus() error = %s", err)
+	}
+	if !status[0].Applied || status[1].Applied || status[2].Applied {
+		t.Errorf("unexpected migrations status %v", status)
+	}
+}
+
+func TestRepo_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fedbox-sqlite-migrations")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := repo{path: filepath.Join(dir, "storage.sqlite"), logFn: defaultLogFn, errFn: defaultLogFn}
+	done, err := r.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate() error = %s", err)
+	}
+	if len(done) != len(migrations) {
+		t.Errorf("Migrate() applied %d migrations, expected %d", len(done), len(migrations))
+	}
+	if done, err = r.Migrate(); err != nil || len(done) != 0 {
+		t.Errorf("Migrate() second run applied %v: %v, expected nothing", done, err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/fedbox/internal/events"
+	"github.com/go-ap/fedbox/internal/metrics"
 	"github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/handlers"
 	"github.com/go-ap/jsonld"
@@ -58,6 +60,13 @@ type repo struct {
 	errFn   loggerFn
 }
 
+// SetCacheEnabled enables or disables the in memory cache of the loaded objects
+func (r *repo) SetCacheEnabled(enabled bool) {
+	if c, ok := r.cache.(interface{ SetEnabled(bool) }); ok {
+		c.SetEnabled(enabled)
+	}
+}
+
 // Open
 func (r *repo) Open() error {
 	var err error
@@ -67,6 +76,9 @@ func (r *repo) Open() error {
 
 // Close
 func (r *repo) Close() error {
+	if r.conn == nil {
+		return nil
+	}
 	return r.conn.Close()
 }
 
@@ -125,6 +137,7 @@ func getCollectionTableFromFilter(f *ap.Filters) handlers.CollectionType {
 
 // Load
 func (r *repo) Load(i pub.IRI) (pub.Item, error) {
+	defer metrics.StorageTimer("sqlite", "Load")()
 	f, err := ap.FiltersFromIRI(i)
 	if err != nil {
 		return nil, err
@@ -138,6 +151,7 @@ func (r *repo) Load(i pub.IRI) (pub.Item, error) {
 
 // Save
 func (r *repo) Save(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("sqlite", "Save")()
 	if err := r.Open(); err != nil {
 		return nil, err
 	}
@@ -174,6 +188,7 @@ func (r *repo) RemoveFrom(col pub.IRI, it pub.Item) error {
 
 // AddTo
 func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
+	defer metrics.StorageTimer("sqlite", "AddTo")()
 	if err := r.Open(); err != nil {
 		return err
 	}
@@ -184,12 +199,14 @@ func (r *repo) AddTo(col pub.IRI, it pub.Item) error {
 		r.errFn("query error: %s\n%s\n%#v", err, query)
 		return errors.Annotatef(err, "query error")
 	}
+	events.Publish(col, it.GetLink())
 
 	return nil
 }
 
 // Delete
 func (r *repo) Delete(it pub.Item) (pub.Item, error) {
+	defer metrics.StorageTimer("sqlite", "Delete")()
 	err := r.Open()
 	defer r.Close()
 	if err != nil {
@@ -238,10 +255,10 @@ func (r *repo) PasswordSet(it pub.Item, pw []byte) error {
 	if err != nil {
 		return errors.Annotatef(err, "could not generate pw hash")
 	}
-	m := storage.Metadata{
-		Pw: pw,
-	}
-	return r.SaveMetadata(m, it.GetLink())
+	_, err = storage.UpdateMetadata(r, it.GetLink(), func(m *storage.Metadata) {
+		m.Pw = pw
+	})
+	return err
 }
 
 // PasswordCheck
@@ -266,6 +283,9 @@ func (r *repo) LoadMetadata(iri pub.IRI) (*storage.Metadata, error) {
 
 	m := new(storage.Metadata)
 	raw, err := loadMetadataFromTable(r.conn, iri)
+	if err == sql.ErrNoRows || (err == nil && len(raw) == 0) {
+		return nil, errors.NotFoundf("no metadata for %s", iri)
+	}
 	if err != nil {
 		return nil, err
 	}
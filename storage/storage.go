@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"io"
+	"time"
+
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/storage"
 )
@@ -16,8 +19,47 @@ type PasswordChanger interface {
 
 type Metadata struct {
 	Pw []byte `json:"pw"`
+	// AlsoKnownAs are the other IRIs of the actor, from which it accepts to be moved
+	AlsoKnownAs pub.IRIs `json:"alsoKnownAs,omitempty"`
+	// MovedTo is the IRI of the actor this one moved to
+	MovedTo pub.IRI `json:"movedTo,omitempty"`
+	// FailedLogins is the number of failed login attempts since the last successful one
+	FailedLogins int `json:"failedLogins,omitempty"`
+	// LockedUntil is the time until which the logins are refused, after too many failed attempts
+	LockedUntil time.Time `json:"lockedUntil,omitempty"`
+	// Audit are the most recent logins, token grants and password changes of the account
+	Audit []AuditEntry `json:"audit,omitempty"`
+	// TOTPSecret is the secret of the one time passwords, when the second factor authentication is enabled
+	TOTPSecret string `json:"totpSecret,omitempty"`
+	// TOTPPendingSecret is the secret being enrolled, which is enabled after the first code is verified
+	TOTPPendingSecret string `json:"totpPendingSecret,omitempty"`
+	// TOTPLastCounter is the time step of the last accepted code, so each code can be used only once
+	TOTPLastCounter uint64 `json:"totpLastCounter,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// AuditEntry is an event in the audit log of an account
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	IP     string    `json:"ip,omitempty"`
+	Client string    `json:"client,omitempty"`
+	Detail string    `json:"detail,omitempty"`
 }
 
+// The events of the audit log
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login-failed"
+	AuditLocked         = "locked"
+	AuditTokenGrant     = "token-grant"
+	AuditPasswordChange = "password-change"
+	AuditTOTPEnabled    = "2fa-enabled"
+	AuditTOTPReset      = "2fa-reset"
+	AuditRecoveryCode   = "recovery-code"
+)
+
 type MetadataTyper interface {
 	LoadMetadata (pub.IRI) (*Metadata, error)
 	SaveMetadata (Metadata, pub.IRI) error
@@ -32,4 +74,34 @@ type Resetter interface {
 	Reset()
 }
 
+// Migration is a versioned change to the schema of a storage backend
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// MigrationStatus shows if a Migration has been applied to a storage backend, and when
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by the storage backends that have a versioned schema
+type Migrator interface {
+	// Migrate applies, in order, the migrations which are still pending and returns them
+	Migrate() ([]MigrationStatus, error)
+	// MigrationStatus returns the status of all the migrations known to the backend
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// Backuper is implemented by the storage backends which can take a consistent snapshot of their data
+type Backuper interface {
+	// Backup writes a consistent snapshot of the storage to w
+	Backup(w io.Writer) error
+	// Restore replaces the contents of the storage with the snapshot read from r
+	Restore(r io.Reader) error
+}
+
 type OptionFn func(s storage.Store) error
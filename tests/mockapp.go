@@ -119,7 +119,7 @@ func seedTestData(t *testing.T, testData []string) {
 	}
 	addMockObjects(db, mocks, t.Errorf)
 
-	tok, err := o.GenAuthToken(clientCode, defaultTestAccount.Id, nil)
+	tok, err := o.GenAuthToken(clientCode, defaultTestAccount.Id, app.DefaultScope, 24*time.Hour, nil)
 	if err == nil {
 		defaultTestAccount.AuthToken = tok
 	}